@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,3 +17,25 @@ func Config(key string) string {
 
 	return os.Getenv(key)
 }
+
+// Duration reads a duration (e.g. "15m", "720h") from the environment,
+// falling back to the given default when the variable is unset or invalid.
+func Duration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
+
+// Int reads a positive integer from the environment, falling back to the
+// given default when the variable is unset or invalid.
+func Int(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+
+	return value
+}
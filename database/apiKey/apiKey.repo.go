@@ -0,0 +1,56 @@
+package apiKeyRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func FindAPIKeysByUserId(userId uuid.UUID) ([]model.APIKey, error) {
+	var apiKeys []model.APIKey
+	db := database.DB
+	err := db.Preload("Roles").Where("user_id = ?", userId).Order("created_at").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func FindAPIKeysByOrgId(orgId uuid.UUID) ([]model.APIKey, error) {
+	var apiKeys []model.APIKey
+	db := database.DB
+	err := db.Preload("Roles").Where("org_id = ?", orgId).Order("created_at").Find(&apiKeys).Error
+	return apiKeys, err
+}
+
+func FindAPIKeyById(id uuid.UUID) (model.APIKey, error) {
+	var apiKey model.APIKey
+	db := database.DB
+	err := db.Preload("Roles").Where("id = ?", id).First(&apiKey).Error
+	return apiKey, err
+}
+
+func FindAPIKeyByHash(hash string) (model.APIKey, error) {
+	var apiKey model.APIKey
+	db := database.DB
+	err := db.Preload("Roles").Where("key_hash = ?", hash).First(&apiKey).Error
+	return apiKey, err
+}
+
+func CreateAPIKey(apiKey model.APIKey) (model.APIKey, error) {
+	db := database.DB
+	err := db.Create(&apiKey).Error
+	return apiKey, err
+}
+
+func UpdateAPIKey(apiKey model.APIKey) (model.APIKey, error) {
+	db := database.DB
+	err := db.Omit("Roles", "User").Save(&apiKey).Error
+	return apiKey, err
+}
+
+// TouchAPIKey records when and from where the key was last used.
+func TouchAPIKey(id uuid.UUID, ip string) error {
+	db := database.DB
+	err := db.Model(&model.APIKey{}).Where("id = ?", id).Updates(map[string]interface{}{"last_used_at": time.Now(), "last_used_ip": ip}).Error
+	return err
+}
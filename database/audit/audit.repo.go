@@ -0,0 +1,27 @@
+package auditRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+
+	"github.com/google/uuid"
+)
+
+func CreateAuditLog(entry model.AuditLog) (model.AuditLog, error) {
+	db := database.DB
+	err := db.Create(&entry).Error
+	return entry, err
+}
+
+// FindAuditLogsByOrgId returns the newest entries of the org first, only
+// those of one action unless action is empty.
+func FindAuditLogsByOrgId(orgId uuid.UUID, action string, limit int) ([]model.AuditLog, error) {
+	var entries []model.AuditLog
+	db := database.DB
+	query := db.Where("org_id = ?", orgId)
+	if action != "" {
+		query = query.Where("action = ?", action)
+	}
+	err := query.Order("created_at desc").Limit(limit).Find(&entries).Error
+	return entries, err
+}
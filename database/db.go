@@ -34,12 +34,21 @@ func Connect() {
 	}
 
 	log.Println("Running database migrations")
-	err = db.AutoMigrate(&model.User{}, &model.Org{}, &model.Role{}, &model.Group{}, &model.Task{})
+	err = db.AutoMigrate(&model.User{}, &model.Org{}, &model.Role{}, &model.Group{}, &model.Task{}, &model.RefreshToken{}, &model.RevokedToken{}, &model.SubjectRevocation{}, &model.SigningKey{}, &model.OAuthClient{}, &model.AuthorizationCode{}, &model.OAuthConsent{}, &model.ServiceAccount{}, &model.APIKey{}, &model.MFAFactor{}, &model.RecoveryCode{}, &model.PasswordResetToken{}, &model.LoginFailure{}, &model.AuditLog{}, &model.PasswordPolicy{}, &model.PasswordHistory{}, &model.Session{}, &model.Invitation{}, &model.IdentityProvider{}, &model.FederatedIdentity{}, &model.LDAPConnector{}, &model.Policy{}, &model.PolicyAttachment{}, &model.RoleCondition{})
 	if err != nil {
 		log.Fatal("Migration failed.\n", err)
 		os.Exit(1)
 	}
 
+	// Role names used to be unique across orgs, now only within an org
+	if db.Migrator().HasIndex(&model.Role{}, "idx_roles_name") {
+		err = db.Migrator().DropIndex(&model.Role{}, "idx_roles_name")
+		if err != nil {
+			log.Fatal("Migration failed.\n", err)
+			os.Exit(1)
+		}
+	}
+
 	DB = db
 	log.Println("Connected successfully to the database")
 }
@@ -0,0 +1,78 @@
+package identityProviderRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func FindIdentityProvidersByOrgId(orgId uuid.UUID) ([]model.IdentityProvider, error) {
+	var providers []model.IdentityProvider
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).Order("created_at").Find(&providers).Error
+	return providers, err
+}
+
+func FindIdentityProviderById(id uuid.UUID) (model.IdentityProvider, error) {
+	var provider model.IdentityProvider
+	db := database.DB
+	err := db.First(&provider, "id = ?", id).Error
+	return provider, err
+}
+
+// CountEnabledIdentityProviders counts the providers users of the org can
+// sign in with.
+func CountEnabledIdentityProviders(orgId uuid.UUID) (int64, error) {
+	var count int64
+	db := database.DB
+	err := db.Model(&model.IdentityProvider{}).Where("org_id = ? AND disabled = ?", orgId, false).Count(&count).Error
+	return count, err
+}
+
+func CreateIdentityProvider(provider model.IdentityProvider) (model.IdentityProvider, error) {
+	db := database.DB
+	err := db.Create(&provider).Error
+	return provider, err
+}
+
+func UpdateIdentityProvider(provider model.IdentityProvider) (model.IdentityProvider, error) {
+	db := database.DB
+	err := db.Save(&provider).Error
+	return provider, err
+}
+
+func DeleteIdentityProvider(provider model.IdentityProvider) error {
+	db := database.DB
+	err := db.Where("provider_id = ?", provider.ID).Delete(&model.FederatedIdentity{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(&provider).Error
+}
+
+func FindFederatedIdentity(providerId uuid.UUID, subject string) (model.FederatedIdentity, error) {
+	var identity model.FederatedIdentity
+	db := database.DB
+	err := db.First(&identity, "provider_id = ? AND subject = ?", providerId, subject).Error
+	return identity, err
+}
+
+func CreateFederatedIdentity(identity model.FederatedIdentity) (model.FederatedIdentity, error) {
+	db := database.DB
+	err := db.Create(&identity).Error
+	return identity, err
+}
+
+func TouchFederatedIdentity(id uuid.UUID, loginAt time.Time) error {
+	db := database.DB
+	err := db.Model(&model.FederatedIdentity{}).Where("id = ?", id).Update("last_login_at", loginAt).Error
+	return err
+}
+
+func DeleteFederatedIdentity(identity model.FederatedIdentity) error {
+	db := database.DB
+	err := db.Delete(&identity).Error
+	return err
+}
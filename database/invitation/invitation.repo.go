@@ -0,0 +1,56 @@
+package invitationRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// UpsertInvitation stores the invitation of a user, replacing the token and
+// expiry of an earlier one.
+func UpsertInvitation(invitation model.Invitation) (model.Invitation, error) {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"token_hash", "invited_by_id", "invited_by_type", "sent_at", "expires_at", "updated_at"}),
+	}).Create(&invitation).Error
+	return invitation, err
+}
+
+func FindInvitationById(id uuid.UUID) (model.Invitation, error) {
+	var invitation model.Invitation
+	db := database.DB
+	err := db.Where("id = ?", id).First(&invitation).Error
+	return invitation, err
+}
+
+func FindInvitationByHash(hash string) (model.Invitation, error) {
+	var invitation model.Invitation
+	db := database.DB
+	err := db.Where("token_hash = ?", hash).First(&invitation).Error
+	return invitation, err
+}
+
+// FindInvitationsByOrgId returns the pending invitations of the org, newest first.
+func FindInvitationsByOrgId(orgId uuid.UUID) ([]model.Invitation, error) {
+	var invitations []model.Invitation
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).Order("sent_at desc").Find(&invitations).Error
+	return invitations, err
+}
+
+// UseInvitation deletes an invitation if its token is still the given one.
+// The bool reports whether it did, so a token is accepted only once.
+func UseInvitation(id uuid.UUID, hash string) (bool, error) {
+	db := database.DB
+	result := db.Where("id = ? AND token_hash = ?", id, hash).Delete(&model.Invitation{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func DeleteInvitation(invitation model.Invitation) error {
+	db := database.DB
+	err := db.Delete(&invitation).Error
+	return err
+}
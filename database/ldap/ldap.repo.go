@@ -0,0 +1,47 @@
+package ldapRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func FindLDAPConnectorByOrgId(orgId uuid.UUID) (model.LDAPConnector, error) {
+	var connector model.LDAPConnector
+	db := database.DB
+	err := db.First(&connector, "org_id = ?", orgId).Error
+	return connector, err
+}
+
+// FindScheduledLDAPConnectors returns the connectors the scheduler syncs.
+func FindScheduledLDAPConnectors() ([]model.LDAPConnector, error) {
+	var connectors []model.LDAPConnector
+	db := database.DB
+	err := db.Where("sync_disabled = ?", false).Find(&connectors).Error
+	return connectors, err
+}
+
+func SaveLDAPConnector(connector model.LDAPConnector) (model.LDAPConnector, error) {
+	db := database.DB
+	err := db.Save(&connector).Error
+	return connector, err
+}
+
+func DeleteLDAPConnector(connector model.LDAPConnector) error {
+	db := database.DB
+	err := db.Delete(&connector).Error
+	return err
+}
+
+// RecordLDAPSync stores the outcome of a sync. An empty message means it
+// succeeded.
+func RecordLDAPSync(id uuid.UUID, syncedAt time.Time, message string) error {
+	db := database.DB
+	err := db.Model(&model.LDAPConnector{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_sync_at":    syncedAt,
+		"last_sync_error": message,
+	}).Error
+	return err
+}
@@ -0,0 +1,56 @@
+package loginFailureRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func FindLoginFailure(key string) (model.LoginFailure, error) {
+	var failure model.LoginFailure
+	db := database.DB
+	err := db.Where("key = ?", key).First(&failure).Error
+	return failure, err
+}
+
+// IncrementLoginFailure counts one more failure for the key. Failures older
+// than windowStart are forgotten and the count starts again at one.
+func IncrementLoginFailure(key string, now time.Time, windowStart time.Time) (model.LoginFailure, error) {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"failures":       gorm.Expr("CASE WHEN login_failures.last_failed_at < ? THEN 1 ELSE login_failures.failures + 1 END", windowStart),
+			"last_failed_at": now,
+			"updated_at":     now,
+		}),
+	}).Create(&model.LoginFailure{Key: key, Failures: 1, LastFailedAt: now}).Error
+	if err != nil {
+		return model.LoginFailure{}, err
+	}
+
+	return FindLoginFailure(key)
+}
+
+func SetLockedUntil(key string, lockedUntil time.Time) error {
+	db := database.DB
+	err := db.Model(&model.LoginFailure{}).Where("key = ?", key).Update("locked_until", lockedUntil).Error
+	return err
+}
+
+func DeleteLoginFailure(key string) error {
+	db := database.DB
+	err := db.Where("key = ?", key).Delete(&model.LoginFailure{}).Error
+	return err
+}
+
+// DeleteStaleLoginFailures removes counters that have not changed since the
+// threshold and are not holding a lock anymore.
+func DeleteStaleLoginFailures(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", threshold, time.Now()).Delete(&model.LoginFailure{}).Error
+	return err
+}
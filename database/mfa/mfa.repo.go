@@ -0,0 +1,90 @@
+package mfaRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func FindFactorBySubject(subjectId uuid.UUID) (model.MFAFactor, error) {
+	var factor model.MFAFactor
+	db := database.DB
+	err := db.Where("subject_id = ?", subjectId).First(&factor).Error
+	return factor, err
+}
+
+func FindConfirmedFactorBySubject(subjectId uuid.UUID) (model.MFAFactor, error) {
+	var factor model.MFAFactor
+	db := database.DB
+	err := db.Where("subject_id = ? AND confirmed_at IS NOT NULL", subjectId).First(&factor).Error
+	return factor, err
+}
+
+// UpsertPendingFactor stores a new unconfirmed secret for the subject,
+// replacing an earlier enrollment that was never confirmed.
+func UpsertPendingFactor(factor model.MFAFactor) (model.MFAFactor, error) {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subject_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "confirmed_at", "last_used_step", "updated_at"}),
+	}).Create(&factor).Error
+	return factor, err
+}
+
+func ConfirmFactor(id uuid.UUID, step int64) error {
+	db := database.DB
+	err := db.Model(&model.MFAFactor{}).Where("id = ?", id).Updates(map[string]interface{}{"confirmed_at": time.Now(), "last_used_step": step}).Error
+	return err
+}
+
+// UseFactorStep records the TOTP step as used. It fails for a step that was
+// already used, so a code cannot be replayed within its validity window.
+func UseFactorStep(id uuid.UUID, step int64) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.MFAFactor{}).Where("id = ? AND last_used_step < ?", id, step).Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+func DeleteFactor(subjectId uuid.UUID) error {
+	db := database.DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subject_id = ?", subjectId).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("subject_id = ?", subjectId).Delete(&model.MFAFactor{}).Error
+	})
+}
+
+// ReplaceRecoveryCodes drops every recovery code of the subject and stores the new ones.
+func ReplaceRecoveryCodes(subjectId uuid.UUID, codeHashes []string) error {
+	db := database.DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subject_id = ?", subjectId).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		codes := []model.RecoveryCode{}
+		for _, codeHash := range codeHashes {
+			codes = append(codes, model.RecoveryCode{SubjectID: subjectId, CodeHash: codeHash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks a matching unused code as used and reports whether there was one.
+func UseRecoveryCode(subjectId uuid.UUID, codeHash string) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.RecoveryCode{}).Where("subject_id = ? AND code_hash = ? AND used_at IS NULL", subjectId, codeHash).Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func CountUnusedRecoveryCodes(subjectId uuid.UUID) (int64, error) {
+	var count int64
+	db := database.DB
+	err := db.Model(&model.RecoveryCode{}).Where("subject_id = ? AND used_at IS NULL", subjectId).Count(&count).Error
+	return count, err
+}
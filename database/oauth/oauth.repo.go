@@ -0,0 +1,101 @@
+package oauthRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func GetClientsByOrgId(orgId uuid.UUID) ([]model.OAuthClient, error) {
+	var clients []model.OAuthClient
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).Find(&clients).Error
+	return clients, err
+}
+
+func GetClientById(id uuid.UUID) (model.OAuthClient, error) {
+	var client model.OAuthClient
+	db := database.DB
+	err := db.First(&client, "id = ?", id).Error
+	return client, err
+}
+
+func GetClientByClientId(clientId string) (model.OAuthClient, error) {
+	var client model.OAuthClient
+	db := database.DB
+	err := db.First(&client, "client_id = ?", clientId).Error
+	return client, err
+}
+
+func CreateClient(client model.OAuthClient) (model.OAuthClient, error) {
+	db := database.DB
+	err := db.Create(&client).Error
+	return client, err
+}
+
+// DeleteClient removes the client together with its consents and pending
+// codes, and ends every login that was delegated to it.
+func DeleteClient(client model.OAuthClient) error {
+	db := database.DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&model.OAuthConsent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ClientID).Delete(&model.AuthorizationCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.RefreshToken{}).Where("client_id = ? AND revoked_at IS NULL", client.ClientID).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Session{}).Where("client_id = ? AND revoked_at IS NULL", client.ClientID).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&client).Error
+	})
+}
+
+func CreateAuthorizationCode(code model.AuthorizationCode) (model.AuthorizationCode, error) {
+	db := database.DB
+	err := db.Create(&code).Error
+	return code, err
+}
+
+func FindAuthorizationCodeByHash(hash string) (model.AuthorizationCode, error) {
+	var code model.AuthorizationCode
+	db := database.DB
+	err := db.First(&code, "code_hash = ?", hash).Error
+	return code, err
+}
+
+// MarkAuthorizationCodeUsed only succeeds once per code.
+func MarkAuthorizationCodeUsed(id uuid.UUID) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.AuthorizationCode{}).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func DeleteExpiredAuthorizationCodes(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ?", threshold).Delete(&model.AuthorizationCode{}).Error
+	return err
+}
+
+func FindConsent(userId uuid.UUID, clientId string) (model.OAuthConsent, error) {
+	var consent model.OAuthConsent
+	db := database.DB
+	err := db.First(&consent, "user_id = ? AND client_id = ?", userId, clientId).Error
+	return consent, err
+}
+
+func UpsertConsent(consent model.OAuthConsent) error {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+	}).Create(&consent).Error
+	return err
+}
@@ -46,6 +46,60 @@ func UpdateOrg(org model.Org) (orgSchema.OrgResponse, error) {
 	return org_, err
 }
 
+func SetRequireMFA(orgId uuid.UUID, required bool) error {
+	db := database.DB
+	err := db.Model(&model.Org{}).Where("id = ?", orgId).Update("require_mfa", required).Error
+	return err
+}
+
+func SetDisablePasswordLogin(orgId uuid.UUID, disabled bool) error {
+	db := database.DB
+	err := db.Model(&model.Org{}).Where("id = ?", orgId).Update("disable_password_login", disabled).Error
+	return err
+}
+
+// UpdateOrgPasswordHash replaces the hash of an unchanged password, e.g.
+// when it is upgraded to a stronger algorithm. It does not touch updated_at.
+func UpdateOrgPasswordHash(id uuid.UUID, oldHash string, newHash string) error {
+	db := database.DB
+	err := db.Model(&model.Org{}).Where("id = ? AND password = ?", id, oldHash).UpdateColumn("password", newHash).Error
+	return err
+}
+
+// LockOrg marks an org root account as locked unless it is deactivated,
+// deleted or not verified yet.
+func LockOrg(id uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.Org{}).Where("id = ? AND account_status NOT IN ?", id, []constants.AccountStatus{constants.DEACTIVATED, constants.DELETED, constants.PENDING_VERIFICATION}).Update("account_status", constants.LOCKED).Error
+	return err
+}
+
+// UnlockOrg reactivates a locked account. It reports whether it was locked.
+func UnlockOrg(id uuid.UUID) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.Org{}).Where("id = ? AND account_status = ?", id, constants.LOCKED).Update("account_status", constants.ACTIVATED)
+	return result.RowsAffected == 1, result.Error
+}
+
+// VerifyOrgEmail activates an org waiting for verification, as long as its
+// email is still the one the link was sent to. It reports whether it did.
+func VerifyOrgEmail(id uuid.UUID, email string) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.Org{}).Where("id = ? AND email = ? AND account_status = ?", id, email, constants.PENDING_VERIFICATION).Updates(map[string]interface{}{
+		"account_status":    constants.ACTIVATED,
+		"email_verified_at": time.Now(),
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkVerificationSent records that a verification link is sent now, unless
+// one was already sent after notBefore. It reports whether a link may be sent.
+func MarkVerificationSent(id uuid.UUID, notBefore time.Time) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.Org{}).Where("id = ? AND account_status = ? AND (verification_sent_at IS NULL OR verification_sent_at < ?)", id, constants.PENDING_VERIFICATION, notBefore).UpdateColumn("verification_sent_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
 func DeleteOrg(org model.Org) (model.Org, error) {
 	db := database.DB
 	err := db.Model(&org).Association("Users").Clear()
@@ -67,6 +121,13 @@ func GetDeletedOrgsForThreshold(threshold time.Time) ([]model.Org, error) {
 	return orgs, err
 }
 
+func GetUnverifiedOrgsForThreshold(threshold time.Time) ([]model.Org, error) {
+	var orgs []model.Org
+	db := database.DB
+	err := db.Where("account_status = ? AND created_at < ?", constants.PENDING_VERIFICATION, threshold).Find(&orgs).Error
+	return orgs, err
+}
+
 func UpdateOrgs(orgs []model.Org) ([]model.Org, error) {
 	db := database.DB
 	err := db.Save(&orgs).Error
@@ -0,0 +1,59 @@
+package passwordRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+func FindPasswordPolicyByOrgId(orgId uuid.UUID) (model.PasswordPolicy, error) {
+	var policy model.PasswordPolicy
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).First(&policy).Error
+	return policy, err
+}
+
+// UpsertPasswordPolicy creates the policy of the org or replaces its rules.
+func UpsertPasswordPolicy(policy model.PasswordPolicy) (model.PasswordPolicy, error) {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "org_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"min_length", "require_uppercase", "require_lowercase", "require_digit", "require_special", "banned_words", "history_depth", "max_age_days", "updated_at"}),
+	}).Create(&policy).Error
+	if err != nil {
+		return policy, err
+	}
+
+	return FindPasswordPolicyByOrgId(policy.OrgID)
+}
+
+func DeletePasswordPolicy(orgId uuid.UUID) error {
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).Delete(&model.PasswordPolicy{}).Error
+	return err
+}
+
+// FindPasswordHistory returns the most recent password hashes of the subject.
+func FindPasswordHistory(subjectId uuid.UUID, limit int) ([]model.PasswordHistory, error) {
+	var history []model.PasswordHistory
+	db := database.DB
+	err := db.Where("subject_id = ?", subjectId).Order("created_at desc").Limit(limit).Find(&history).Error
+	return history, err
+}
+
+func CreatePasswordHistory(entry model.PasswordHistory) (model.PasswordHistory, error) {
+	db := database.DB
+	err := db.Create(&entry).Error
+	return entry, err
+}
+
+// TrimPasswordHistory keeps only the newest entries of the subject.
+func TrimPasswordHistory(subjectId uuid.UUID, keep int) error {
+	db := database.DB
+	err := db.Where("subject_id = ? AND id NOT IN (?)", subjectId,
+		db.Model(&model.PasswordHistory{}).Select("id").Where("subject_id = ?", subjectId).Order("created_at desc").Limit(keep),
+	).Delete(&model.PasswordHistory{}).Error
+	return err
+}
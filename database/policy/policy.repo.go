@@ -0,0 +1,89 @@
+package policyRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+
+	"github.com/google/uuid"
+)
+
+func FindPoliciesByOrgId(orgId uuid.UUID) ([]model.Policy, error) {
+	var policies []model.Policy
+	db := database.DB
+	err := db.Where("org_id = ?", orgId).Order("created_at").Find(&policies).Error
+	return policies, err
+}
+
+func FindPolicyById(id uuid.UUID) (model.Policy, error) {
+	var policy model.Policy
+	db := database.DB
+	err := db.First(&policy, "id = ?", id).Error
+	return policy, err
+}
+
+func FindPolicyByName(orgId uuid.UUID, name string) (model.Policy, error) {
+	var policy model.Policy
+	db := database.DB
+	err := db.First(&policy, "org_id = ? AND name = ?", orgId, name).Error
+	return policy, err
+}
+
+// FindPoliciesForPrincipals loads the policies of the org attached to any of
+// the principals, i.e. a user together with its groups and roles.
+func FindPoliciesForPrincipals(orgId uuid.UUID, principalIds []uuid.UUID) ([]model.Policy, error) {
+	var policies []model.Policy
+	if len(principalIds) == 0 {
+		return policies, nil
+	}
+
+	db := database.DB
+	attached := db.Model(&model.PolicyAttachment{}).Select("policy_id").Where("principal_id IN ?", principalIds)
+	err := db.Where("org_id = ? AND id IN (?)", orgId, attached).Find(&policies).Error
+	return policies, err
+}
+
+func CreatePolicy(policy model.Policy) (model.Policy, error) {
+	db := database.DB
+	err := db.Create(&policy).Error
+	return policy, err
+}
+
+func UpdatePolicy(policy model.Policy) (model.Policy, error) {
+	db := database.DB
+	err := db.Save(&policy).Error
+	return policy, err
+}
+
+func DeletePolicy(policy model.Policy) error {
+	db := database.DB
+	err := db.Where("policy_id = ?", policy.ID).Delete(&model.PolicyAttachment{}).Error
+	if err != nil {
+		return err
+	}
+	return db.Delete(&policy).Error
+}
+
+func FindAttachments(policyId uuid.UUID) ([]model.PolicyAttachment, error) {
+	var attachments []model.PolicyAttachment
+	db := database.DB
+	err := db.Where("policy_id = ?", policyId).Order("created_at").Find(&attachments).Error
+	return attachments, err
+}
+
+func FindAttachment(policyId uuid.UUID, principalType string, principalId uuid.UUID) (model.PolicyAttachment, error) {
+	var attachment model.PolicyAttachment
+	db := database.DB
+	err := db.First(&attachment, "policy_id = ? AND principal_type = ? AND principal_id = ?", policyId, principalType, principalId).Error
+	return attachment, err
+}
+
+func CreateAttachment(attachment model.PolicyAttachment) (model.PolicyAttachment, error) {
+	db := database.DB
+	err := db.Create(&attachment).Error
+	return attachment, err
+}
+
+func DeleteAttachment(attachment model.PolicyAttachment) error {
+	db := database.DB
+	return db.Delete(&attachment).Error
+}
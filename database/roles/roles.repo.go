@@ -5,12 +5,24 @@ import (
 	"balkantask/model"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-func GetAllRoles() ([]model.Role, error) {
+// GetAllRoles lists the SYSTEM roles together with the CUSTOM roles of the org.
+func GetAllRoles(orgId uuid.UUID) ([]model.Role, error) {
 	db := database.DB
 	var roles []model.Role
-	err := db.Find(&roles).Error
+	err := db.Find(&roles, "org_id IS NULL OR org_id = ?", orgId).Error
+
+	return roles, err
+}
+
+// GetRolesWithIncludes loads the roles available to the org with the roles
+// they include, to expand the hierarchy.
+func GetRolesWithIncludes(orgId uuid.UUID) ([]model.Role, error) {
+	db := database.DB
+	var roles []model.Role
+	err := db.Preload("Includes").Find(&roles, "org_id IS NULL OR org_id = ?", orgId).Error
 
 	return roles, err
 }
@@ -30,18 +42,20 @@ func GetRolesByIds(ids []uuid.UUID) ([]model.Role, error) {
 	return roles, err
 }
 
-func GetRoleByName(name string) (model.Role, error) {
+// GetRoleByName looks the name up among the SYSTEM roles and the CUSTOM
+// roles of the org. Other orgs may use the same name.
+func GetRoleByName(name string, orgId uuid.UUID) (model.Role, error) {
 	db := database.DB
 	var role model.Role
-	err := db.First(&role, "name = ?", name).Error
+	err := db.First(&role, "name = ? AND (org_id IS NULL OR org_id = ?)", name, orgId).Error
 
 	return role, err
 }
 
-func GetRolesByNames(name []string) ([]model.Role, error) {
+func GetRolesByNames(name []string, orgId uuid.UUID) ([]model.Role, error) {
 	db := database.DB
 	var role []model.Role
-	err := db.Find(&role, "name IN ?", name).Error
+	err := db.Find(&role, "name IN ? AND (org_id IS NULL OR org_id = ?)", name, orgId).Error
 
 	return role, err
 }
@@ -60,11 +74,67 @@ func CreateRoles(role []model.Role) ([]model.Role, error) {
 	return role, err
 }
 
+func UpdateRole(role *model.Role) error {
+	db := database.DB
+	return db.Omit("Includes").Save(role).Error
+}
+
+// DeleteRole removes the role with its assignments, the conditions on them
+// and the policies attached to it.
 func DeleteRole(role *model.Role) error {
 	db := database.DB
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Association("Users").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(role).Association("Groups").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Model(role).Association("Includes").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM role_includes WHERE included_role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", role.ID).Delete(&model.RoleCondition{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("principal_id = ?", role.ID).Delete(&model.PolicyAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(role).Error
+	})
+}
+
+// ReplaceIncludes sets the roles a CUSTOM role includes.
+func ReplaceIncludes(role *model.Role, includes []model.Role) error {
+	db := database.DB
+	if len(includes) == 0 {
+		return db.Model(role).Association("Includes").Clear()
+	}
+	return db.Model(role).Association("Includes").Replace(includes)
+}
+
+// GetRoleConditions loads the conditions of the roles held by the principals.
+func GetRoleConditions(principalIds []uuid.UUID) ([]model.RoleCondition, error) {
+	db := database.DB
+	var conditions []model.RoleCondition
+	err := db.Find(&conditions, "principal_id IN ?", principalIds).Error
+	return conditions, err
+}
 
-	err := db.Model(&role).Association("Users").Clear()
-	err = db.Model(&role).Association("Groups").Clear()
-	err = db.Delete(&role).Error
-	return err
+// SetRoleConditions replaces the conditions of a role held by a principal.
+// No conditions leave the role unconditional.
+func SetRoleConditions(principalId uuid.UUID, roleId uuid.UUID, conditions map[string]map[string][]string) error {
+	db := database.DB
+	err := DeleteRoleConditions(principalId, roleId)
+	if err != nil || len(conditions) == 0 {
+		return err
+	}
+	return db.Create(&model.RoleCondition{PrincipalID: principalId, RoleID: roleId, Conditions: conditions}).Error
+}
+
+func DeleteRoleConditions(principalId uuid.UUID, roleId uuid.UUID) error {
+	db := database.DB
+	return db.Where("principal_id = ? AND role_id = ?", principalId, roleId).Delete(&model.RoleCondition{}).Error
 }
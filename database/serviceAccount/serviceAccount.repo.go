@@ -0,0 +1,86 @@
+package serviceAccountRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	constants "balkantask/utils"
+
+	"github.com/google/uuid"
+)
+
+func FindServiceAccountsByOrgId(orgId uuid.UUID) ([]serviceAccountSchema.ServiceAccountResponse, error) {
+	var serviceAccounts []model.ServiceAccount
+	db := database.DB
+	err := db.Preload("Roles").Preload("Groups").Where("org_id = ? AND account_status != ?", orgId, constants.DELETED).Find(&serviceAccounts).Error
+
+	serviceAccounts_ := []serviceAccountSchema.ServiceAccountResponse{}
+	for _, serviceAccount := range serviceAccounts {
+		serviceAccounts_ = append(serviceAccounts_, serviceAccountSchema.MapServiceAccountRecord(&serviceAccount))
+	}
+
+	return serviceAccounts_, err
+}
+
+func FindServiceAccountById(id uuid.UUID) (model.ServiceAccount, error) {
+	var serviceAccount model.ServiceAccount
+	db := database.DB
+	err := db.Preload("Roles").Preload("Groups.Roles").Preload("Org").Where("id = ? AND account_status != ?", id, constants.DELETED).First(&serviceAccount).Error
+	return serviceAccount, err
+}
+
+func FindServiceAccountByClientId(clientId string) (model.ServiceAccount, error) {
+	var serviceAccount model.ServiceAccount
+	db := database.DB
+	err := db.Preload("Org").Where("client_id = ? AND account_status != ?", clientId, constants.DELETED).First(&serviceAccount).Error
+	return serviceAccount, err
+}
+
+func CreateServiceAccount(serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Create(&serviceAccount).Error
+	return serviceAccount, err
+}
+
+func UpdateServiceAccount(serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Omit("Roles", "Groups", "Org").Save(&serviceAccount).Error
+	return serviceAccount, err
+}
+
+func DeleteServiceAccount(serviceAccount model.ServiceAccount) error {
+	db := database.DB
+	err := db.Model(&serviceAccount).Association("Roles").Clear()
+	if err != nil {
+		return err
+	}
+	err = db.Model(&serviceAccount).Association("Groups").Clear()
+	if err != nil {
+		return err
+	}
+	return db.Delete(&serviceAccount).Error
+}
+
+func AddRoleToServiceAccount(role model.Role, serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Model(&serviceAccount).Association("Roles").Append(&role)
+	return serviceAccount, err
+}
+
+func DeleteRoleFromServiceAccount(role model.Role, serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Model(&serviceAccount).Association("Roles").Delete(&role)
+	return serviceAccount, err
+}
+
+func AddGroupToServiceAccount(group model.Group, serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Model(&serviceAccount).Association("Groups").Append(&group)
+	return serviceAccount, err
+}
+
+func DeleteGroupFromServiceAccount(group model.Group, serviceAccount model.ServiceAccount) (model.ServiceAccount, error) {
+	db := database.DB
+	err := db.Model(&serviceAccount).Association("Groups").Delete(&group)
+	return serviceAccount, err
+}
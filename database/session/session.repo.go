@@ -0,0 +1,65 @@
+package sessionRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func CreateSession(session model.Session) (model.Session, error) {
+	db := database.DB
+	err := db.Create(&session).Error
+	return session, err
+}
+
+func FindSessionById(id uuid.UUID) (model.Session, error) {
+	var session model.Session
+	db := database.DB
+	err := db.First(&session, "id = ?", id).Error
+	return session, err
+}
+
+// FindActiveSessionsBySubject returns the sessions that are neither revoked
+// nor expired, most recently used first.
+func FindActiveSessionsBySubject(subjectId uuid.UUID) ([]model.Session, error) {
+	var sessions []model.Session
+	db := database.DB
+	err := db.Where("subject_id = ? AND revoked_at IS NULL AND expires_at > ?", subjectId, time.Now()).Order("last_seen_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// TouchSession records that the session was just used from the IP.
+func TouchSession(id uuid.UUID, ip string) error {
+	db := database.DB
+	err := db.Model(&model.Session{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"last_seen_at": time.Now(), "ip": ip}).Error
+	return err
+}
+
+// ExtendSession moves the expiry of the session along with a new refresh token.
+func ExtendSession(id uuid.UUID, ip string, expiresAt time.Time) error {
+	db := database.DB
+	err := db.Model(&model.Session{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"last_seen_at": time.Now(), "ip": ip, "expires_at": expiresAt}).Error
+	return err
+}
+
+func RevokeSession(id uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now()).Error
+	return err
+}
+
+func RevokeSessionsBySubject(subjectId uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.Session{}).Where("subject_id = ? AND revoked_at IS NULL", subjectId).Update("revoked_at", time.Now()).Error
+	return err
+}
+
+// DeleteEndedSessions removes sessions that expired or were revoked before
+// the threshold.
+func DeleteEndedSessions(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ? OR revoked_at < ?", threshold, threshold).Delete(&model.Session{}).Error
+	return err
+}
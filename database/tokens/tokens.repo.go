@@ -0,0 +1,181 @@
+package tokensRepo
+
+import (
+	"balkantask/database"
+	"balkantask/model"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func CreateRefreshToken(token model.RefreshToken) (model.RefreshToken, error) {
+	db := database.DB
+	err := db.Create(&token).Error
+	return token, err
+}
+
+func FindRefreshTokenByHash(hash string) (model.RefreshToken, error) {
+	var token model.RefreshToken
+	db := database.DB
+	err := db.Where("token_hash = ?", hash).First(&token).Error
+	return token, err
+}
+
+// MarkRefreshTokenUsed flags the token as consumed. It only succeeds for the
+// first caller, so two concurrent refreshes with the same token cannot both win.
+func MarkRefreshTokenUsed(id uuid.UUID) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.RefreshToken{}).Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func RevokeRefreshTokenFamily(familyId uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyId).Update("revoked_at", time.Now()).Error
+	return err
+}
+
+func DeleteExpiredRefreshTokens(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ?", threshold).Delete(&model.RefreshToken{}).Error
+	return err
+}
+
+func RevokeRefreshTokensBySubject(subjectId uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.RefreshToken{}).Where("subject_id = ? AND revoked_at IS NULL", subjectId).Update("revoked_at", time.Now()).Error
+	return err
+}
+
+func CreateRevokedToken(token model.RevokedToken) error {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&token).Error
+	return err
+}
+
+func UpsertSubjectRevocation(revocation model.SubjectRevocation) error {
+	db := database.DB
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "subject_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_at", "expires_at", "updated_at"}),
+	}).Create(&revocation).Error
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the token was revoked on its own or as
+// part of a revocation of all tokens of its subject.
+func IsAccessTokenRevoked(jti string, subjectId uuid.UUID, issuedAt time.Time) (bool, error) {
+	db := database.DB
+
+	var count int64
+	err := db.Model(&model.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	if err != nil || count > 0 {
+		return true, err
+	}
+
+	// iat only has second precision, so revoked_at is compared at the same
+	// precision. A token minted in the second of the revocation is revoked.
+	err = db.Model(&model.SubjectRevocation{}).Where("subject_id = ? AND date_trunc('second', revoked_at) >= ?", subjectId, issuedAt.Truncate(time.Second)).Count(&count).Error
+	if err != nil {
+		return true, err
+	}
+
+	return count > 0, nil
+}
+
+func DeleteExpiredRevocations(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ?", threshold).Delete(&model.RevokedToken{}).Error
+	if err != nil {
+		return err
+	}
+
+	err = db.Where("expires_at < ?", threshold).Delete(&model.SubjectRevocation{}).Error
+	return err
+}
+
+func GetSigningKeys() ([]model.SigningKey, error) {
+	var keys []model.SigningKey
+	db := database.DB
+	err := db.Order("created_at").Find(&keys).Error
+	return keys, err
+}
+
+// RotateSigningKey stores key as the active signing key and retires the others
+// when the active key was created before dueBefore or uses another algorithm.
+// The active key row is locked while this is decided, so instances rotating at
+// the same time create a single key. It reports whether key was stored.
+func RotateSigningKey(key model.SigningKey, dueBefore time.Time, expiresAt time.Time) (bool, error) {
+	db := database.DB
+	rotated := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var active model.SigningKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("retired_at IS NULL").Order("created_at desc").First(&active).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && active.Algorithm == key.Algorithm && active.CreatedAt != nil && !active.CreatedAt.Before(dueBefore) {
+			return nil
+		}
+
+		if err := tx.Create(&key).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&model.SigningKey{}).Where("kid != ? AND retired_at IS NULL", key.Kid).Updates(map[string]interface{}{"retired_at": time.Now(), "expires_at": expiresAt}).Error; err != nil {
+			return err
+		}
+
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+func UpdateSigningKeyPrivateKey(kid string, privateKey string) error {
+	db := database.DB
+	err := db.Model(&model.SigningKey{}).Where("kid = ?", kid).Update("private_key", privateKey).Error
+	return err
+}
+
+func DeleteExpiredSigningKeys(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ?", threshold).Delete(&model.SigningKey{}).Error
+	return err
+}
+
+func CreatePasswordResetToken(token model.PasswordResetToken) (model.PasswordResetToken, error) {
+	db := database.DB
+	err := db.Create(&token).Error
+	return token, err
+}
+
+func FindPasswordResetTokenByHash(hash string) (model.PasswordResetToken, error) {
+	var token model.PasswordResetToken
+	db := database.DB
+	err := db.Where("token_hash = ?", hash).First(&token).Error
+	return token, err
+}
+
+// UsePasswordResetTokens consumes every open reset token of the subject. The
+// bool reports whether the given token was among them, so only one of two
+// concurrent resets with the same token wins.
+func UsePasswordResetTokens(subjectId uuid.UUID, tokenId uuid.UUID) (bool, error) {
+	db := database.DB
+
+	result := db.Model(&model.PasswordResetToken{}).Where("id = ? AND used_at IS NULL", tokenId).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false, result.Error
+	}
+
+	err := db.Model(&model.PasswordResetToken{}).Where("subject_id = ? AND used_at IS NULL", subjectId).Update("used_at", time.Now()).Error
+	return true, err
+}
+
+func DeleteExpiredPasswordResetTokens(threshold time.Time) error {
+	db := database.DB
+	err := db.Where("expires_at < ?", threshold).Delete(&model.PasswordResetToken{}).Error
+	return err
+}
@@ -26,7 +26,7 @@ func FindUsers() ([]userSchema.UserResponse, error) {
 func FindUserByIdWithPassword(id uuid.UUID) (model.User, error) {
 	var user model.User
 	db := database.DB
-	err := db.Preload("Roles").Preload("Groups").Preload("Org").Where("id = ? AND account_status != ?", id, constants.DELETED).First(&user).Error
+	err := db.Preload("Roles").Preload("Groups.Roles").Preload("Org").Where("id = ? AND account_status != ?", id, constants.DELETED).First(&user).Error
 	return user, err
 }
 
@@ -82,6 +82,15 @@ func FindUserByOrgAndUsernameWithPassword(username string, orgId string) (model.
 	return user, err
 }
 
+// FindUsersByOrgAndEmail returns the users of the org with the address.
+// Emails are not unique, so there may be several.
+func FindUsersByOrgAndEmail(email string, orgId uuid.UUID) ([]model.User, error) {
+	var users []model.User
+	db := database.DB
+	err := db.Where("email = ? AND org_id = ? AND account_status != ?", email, orgId, constants.DELETED).Find(&users).Error
+	return users, err
+}
+
 func FindUserByUsername(username string) (*model.User, error) {
 	var user *model.User
 	db := database.DB
@@ -132,6 +141,49 @@ func UpdateUser(user model.User) (userSchema.UserResponse, error) {
 	return user_, err
 }
 
+// UpdateUserAttributes replaces the attributes conditions test.
+func UpdateUserAttributes(id uuid.UUID, attributes map[string]string) error {
+	db := database.DB
+	err := db.Model(&model.User{}).Where("id = ?", id).Updates(model.User{Attributes: attributes}).Error
+	return err
+}
+
+// UpdateUserPasswordHash replaces the hash of an unchanged password, e.g.
+// when it is upgraded to a stronger algorithm. It does not touch updated_at.
+func UpdateUserPasswordHash(id uuid.UUID, oldHash string, newHash string) error {
+	db := database.DB
+	err := db.Model(&model.User{}).Where("id = ? AND password = ?", id, oldHash).UpdateColumn("password", newHash).Error
+	return err
+}
+
+// ReplaceRequiredPassword sets the password a user chose in place of one set
+// by an admin. It reports whether the user still had to change the password
+// and it was unchanged, so a change token cannot be used twice.
+func ReplaceRequiredPassword(id uuid.UUID, oldHash string, newHash string, changedAt time.Time) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.User{}).Where("id = ? AND password = ? AND must_change_password = ?", id, oldHash, true).Updates(map[string]interface{}{
+		"password":             newHash,
+		"password_changed_at":  changedAt,
+		"must_change_password": false,
+	})
+	return result.RowsAffected == 1, result.Error
+}
+
+// LockUser marks an account as locked unless it is deactivated, deleted or
+// only invited.
+func LockUser(id uuid.UUID) error {
+	db := database.DB
+	err := db.Model(&model.User{}).Where("id = ? AND account_status NOT IN ?", id, []constants.AccountStatus{constants.DEACTIVATED, constants.DELETED, constants.INVITED}).Update("account_status", constants.LOCKED).Error
+	return err
+}
+
+// UnlockUser reactivates a locked account. It reports whether it was locked.
+func UnlockUser(id uuid.UUID) (bool, error) {
+	db := database.DB
+	result := db.Model(&model.User{}).Where("id = ? AND account_status = ?", id, constants.LOCKED).Update("account_status", constants.ACTIVATED)
+	return result.RowsAffected == 1, result.Error
+}
+
 func DeleteUser(user model.User) (bool, error) {
 	db := database.DB
 	err := db.Model(&user).Association("Roles").Clear()
@@ -165,6 +217,15 @@ func DeleteGroupFromUser(group model.Group, user model.User) (model.User, error)
 	return user, err
 }
 
+// FindLDAPUsersByOrgId returns the users of the org synced from its
+// directory.
+func FindLDAPUsersByOrgId(orgId uuid.UUID) ([]model.User, error) {
+	var users []model.User
+	db := database.DB
+	err := db.Preload("Groups").Where("org_id = ? AND ldap_dn != ? AND account_status != ?", orgId, "", constants.DELETED).Find(&users).Error
+	return users, err
+}
+
 func GetDeactivatedUserForThreshold(threshold time.Time) ([]model.User, error) {
 	var users []model.User
 	db := database.DB
@@ -0,0 +1,357 @@
+package apiKeyHandler
+
+import (
+	apiKeyRepo "balkantask/database/apiKey"
+	rolesRepo "balkantask/database/roles"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	apiKeySchema "balkantask/schemas/apiKey"
+	orgSchema "balkantask/schemas/org"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/roles"
+	"balkantask/utils/tokens"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const apiKeyPrefix = "gik_"
+
+func GetAPIKeys(c *fiber.Ctx) error {
+	if _, ok := c.Locals("apiKey").(apiKeySchema.APIKeyResponse); ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "API keys cannot be managed with an API key",
+			"status":  "error",
+		})
+	}
+
+	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+
+	var apiKeys []model.APIKey
+	var err error
+
+	if orgOK {
+		apiKeys, err = apiKeyRepo.FindAPIKeysByOrgId(org.ID)
+	} else if userOK {
+		apiKeys, err = apiKeyRepo.FindAPIKeysByUserId(user.ID)
+	} else {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	response := []apiKeySchema.APIKeyResponse{}
+	for _, apiKey := range apiKeys {
+		response = append(response, apiKeySchema.MapAPIKeyRecord(&apiKey))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+// CreateAPIKey creates a key for the logged in user, or for one of its users
+// when called by the org root.
+func CreateAPIKey(c *fiber.Ctx) error {
+	var input apiKeySchema.CreateAPIKey
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	if _, ok := c.Locals("apiKey").(apiKeySchema.APIKeyResponse); ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "API keys cannot be managed with an API key",
+			"status":  "error",
+		})
+	}
+
+	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+
+	var ownerId uuid.UUID
+	if orgOK {
+		ownerId = input.UserId
+	} else if userOK && (input.UserId == uuid.Nil || input.UserId == user.ID) {
+		ownerId = user.ID
+	} else {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Expiry must be in the future",
+			"status":  "error",
+		})
+	}
+
+	owner, err := userRepo.FindUserByIdWithPassword(ownerId)
+	if err != nil || (orgOK && owner.OrgID != org.ID) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User Not Found",
+			"status":  "error",
+		})
+	}
+
+	if owner.AccountStatus == constants.DEACTIVATED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
+	if owner.AccountStatus == constants.INVITED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "User has not accepted the invitation yet",
+			"status":  "error",
+		})
+	}
+
+	// A key can only be restricted to roles the owner actually holds
+	keyRoles := []model.Role{}
+	if len(input.RoleIds) > 0 {
+		rolesById, err := rolesRepo.GetRolesByIds(input.RoleIds)
+		if err != nil || len(rolesById) != len(input.RoleIds) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid Role IDs",
+				"status":  "error",
+			})
+		}
+		for _, role := range rolesById {
+			if !roles.AvailableTo(role, owner.OrgID) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": "Role doesn't exist",
+					"status":  "error",
+				})
+			}
+		}
+		keyRoles = append(keyRoles, rolesById...)
+	}
+	if len(input.RoleNames) > 0 {
+		rolesByName, err := rolesRepo.GetRolesByNames(input.RoleNames, owner.OrgID)
+		if err != nil || len(rolesByName) != len(input.RoleNames) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid Role Names",
+				"status":  "error",
+			})
+		}
+		keyRoles = append(keyRoles, rolesByName...)
+	}
+	keyRoles = roles.RemoveDuplicates(keyRoles)
+
+	// A role is also held through the roles including it
+	available, err := rolesRepo.GetRolesWithIncludes(owner.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+	ownerRoles := roles.EffectiveRoles(owner.Roles, owner.Groups)
+	graph := roles.NewGraph(available)
+	for _, role := range keyRoles {
+		if !graph.Reaches(ownerRoles, role.ID) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "API key can only be restricted to roles the user has",
+				"status":  "error",
+			})
+		}
+	}
+
+	secret, _, err := tokens.GenerateOpaqueToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+	key := apiKeyPrefix + secret
+
+	createdAPIKey, err := apiKeyRepo.CreateAPIKey(model.APIKey{
+		UserID:    owner.ID,
+		OrgID:     owner.OrgID,
+		Label:     input.Label,
+		Prefix:    key[:12],
+		KeyHash:   tokens.HashToken(key),
+		Roles:     keyRoles,
+		ExpiresAt: input.ExpiresAt,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	// The key is only ever shown once
+	response := apiKeySchema.MapAPIKeyRecord(&createdAPIKey)
+	response.Key = key
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Created",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+// UpdateAPIKey changes the label or the expiry of a key.
+func UpdateAPIKey(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	var input apiKeySchema.UpdateAPIKey
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Expiry must be in the future",
+			"status":  "error",
+		})
+	}
+
+	apiKey, status, message := findManagedAPIKey(c, id)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{
+			"message": message,
+			"status":  "error",
+		})
+	}
+
+	if apiKey.RevokedAt != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "API key has been revoked",
+			"status":  "error",
+		})
+	}
+
+	if input.Label != "" {
+		apiKey.Label = input.Label
+	}
+	if input.ExpiresAt != nil {
+		apiKey.ExpiresAt = input.ExpiresAt
+	}
+
+	updatedAPIKey, err := apiKeyRepo.UpdateAPIKey(apiKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    apiKeySchema.MapAPIKeyRecord(&updatedAPIKey),
+	})
+}
+
+func RevokeAPIKey(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	apiKey, status, message := findManagedAPIKey(c, id)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{
+			"message": message,
+			"status":  "error",
+		})
+	}
+
+	if apiKey.RevokedAt != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "API key has already been revoked",
+			"status":  "error",
+		})
+	}
+
+	now := time.Now()
+	apiKey.RevokedAt = &now
+
+	updatedAPIKey, err := apiKeyRepo.UpdateAPIKey(apiKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "API key revoked",
+		"status":  "success",
+		"data":    apiKeySchema.MapAPIKeyRecord(&updatedAPIKey),
+	})
+}
+
+// findManagedAPIKey loads a key the caller is allowed to manage: its own, or
+// any key of the org when called by the org root.
+func findManagedAPIKey(c *fiber.Ctx, id uuid.UUID) (model.APIKey, int, string) {
+	if _, ok := c.Locals("apiKey").(apiKeySchema.APIKeyResponse); ok {
+		return model.APIKey{}, fiber.StatusForbidden, "API keys cannot be managed with an API key"
+	}
+
+	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+
+	if !orgOK && !userOK {
+		return model.APIKey{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	apiKey, err := apiKeyRepo.FindAPIKeyById(id)
+	if err != nil || (orgOK && apiKey.OrgID != org.ID) || (userOK && apiKey.UserID != user.ID) {
+		return model.APIKey{}, fiber.StatusNotFound, "API Key Not Found"
+	}
+
+	return apiKey, fiber.StatusOK, ""
+}
@@ -0,0 +1,64 @@
+package auditHandler
+
+import (
+	auditRepo "balkantask/database/audit"
+	auditSchema "balkantask/schemas/audit"
+	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	userSchema "balkantask/schemas/user"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAuditLogs lists the newest audit entries of the caller's org. The number
+// of entries can be set with ?limit= and ?action= keeps one kind of entry,
+// e.g. impersonation.started.
+func GetAuditLogs(c *fiber.Ctx) error {
+	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
+
+	var orgId uuid.UUID
+	if orgOK {
+		orgId = org.ID
+	} else if userOK {
+		orgId = user.OrgId
+	} else if serviceAccountOK {
+		orgId = serviceAccount.OrgId
+	} else {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	limit := c.QueryInt("limit", defaultAuditLimit)
+	if limit <= 0 || limit > maxAuditLimit {
+		limit = defaultAuditLimit
+	}
+
+	entries, err := auditRepo.FindAuditLogsByOrgId(orgId, c.Query("action"), limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	response := []auditSchema.AuditLogResponse{}
+	for _, entry := range entries {
+		response = append(response, auditSchema.MapAuditLogRecord(&entry))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    response,
+	})
+}
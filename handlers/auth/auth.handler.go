@@ -4,20 +4,23 @@ import (
 	orgRepo "balkantask/database/org"
 	userRepo "balkantask/database/user"
 	"balkantask/model"
+	authSchema "balkantask/schemas/auth"
 	orgSchema "balkantask/schemas/org"
 	userSchema "balkantask/schemas/user"
 	constants "balkantask/utils"
-	"balkantask/utils/roles"
+	"balkantask/utils/hashing"
+	"balkantask/utils/lockout"
+	"balkantask/utils/password"
+	"balkantask/utils/permissions"
+	"balkantask/utils/sessionCookie"
+	"balkantask/utils/tokens"
 	"fmt"
-	"os"
-	"time"
-
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -34,13 +37,25 @@ func SignInUser(c *fiber.Ctx) error {
 
 	}
 
+	if wait := lockout.RetryAfter(uuid.Nil, c.IP()); wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	// Checked before the user, so the answer does not tell whether it exists
+	if orgId, err := uuid.Parse(payload.AccountId); err == nil && passwordLoginDisabled(orgId) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "Password login is disabled for this org. Sign in with your identity provider"})
+	}
+
 	var user model.User
 	user, err := userRepo.FindUserByOrgAndUsernameWithPassword(strings.ToLower(payload.Username), payload.AccountId)
 	if err != nil {
+		lockout.RecordFailure(lockout.Account{}, c.IP())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid username or Password"})
 	}
 
-	if user.AccountStatus == constants.DELETED {
+	// An invited user has no password until it accepts the invitation
+	if user.AccountStatus == constants.DELETED || user.AccountStatus == constants.INVITED {
+		lockout.RecordFailure(lockout.Account{}, c.IP())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid username or Password"})
 	}
 
@@ -48,29 +63,32 @@ func SignInUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Account deactivated. Contact your admin"})
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(payload.Password))
+	account := lockout.Account{ID: user.ID, Type: constants.USER, OrgID: user.OrgID}
+	if throttled, err := loginThrottled(c, account, user.AccountStatus == constants.LOCKED); throttled {
+		return err
+	}
+
+	verified, bound, err := checkUserPassword(user, payload.Password)
 	if err != nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "false", "message": "Directory unavailable. Try again later"})
+	}
+	if !verified {
+		if lockout.RecordFailure(account, c.IP()) {
+			return accountLocked(c, account)
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid username or Password"})
 	}
 
-	// Create a new JWT token with a custom expiration time
-	tokenByte := jwt.New(jwt.SigningMethodHS256)
-	now := time.Now().UTC()
-	expirationTime := now.Add(time.Hour * 24) // Token will expire in 24 hours
-
-	claims := tokenByte.Claims.(jwt.MapClaims)
-	claims["sub"] = user.ID
-	claims["exp"] = expirationTime.Unix()
-	claims["iat"] = now.Unix()
-	claims["nbf"] = now.Unix()
+	lockout.RecordSuccess(user.ID)
+	if !bound {
+		rehashPassword(user.ID, constants.USER, user.Password, payload.Password)
+	}
 
-	config := os.Getenv("JWT_SECRET")
-	tokenString, err := tokenByte.SignedString([]byte(config))
-	if err != nil {
-		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "false", "message": "Internal Server Error"})
+	if user.MustChangePassword && !bound {
+		return passwordChangeRequired(c, user.ID)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "token": tokenString})
+	return completeSignIn(c, user.ID, constants.USER, orgRequiresMFA(user.OrgID))
 }
 
 func SignInOrg(c *fiber.Ctx) error {
@@ -86,13 +104,19 @@ func SignInOrg(c *fiber.Ctx) error {
 
 	}
 
+	if wait := lockout.RetryAfter(uuid.Nil, c.IP()); wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
 	var org model.Org
 	org, err := orgRepo.FindOrgByEmail(strings.ToLower(payload.Email))
 	if err != nil {
+		lockout.RecordFailure(lockout.Account{}, c.IP())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid email or Password"})
 	}
 
 	if org.AccountStatus == constants.DELETED {
+		lockout.RecordFailure(lockout.Account{}, c.IP())
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid email or Password"})
 	}
 
@@ -100,33 +124,143 @@ func SignInOrg(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Account deactivated. Contact support."})
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(org.Password), []byte(payload.Password))
-	if err != nil {
+	account := lockout.Account{ID: org.ID, Type: constants.ORG, OrgID: org.ID}
+	if throttled, err := loginThrottled(c, account, org.AccountStatus == constants.LOCKED); throttled {
+		return err
+	}
+
+	if !hashing.Verify(payload.Password, org.Password) {
+		if lockout.RecordFailure(account, c.IP()) {
+			return accountLocked(c, account)
+		}
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "Invalid email or Password"})
 	}
 
-	// Create a new JWT token with a custom expiration time
-	tokenByte := jwt.New(jwt.SigningMethodHS256)
-	now := time.Now().UTC()
-	expirationTime := now.Add(time.Hour * 24) // Token will expire in 24 hours
+	lockout.RecordSuccess(org.ID)
+	rehashPassword(org.ID, constants.ORG, org.Password, payload.Password)
+
+	if org.AccountStatus == constants.PENDING_VERIFICATION {
+		return emailNotVerified(c)
+	}
+
+	return completeSignIn(c, org.ID, constants.ORG, org.RequireMFA)
+}
+
+// RefreshToken rotates a refresh token. Cookie sessions send none in the
+// body; theirs comes from the cookie and the CSRF token must be echoed back.
+func RefreshToken(c *fiber.Ctx) error {
+	var payload authSchema.RefreshInput
+
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+		}
+	}
+
+	cookieSession := false
+	if payload.RefreshToken == "" && c.Cookies(sessionCookie.RefreshTokenCookie) != "" {
+		if !sessionCookie.ValidDoubleSubmit(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "Invalid CSRF token"})
+		}
+		payload.RefreshToken, cookieSession = c.Cookies(sessionCookie.RefreshTokenCookie), true
+	}
+
+	if payload.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "refresh_token is required"})
+	}
+
+	storedToken, err := tokens.ConsumeRefreshToken(payload.RefreshToken)
+	switch err {
+	case nil:
+	case tokens.ErrInvalidRefreshToken:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid refresh token"})
+	case tokens.ErrRefreshTokenReused:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Refresh token reuse detected. Please log in again."})
+	case tokens.ErrRefreshTokenExpired:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Refresh token expired"})
+	case tokens.ErrPrincipalInactive:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Account is not active"})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	// A token issued to an OAuth client is only redeemable by that client, and
+	// only at /oauth/token where it has to authenticate first
+	if storedToken.ClientID != "" {
+		tokens.RevokeSession(storedToken.FamilyID)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Refresh tokens issued to an OAuth client must be redeemed at /oauth/token"})
+	}
+
+	return sendTokens(c, tokens.TokenRequest{
+		Subject:     storedToken.SubjectID,
+		SubjectType: storedToken.SubjectType,
+		FamilyID:    storedToken.FamilyID,
+		ClientID:    storedToken.ClientID,
+		Scope:       storedToken.Scope,
+		AuthMethods: strings.Fields(storedToken.AuthMethods),
+		IP:          c.IP(),
+	}, cookieSession, nil)
+}
+
+func Logout(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid token"})
+	}
+
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid token"})
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid token"})
+	}
+
+	err = tokens.RevokeAccessToken(fmt.Sprint(claims["jti"]), subject, expiresAt.Time)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	if familyId, err := uuid.Parse(fmt.Sprint(claims["sid"])); err == nil {
+		err = tokens.RevokeSession(familyId)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+		}
+	}
+
+	sessionCookie.Clear(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Logged out"})
+}
+
+func LogoutAll(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid token"})
+	}
 
-	claims := tokenByte.Claims.(jwt.MapClaims)
-	claims["sub"] = org.ID
-	claims["exp"] = expirationTime.Unix()
-	claims["iat"] = now.Unix()
-	claims["nbf"] = now.Unix()
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid token"})
+	}
 
-	config := os.Getenv("JWT_SECRET")
-	tokenString, err := tokenByte.SignedString([]byte(config))
+	err = tokens.RevokeAllTokens(subject)
 	if err != nil {
-		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "false", "message": fmt.Sprintf("generating JWT Token failed: %v", err)})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "token": tokenString})
+	sessionCookie.Clear(c)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Logged out from all devices"})
 }
 
 func GetMe(c *fiber.Ctx) error {
 	if user, ok := c.Locals("user").(userSchema.UserResponse); ok {
+		if actor, ok := c.Locals("actor").(tokens.Actor); ok {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"user": user, "impersonated_by": fiber.Map{"id": actor.ID, "type": actor.Type}}})
+		}
 
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"user": user}})
 	}
@@ -150,6 +284,15 @@ func SignUpOrg(c *fiber.Ctx) error {
 		})
 	}
 
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
 	if input.Password != input.ConfirmPassword {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Password and password confirmation do not match",
@@ -159,11 +302,14 @@ func SignUpOrg(c *fiber.Ctx) error {
 
 	if !input.ValidatePassword() {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Password must be at least 8 characters long, contain at least one uppercase letter, one lowercase letter, one number and one special character.",
+			"message": password.Requirements,
 			"status":  "error",
 		})
 	}
 
+	// Orgs are found by email, so it is matched case-insensitively
+	input.Email = strings.ToLower(input.Email)
+
 	// Check if email already exists
 	exisitingOrg, err := orgRepo.FindOrgByEmail(input.Email)
 	if err != nil && err != gorm.ErrRecordNotFound {
@@ -180,6 +326,18 @@ func SignUpOrg(c *fiber.Ctx) error {
 		})
 	}
 
+	// Nobody proved they own the address yet, so a new sign-up replaces the
+	// pending one rather than letting it hold the email until it is purged
+	if exisitingOrg.AccountStatus == constants.PENDING_VERIFICATION {
+		if _, err := orgRepo.DeleteOrg(exisitingOrg); err != nil {
+			return c.Status(500).JSON(fiber.Map{
+				"message": "Internal Server Error",
+				"status":  "error",
+			})
+		}
+		exisitingOrg = model.Org{}
+	}
+
 	if exisitingOrg.ID != uuid.Nil {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 			"message": "Email already in use",
@@ -188,11 +346,12 @@ func SignUpOrg(c *fiber.Ctx) error {
 	}
 
 	org := model.Org{
-		Username: input.Username,
-		Email:    input.Email,
+		Username:      input.Username,
+		Email:         input.Email,
+		AccountStatus: constants.PENDING_VERIFICATION,
 	}
 
-	errors := model.ValidateStruct(org)
+	errors = model.ValidateStruct(org)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Validation Error",
@@ -201,13 +360,15 @@ func SignUpOrg(c *fiber.Ctx) error {
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashing.Hash(input.Password)
 
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "Internal Server Error", "message": err.Error()})
 	}
 
-	org.Password = string(hashedPassword)
+	now := time.Now()
+	org.Password = hashedPassword
+	org.PasswordChangedAt = &now
 
 	createdOrg, err := orgRepo.CreateOrg(org)
 	if err != nil {
@@ -217,10 +378,16 @@ func SignUpOrg(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := password.Remember(createdOrg.ID, constants.ORG, createdOrg.Password); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	go sendEmailVerification(createdOrg.ID, createdOrg.Email)
+
 	response := orgSchema.MapOrgRecord(&createdOrg)
 
 	return c.Status(201).JSON(fiber.Map{
-		"message": "Created",
+		"message": "Created. Check your email to verify the account",
 		"status":  "success",
 		"data":    response,
 	})
@@ -237,9 +404,9 @@ func DeleteAccount(c *fiber.Ctx) error {
 	}
 
 	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	userLoggedIn, userOK := c.Locals("user").(userSchema.UserResponse)
+	_, userOK := c.Locals("user").(userSchema.UserResponse)
 
-	if !(orgOK || (userOK && roles.UserIsAuthorized(userLoggedIn.Roles, userLoggedIn.Groups, []roles.Role{roles.OrgFullAccess}))) {
+	if !(orgOK || (userOK && permissions.Granted(c, permissions.OrgAdmin))) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"message": "Forbidden",
 			"status":  "error",
@@ -282,6 +449,31 @@ func DeleteAccount(c *fiber.Ctx) error {
 		})
 	}
 
+	// Nobody in the org may keep using a token once deletion is initiated
+	orgUsers, err := userRepo.FindUsersByOrgId(orgToDeactivate.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	for _, orgUser := range orgUsers {
+		if err := tokens.RevokeAllTokens(orgUser.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+				"status":  "error",
+			})
+		}
+	}
+
+	if err := tokens.RevokeAllTokens(orgToDeactivate.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Account deletion initiated. Your account is under review, and will be marked as deleted in 5 days. Your data will be deleted after 45 days.",
 		"status":  "success",
@@ -307,8 +499,8 @@ func ChangePassword(c *fiber.Ctx) error {
 	}
 
 	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.OrgWriteAccess}))) {
+	_, userOK := c.Locals("user").(userSchema.UserResponse)
+	if !(orgOK || (userOK && permissions.Granted(c, permissions.OrgWrite))) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"message": "Forbidden",
 			"status":  "error",
@@ -341,15 +533,36 @@ func ChangePassword(c *fiber.Ctx) error {
 	}
 
 	// check if new password is the same as the old password
-	err = bcrypt.CompareHashAndPassword([]byte(org_.Password), []byte(input.Password))
-	if err == nil {
+	if hashing.Verify(input.Password, org_.Password) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "New password cannot be the same as the old password",
 			"status":  "error",
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	policy, err := password.PolicyForOrg(org_.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	violation, err := password.Check(policy, org_.ID, input.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+	if violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": violation,
+			"status":  "error",
+		})
+	}
+
+	hashedPassword, err := hashing.Hash(input.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Internal Server Error",
@@ -357,7 +570,9 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
-	org_.Password = string(hashedPassword)
+	now := time.Now()
+	org_.Password = hashedPassword
+	org_.PasswordChangedAt = &now
 
 	updatedUser, err := orgRepo.UpdateOrg(org_)
 	if err != nil {
@@ -367,9 +582,27 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := password.Remember(org_.ID, constants.ORG, org_.Password); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	err = tokens.RevokeAllTokens(org_.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Password updated successfully",
 		"status":  "success",
 		"data":    updatedUser,
 	})
 }
+
+func GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"keys": tokens.PublicJWKs()})
+}
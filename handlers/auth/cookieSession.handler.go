@@ -0,0 +1,42 @@
+package authHandler
+
+import (
+	"balkantask/utils/sessionCookie"
+	"balkantask/utils/tokens"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendTokens issues a token pair and sends it to the client. In a cookie
+// session the tokens go into HttpOnly cookies and only the CSRF token the
+// browser has to echo back is in the body. extra is added to the response.
+func sendTokens(c *fiber.Ctx, request tokens.TokenRequest, cookieSession bool, extra fiber.Map) error {
+	var csrfToken string
+	if cookieSession {
+		var err error
+		csrfToken, request.CSRFHash, err = sessionCookie.NewCSRFToken()
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "false", "message": "Internal Server Error"})
+		}
+	}
+
+	tokenPair, err := tokens.IssueTokens(request)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "false", "message": "Internal Server Error"})
+	}
+
+	response := fiber.Map{"status": "success", "expires_in": tokenPair.ExpiresIn}
+	if cookieSession {
+		sessionCookie.Set(c, tokenPair, csrfToken)
+		response["csrf_token"] = csrfToken
+	} else {
+		response["token"] = tokenPair.AccessToken
+		response["refresh_token"] = tokenPair.RefreshToken
+	}
+
+	for key, value := range extra {
+		response[key] = value
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
@@ -0,0 +1,128 @@
+package authHandler
+
+import (
+	"balkantask/config"
+	orgRepo "balkantask/database/org"
+	"balkantask/model"
+	authSchema "balkantask/schemas/auth"
+	orgSchema "balkantask/schemas/org"
+	constants "balkantask/utils"
+	"balkantask/utils/notifier"
+	"balkantask/utils/tokens"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// VerifyEmail activates an org with the token from its verification link.
+func VerifyEmail(c *fiber.Ctx) error {
+	var payload authSchema.VerifyEmailInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	orgId, email, err := tokens.ParseEmailVerificationToken(payload.Token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired verification link"})
+	}
+
+	verified, err := orgRepo.VerifyOrgEmail(orgId, email)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	if !verified {
+		// Opening the link twice is fine
+		org, err := orgRepo.FindOrgById(orgId)
+		if err != nil || org.Email != email || org.EmailVerifiedAt == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired verification link"})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Email already verified"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Email verified. You can now log in"})
+}
+
+// ResendVerification sends a new verification link to an org that has not
+// verified its email yet. Links are sent at most once per
+// EMAIL_VERIFICATION_RESEND_INTERVAL, and the response never tells whether
+// the org exists.
+func ResendVerification(c *fiber.Ctx) error {
+	var payload orgSchema.ResendVerificationInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	org, err := orgRepo.FindOrgByEmail(strings.ToLower(payload.Email))
+	if err == nil && org.AccountStatus == constants.PENDING_VERIFICATION {
+		go sendEmailVerification(org.ID, org.Email)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": fmt.Sprintf("If the account is waiting for verification, a new link has been sent. Links can be re-sent once every %s", verificationResendInterval())})
+}
+
+// emailNotVerified rejects a root login of an org that has not verified its
+// email yet.
+func emailNotVerified(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "email_unverified", "message": "Email address is not verified. Use the link we sent you or ask for a new one"})
+}
+
+// sendEmailVerification mails a verification link unless one was sent within
+// the resend interval. It runs in the background like sendPasswordReset.
+func sendEmailVerification(orgId uuid.UUID, email string) {
+	allowed, err := orgRepo.MarkVerificationSent(orgId, time.Now().Add(-verificationResendInterval()))
+	if err != nil {
+		fmt.Println("Error recording email verification:", err)
+		return
+	}
+	if !allowed {
+		return
+	}
+
+	token, err := tokens.GenerateEmailVerificationToken(orgId, email)
+	if err != nil {
+		fmt.Println("Error issuing email verification token:", err)
+		return
+	}
+
+	link := emailVerificationURL() + "?token=" + url.QueryEscape(token)
+
+	err = notifier.Send(notifier.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Thanks for signing up. Open the link below within %s to verify your email address and activate your account:\n\n%s\n\nIf you did not sign up, you can ignore this message.",
+			tokens.EmailVerificationTTL(), link),
+	})
+	if err != nil {
+		fmt.Println("Error sending email verification:", err)
+	}
+}
+
+func verificationResendInterval() time.Duration {
+	return config.Duration("EMAIL_VERIFICATION_RESEND_INTERVAL", 5*time.Minute)
+}
+
+// emailVerificationURL is the page that reads the token from the link and
+// posts it to /api/auth/email/verify.
+func emailVerificationURL() string {
+	verificationURL := os.Getenv("EMAIL_VERIFICATION_URL")
+	if verificationURL == "" {
+		return tokens.Issuer() + "/verify-email"
+	}
+	return verificationURL
+}
@@ -0,0 +1,277 @@
+package authHandler
+
+import (
+	identityProviderRepo "balkantask/database/identityProvider"
+	orgRepo "balkantask/database/org"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	identityProviderSchema "balkantask/schemas/identityProvider"
+	constants "balkantask/utils"
+	"balkantask/utils/audit"
+	"balkantask/utils/groupMapping"
+	"balkantask/utils/oidc"
+	"balkantask/utils/sessionCookie"
+	"balkantask/utils/tokens"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Holds the signed login state between the redirect and the callback
+const loginStateCookie = "oidc_state"
+
+// GetLoginOptions tells a login page how the users of an org sign in: the
+// identity providers they can use and whether passwords are accepted.
+func GetLoginOptions(c *fiber.Ctx) error {
+	orgId, err := uuid.Parse(c.Query("accountId"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid accountId"})
+	}
+
+	org, err := orgRepo.FindOrgById(orgId)
+	if err != nil || !tokens.PrincipalIsActive(org.ID, constants.ORG) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Org Not Found"})
+	}
+
+	providers, err := identityProviderRepo.FindIdentityProvidersByOrgId(org.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	options := []identityProviderSchema.LoginOptionResponse{}
+	for _, provider := range providers {
+		if !provider.Disabled {
+			options = append(options, identityProviderSchema.LoginOptionResponse{ID: provider.ID, Name: provider.Name, LoginURL: oidc.LoginURL(provider.ID)})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": fiber.Map{"password_login": !org.DisablePasswordLogin, "identity_providers": options}})
+}
+
+// FederatedLogin sends the browser to the identity provider. The state,
+// nonce and PKCE verifier stay in an HttpOnly cookie until the callback.
+// With ?session=cookie the callback starts a cookie session.
+func FederatedLogin(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	provider, err := identityProviderRepo.FindIdentityProviderById(id)
+	if err != nil || provider.Disabled || !tokens.PrincipalIsActive(provider.OrgID, constants.ORG) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Identity Provider Not Found"})
+	}
+
+	discovery, err := oidc.Discover(provider.Issuer)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "error", "message": "Identity provider unavailable"})
+	}
+
+	loginState, signedState, err := oidc.NewLoginState(provider.ID, sessionCookie.Requested(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	setLoginStateCookie(c, signedState, time.Now().Add(oidc.LoginStateTTL))
+
+	return c.Redirect(oidc.AuthorizationURL(discovery, provider, loginState), fiber.StatusFound)
+}
+
+// FederatedCallback finishes a login at the identity provider. The identity
+// is matched to a user of the provider's org, either by an earlier link, by a
+// verified email or, if the provider allows it, by creating the user.
+func FederatedCallback(c *fiber.Ctx) error {
+	var input identityProviderSchema.CallbackInput
+	if err := c.QueryParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	signedState := c.Cookies(loginStateCookie)
+	setLoginStateCookie(c, "", time.Unix(0, 0))
+
+	loginState, err := oidc.ParseLoginState(signedState)
+	if err != nil || input.State == "" || subtle.ConstantTimeCompare([]byte(input.State), []byte(loginState.State)) != 1 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired login. Start again"})
+	}
+
+	if input.Error != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "error", "message": "Login failed at the identity provider", "error": input.Error, "error_description": input.ErrorDescription})
+	}
+
+	provider, err := identityProviderRepo.FindIdentityProviderById(loginState.ProviderID)
+	if err != nil || provider.Disabled || !tokens.PrincipalIsActive(provider.OrgID, constants.ORG) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Identity Provider Not Found"})
+	}
+
+	discovery, err := oidc.Discover(provider.Issuer)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "error", "message": "Identity provider unavailable"})
+	}
+
+	idToken, err := oidc.Exchange(discovery, provider, input.Code, loginState.CodeVerifier)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "error", "message": "Identity provider unavailable"})
+	}
+
+	claims, err := oidc.VerifyIDToken(discovery, provider, idToken, loginState.Nonce)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "error", "message": "Invalid ID token"})
+	}
+
+	user, status, message := federatedUser(c, provider, claims)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if _, err := groupMapping.Sync(user, provider.GroupMappings, oidc.ClaimStrings(claims, provider.GroupsClaim)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	// The provider is in charge of how the user authenticated, MFA included
+	return sendTokens(c, tokens.TokenRequest{
+		Subject:     user.ID,
+		SubjectType: constants.USER,
+		AuthMethods: []string{"fed"},
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+		IP:          c.IP(),
+	}, loginState.CookieSession, nil)
+}
+
+// federatedUser finds or creates the user an upstream identity signs in as.
+func federatedUser(c *fiber.Ctx, provider model.IdentityProvider, claims jwt.MapClaims) (model.User, int, string) {
+	subject := oidc.ClaimString(claims, "sub")
+
+	identity, err := identityProviderRepo.FindFederatedIdentity(provider.ID, subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+	if err == nil {
+		user, err := userRepo.FindUserByIdWithPassword(identity.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+		}
+		if err == nil && user.OrgID == provider.OrgID {
+			if err := identityProviderRepo.TouchFederatedIdentity(identity.ID, time.Now()); err != nil {
+				fmt.Println("Error updating federated identity:", err)
+			}
+			return activeFederatedUser(user)
+		}
+
+		// The user was deleted since it was linked
+		if err := identityProviderRepo.DeleteFederatedIdentity(identity); err != nil {
+			return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+		}
+	}
+
+	email := strings.ToLower(oidc.ClaimString(claims, provider.EmailClaim))
+
+	// An address the provider vouches for links the identity to the one user
+	// of the org that has it
+	if email != "" && oidc.ClaimTrue(claims, "email_verified") {
+		users, err := userRepo.FindUsersByOrgAndEmail(email, provider.OrgID)
+		if err != nil {
+			return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+		}
+		if len(users) == 1 {
+			user, err := userRepo.FindUserByIdWithPassword(users[0].ID)
+			if err != nil {
+				return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+			}
+			if err := linkFederatedIdentity(c, provider, subject, user.ID, audit.FederatedLinked); err != nil {
+				return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+			}
+			return activeFederatedUser(user)
+		}
+	}
+
+	if !provider.AutoProvision {
+		return model.User{}, fiber.StatusForbidden, "No account of the org is linked to this identity. Contact your admin"
+	}
+
+	username := strings.ToLower(oidc.ClaimString(claims, provider.UsernameClaim))
+	if username == "" {
+		username = email
+	}
+	if username == "" {
+		return model.User{}, fiber.StatusBadRequest, "The identity provider did not send a username"
+	}
+
+	if _, err := userRepo.FindUserByOrgAndUsernameWithPassword(username, provider.OrgID.String()); err == nil {
+		return model.User{}, fiber.StatusConflict, fmt.Sprintf("A user named %q already exists. Contact your admin to link the account", username)
+	}
+
+	// Provisioned users have no password; they sign in through the provider
+	now := time.Now()
+	createdUser, err := userRepo.CreateUser(model.User{
+		Username:          username,
+		Email:             email,
+		OrgID:             provider.OrgID,
+		AccountStatus:     constants.ACTIVATED,
+		PasswordChangedAt: &now,
+	})
+	if err != nil {
+		return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+
+	if err := linkFederatedIdentity(c, provider, subject, createdUser.ID, audit.FederatedProvisioned); err != nil {
+		return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(createdUser.ID)
+	if err != nil {
+		return model.User{}, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+
+	return activeFederatedUser(user)
+}
+
+func activeFederatedUser(user model.User) (model.User, int, string) {
+	if user.AccountStatus == constants.DEACTIVATED || user.AccountStatus == constants.INVITED || !tokens.PrincipalIsActive(user.ID, constants.USER) {
+		return model.User{}, fiber.StatusForbidden, "Account deactivated. Contact your admin"
+	}
+	return user, fiber.StatusOK, ""
+}
+
+func linkFederatedIdentity(c *fiber.Ctx, provider model.IdentityProvider, subject string, userId uuid.UUID, action string) error {
+	now := time.Now()
+	_, err := identityProviderRepo.CreateFederatedIdentity(model.FederatedIdentity{
+		ProviderID:  provider.ID,
+		Subject:     subject,
+		UserID:      userId,
+		LastLoginAt: &now,
+	})
+	if err != nil {
+		return err
+	}
+
+	audit.Record(model.AuditLog{
+		OrgID:      provider.OrgID,
+		Action:     action,
+		TargetID:   userId,
+		TargetType: constants.USER,
+		IP:         c.IP(),
+		Details:    fmt.Sprintf("%s (%s) subject %s", provider.Name, provider.Issuer, subject),
+	})
+
+	return nil
+}
+
+func setLoginStateCookie(c *fiber.Ctx, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     loginStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   sessionCookie.Secure(),
+		// Lax, so the cookie comes along on the provider's redirect back
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
@@ -0,0 +1,86 @@
+package authHandler
+
+import (
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	authSchema "balkantask/schemas/auth"
+	orgSchema "balkantask/schemas/org"
+	constants "balkantask/utils"
+	"balkantask/utils/audit"
+	"balkantask/utils/tokens"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Impersonate lets the org root or an ORG_FULL_ACCESS user act as a user of
+// the org, e.g. to reproduce what support was told about. The token names the
+// admin in its act claim, cannot be refreshed and is written to the audit log
+// together with the reason given.
+func Impersonate(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	var payload authSchema.ImpersonateInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	// Only a login token names the admin; API keys cannot impersonate
+	actorId, _, ok := sessionCaller(c)
+	orgId, isAdmin := orgAdminId(c)
+	if !ok || !isAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	actor := tokens.Actor{ID: actorId, Type: constants.USER}
+	if _, ok := c.Locals("org").(orgSchema.OrgResponse); ok {
+		actor.Type = constants.ORG
+	}
+
+	if id == actorId {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Cannot impersonate yourself"})
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(id)
+	if err != nil || user.OrgID != orgId || user.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "User Not Found"})
+	}
+
+	if !tokens.PrincipalIsActive(user.ID, constants.USER) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Account is deactivated"})
+	}
+
+	tokenPair, err := tokens.IssueImpersonationToken(tokens.TokenRequest{
+		Subject:     user.ID,
+		SubjectType: constants.USER,
+		AuthMethods: []string{"imp"},
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+		IP:          c.IP(),
+		Actor:       &actor,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	audit.Record(model.AuditLog{
+		OrgID:      orgId,
+		ActorID:    actor.ID,
+		ActorType:  actor.Type,
+		Action:     audit.ImpersonationStarted,
+		TargetID:   user.ID,
+		TargetType: constants.USER,
+		IP:         c.IP(),
+		Details:    fmt.Sprintf("For %s: %s", tokens.ImpersonationTTL(), payload.Reason),
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "token": tokenPair.AccessToken, "expires_in": tokenPair.ExpiresIn})
+}
@@ -0,0 +1,82 @@
+package authHandler
+
+import (
+	invitationRepo "balkantask/database/invitation"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	invitationSchema "balkantask/schemas/invitation"
+	constants "balkantask/utils"
+	"balkantask/utils/hashing"
+	"balkantask/utils/password"
+	"balkantask/utils/tokens"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AcceptInvitation activates an invited user with the password it chose. The
+// token from the link works once; the user logs in normally afterwards.
+func AcceptInvitation(c *fiber.Ctx) error {
+	var payload invitationSchema.AcceptInvitationInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	if payload.Password != payload.ConfirmPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Password and password confirmation do not match"})
+	}
+
+	tokenHash := tokens.HashToken(payload.Token)
+	invitation, err := invitationRepo.FindInvitationByHash(tokenHash)
+	if err != nil || invitation.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired invitation"})
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(invitation.UserID)
+	if err != nil || user.AccountStatus != constants.INVITED || (user.Org != nil && user.Org.AccountStatus == constants.DELETED) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired invitation"})
+	}
+
+	policy, err := password.PolicyForOrg(user.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	if violation := password.Violation(policy, payload.Password); violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": violation})
+	}
+
+	used, err := invitationRepo.UseInvitation(invitation.ID, tokenHash)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if !used {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired invitation"})
+	}
+
+	hashedPassword, err := hashing.Hash(payload.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	now := time.Now()
+	user.Password = hashedPassword
+	user.PasswordChangedAt = &now
+	user.AccountStatus = constants.ACTIVATED
+
+	if _, err := userRepo.UpdateUser(user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update password"})
+	}
+
+	if err := password.Remember(user.ID, constants.USER, hashedPassword); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Invitation accepted. You can now log in", "data": fiber.Map{"username": user.Username, "account_id": user.OrgID}})
+}
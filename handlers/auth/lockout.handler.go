@@ -0,0 +1,40 @@
+package authHandler
+
+import (
+	"balkantask/utils/lockout"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tooManyAttempts asks the client to slow down after recent failed logins.
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, retryAfterSeconds(wait))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"status": "false", "message": "Too many failed login attempts. Try again later"})
+}
+
+// accountLocked rejects a login for an account locked by failed attempts.
+func accountLocked(c *fiber.Ctx, account lockout.Account) error {
+	c.Set(fiber.HeaderRetryAfter, retryAfterSeconds(lockout.LockedFor(account.ID)))
+	return c.Status(fiber.StatusLocked).JSON(fiber.Map{"status": "false", "message": "Account locked after too many failed login attempts. Try again later or contact your admin"})
+}
+
+// loginThrottled checks an account before its password is verified. A lock
+// that has run out is lifted on the way.
+func loginThrottled(c *fiber.Ctx, account lockout.Account, locked bool) (bool, error) {
+	if locked && !lockout.ReleaseExpiredLock(account) {
+		return true, accountLocked(c, account)
+	}
+
+	if wait := lockout.RetryAfter(account.ID, c.IP()); wait > 0 {
+		return true, tooManyAttempts(c, wait)
+	}
+
+	return false, nil
+}
+
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
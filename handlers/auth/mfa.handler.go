@@ -0,0 +1,462 @@
+package authHandler
+
+import (
+	mfaRepo "balkantask/database/mfa"
+	orgRepo "balkantask/database/org"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	apiKeySchema "balkantask/schemas/apiKey"
+	authSchema "balkantask/schemas/auth"
+	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/lockout"
+	"balkantask/utils/mfa"
+	"balkantask/utils/permissions"
+	"balkantask/utils/sessionCookie"
+	"balkantask/utils/tokens"
+	"balkantask/utils/totp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// mfaPrincipal is the account a second factor belongs to. Only users and org
+// roots can enroll; service accounts and API keys have no interactive login.
+type mfaPrincipal struct {
+	ID      uuid.UUID
+	Type    constants.PrincipalType
+	Account string
+	OrgID   uuid.UUID
+}
+
+// VerifyMFALogin completes a login with the challenge token returned by the
+// password step and a TOTP or recovery code.
+func VerifyMFALogin(c *fiber.Ctx) error {
+	var payload authSchema.MFALoginInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := challengePrincipal(payload.MFAToken, tokens.MFAChallengeUse)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired MFA token"})
+	}
+
+	// Codes are guessed against the same lockout as passwords
+	account := lockout.Account{ID: principal.ID, Type: principal.Type, OrgID: principal.OrgID}
+	if lockout.LockedFor(principal.ID) > 0 {
+		return accountLocked(c, account)
+	}
+	if wait := lockout.RetryAfter(principal.ID, c.IP()); wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	factor, err := mfaRepo.FindConfirmedFactorBySubject(principal.ID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired MFA token"})
+	}
+
+	authMethods, ok, err := mfa.VerifyCode(factor, payload.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if !ok {
+		if lockout.RecordFailure(account, c.IP()) {
+			return accountLocked(c, account)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid code"})
+	}
+
+	lockout.RecordSuccess(principal.ID)
+
+	return respondWithTokens(c, tokens.TokenRequest{
+		Subject:     principal.ID,
+		SubjectType: principal.Type,
+		AuthMethods: append([]string{"pwd"}, authMethods...),
+	})
+}
+
+// EnrollMFAChallenge starts enrollment for an account that has to set up MFA
+// before its first login completes.
+func EnrollMFAChallenge(c *fiber.Ctx) error {
+	var payload authSchema.MFAEnrollInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := challengePrincipal(payload.MFAToken, tokens.MFAEnrollmentUse)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired MFA token"})
+	}
+
+	return startEnrollment(c, principal)
+}
+
+// ConfirmMFAChallenge confirms the enrollment started with
+// EnrollMFAChallenge and completes the login.
+func ConfirmMFAChallenge(c *fiber.Ctx) error {
+	var payload authSchema.MFALoginInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := challengePrincipal(payload.MFAToken, tokens.MFAEnrollmentUse)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired MFA token"})
+	}
+
+	// Checked before the factor is confirmed, so the recovery codes are not lost
+	expired, err := passwordExpired(principal.ID, principal.Type)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if expired {
+		return expiredPassword(c, principal.ID, principal.Type)
+	}
+
+	recoveryCodes, status, message := confirmEnrollment(principal, payload.Code)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "false", "message": message})
+	}
+
+	return sendTokens(c, tokens.TokenRequest{
+		Subject:     principal.ID,
+		SubjectType: principal.Type,
+		AuthMethods: []string{"pwd", "mfa", "otp"},
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+		IP:          c.IP(),
+	}, sessionCookie.Requested(c), fiber.Map{"recovery_codes": recoveryCodes})
+}
+
+func EnrollMFA(c *fiber.Ctx) error {
+	principal, ok := currentMFAPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	return startEnrollment(c, principal)
+}
+
+func ConfirmMFA(c *fiber.Ctx) error {
+	var payload authSchema.MFACodeInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := currentMFAPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	recoveryCodes, status, message := confirmEnrollment(principal, payload.Code)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "false", "message": message})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "MFA enabled", "data": fiber.Map{"recovery_codes": recoveryCodes}})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes. A current code is
+// required so a stolen session alone cannot do it.
+func RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var payload authSchema.MFACodeInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := currentMFAPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	factor, err := mfaRepo.FindConfirmedFactorBySubject(principal.ID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "MFA is not enabled"})
+	}
+
+	_, ok, err = mfa.VerifyCode(factor, payload.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid code"})
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes(factor)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"recovery_codes": recoveryCodes}})
+}
+
+func DisableMFA(c *fiber.Ctx) error {
+	var payload authSchema.MFACodeInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	principal, ok := currentMFAPrincipal(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	if orgRequiresMFA(principal.OrgID) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "MFA is required by your organisation"})
+	}
+
+	factor, err := mfaRepo.FindConfirmedFactorBySubject(principal.ID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": "MFA is not enabled"})
+	}
+
+	_, ok, err = mfa.VerifyCode(factor, payload.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid code"})
+	}
+
+	if err := mfaRepo.DeleteFactor(principal.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "MFA disabled"})
+}
+
+// ResetMFA removes the second factor of a user who lost it, so they can
+// enroll again on their next login. Their current sessions are ended.
+func ResetMFA(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	orgId, ok := orgAdminId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	user, err := userRepo.FindUserById(id)
+	if err != nil || user.OrgId != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "false", "message": "User Not Found"})
+	}
+
+	if err := mfaRepo.DeleteFactor(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	if err := tokens.RevokeAllTokens(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "MFA reset"})
+}
+
+// SetMFARequirement turns mandatory MFA on or off for everyone in the org.
+// Accounts without a factor have to enroll on their next login.
+func SetMFARequirement(c *fiber.Ctx) error {
+	var payload authSchema.MFARequirementInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	orgId, ok := orgAdminId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	if err := orgRepo.SetRequireMFA(orgId, *payload.Required); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"require_mfa": *payload.Required}})
+}
+
+// completeSignIn runs after a correct password. Accounts with MFA, or in an
+// org requiring it, get a challenge token instead of the real tokens.
+func completeSignIn(c *fiber.Ctx, subject uuid.UUID, subjectType constants.PrincipalType, mfaRequired bool) error {
+	_, err := mfaRepo.FindConfirmedFactorBySubject(subject)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	if err == nil || mfaRequired {
+		use, status := tokens.MFAChallengeUse, "mfa_required"
+		if err != nil {
+			use, status = tokens.MFAEnrollmentUse, "mfa_enrollment_required"
+		}
+
+		mfaToken, err := tokens.GenerateChallengeToken(subject, subjectType, use)
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "false", "message": "Internal Server Error"})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": status, "mfa_token": mfaToken})
+	}
+
+	return respondWithTokens(c, tokens.TokenRequest{Subject: subject, SubjectType: subjectType, AuthMethods: []string{"pwd"}})
+}
+
+func respondWithTokens(c *fiber.Ctx, request tokens.TokenRequest) error {
+	expired, err := passwordExpired(request.Subject, request.SubjectType)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if expired {
+		return expiredPassword(c, request.Subject, request.SubjectType)
+	}
+
+	request.UserAgent = c.Get(fiber.HeaderUserAgent)
+	request.IP = c.IP()
+
+	return sendTokens(c, request, sessionCookie.Requested(c), nil)
+}
+
+func startEnrollment(c *fiber.Ctx, principal mfaPrincipal) error {
+	_, err := mfaRepo.FindConfirmedFactorBySubject(principal.ID)
+	if err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "false", "message": "MFA is already enabled"})
+	}
+	if err != gorm.ErrRecordNotFound {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	_, err = mfaRepo.UpsertPendingFactor(model.MFAFactor{
+		SubjectID:   principal.ID,
+		SubjectType: principal.Type,
+		Secret:      secret,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totp.KeyURI(mfa.Issuer(), principal.Account, secret),
+	}})
+}
+
+// confirmEnrollment activates a pending factor once the first code from the
+// authenticator app checks out, and hands out the recovery codes.
+func confirmEnrollment(principal mfaPrincipal, code string) ([]string, int, string) {
+	factor, err := mfaRepo.FindFactorBySubject(principal.ID)
+	if err != nil || factor.ConfirmedAt != nil {
+		return nil, fiber.StatusBadRequest, "No pending MFA enrollment"
+	}
+
+	step, ok := totp.Validate(factor.Secret, code, time.Now())
+	if !ok {
+		return nil, fiber.StatusUnauthorized, "Invalid code"
+	}
+
+	if err := mfaRepo.ConfirmFactor(factor.ID, step); err != nil {
+		return nil, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes(factor)
+	if err != nil {
+		return nil, fiber.StatusInternalServerError, "Internal Server Error"
+	}
+
+	return recoveryCodes, fiber.StatusOK, ""
+}
+
+func challengePrincipal(mfaToken string, use string) (mfaPrincipal, bool) {
+	subject, subjectType, err := tokens.ParseChallengeToken(mfaToken, use)
+	if err != nil || !tokens.PrincipalIsActive(subject, subjectType) {
+		return mfaPrincipal{}, false
+	}
+
+	switch subjectType {
+	case constants.USER:
+		user, err := userRepo.FindUserById(subject)
+		return mfaPrincipal{ID: user.ID, Type: constants.USER, Account: user.Username, OrgID: user.OrgId}, err == nil
+	case constants.ORG:
+		org, err := orgRepo.FindOrgById(subject)
+		return mfaPrincipal{ID: org.ID, Type: constants.ORG, Account: org.Email, OrgID: org.ID}, err == nil
+	}
+
+	return mfaPrincipal{}, false
+}
+
+func currentMFAPrincipal(c *fiber.Ctx) (mfaPrincipal, bool) {
+	if _, ok := c.Locals("apiKey").(apiKeySchema.APIKeyResponse); ok {
+		return mfaPrincipal{}, false
+	}
+
+	if org, ok := c.Locals("org").(orgSchema.OrgResponse); ok {
+		return mfaPrincipal{ID: org.ID, Type: constants.ORG, Account: org.Email, OrgID: org.ID}, true
+	}
+
+	if user, ok := c.Locals("user").(userSchema.UserResponse); ok {
+		return mfaPrincipal{ID: user.ID, Type: constants.USER, Account: user.Username, OrgID: user.OrgId}, true
+	}
+
+	return mfaPrincipal{}, false
+}
+
+// orgAdminId returns the org of a caller allowed to change its security
+// settings: the org root or a user with the org:admin permission.
+func orgAdminId(c *fiber.Ctx) (uuid.UUID, bool) {
+	if _, ok := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse); ok {
+		return uuid.Nil, false
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok || !permissions.Granted(c, permissions.OrgAdmin) {
+		return uuid.Nil, false
+	}
+
+	return orgId, true
+}
+
+func orgRequiresMFA(orgId uuid.UUID) bool {
+	org, err := orgRepo.FindOrgById(orgId)
+	return err == nil && org.RequireMFA
+}
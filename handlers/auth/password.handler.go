@@ -0,0 +1,398 @@
+package authHandler
+
+import (
+	ldapRepo "balkantask/database/ldap"
+	orgRepo "balkantask/database/org"
+	tokensRepo "balkantask/database/tokens"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	authSchema "balkantask/schemas/auth"
+	orgSchema "balkantask/schemas/org"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/hashing"
+	"balkantask/utils/ldapSync"
+	"balkantask/utils/lockout"
+	"balkantask/utils/notifier"
+	"balkantask/utils/password"
+	"balkantask/utils/tokens"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// The response never tells whether the account exists
+const passwordResetSentMessage = "If the account exists and has an email address, a password reset link has been sent"
+
+func ForgotPassword(c *fiber.Ctx) error {
+	var payload userSchema.ForgotPasswordInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	user, err := userRepo.FindUserByOrgAndUsernameWithPassword(strings.ToLower(payload.Username), payload.AccountId)
+	// Passwords checked by the directory are reset there
+	_, bound := bindConnector(user)
+	if err == nil && user.Email != "" && tokens.PrincipalIsActive(user.ID, constants.USER) && !passwordLoginDisabled(user.OrgID) && !bound {
+		go sendPasswordReset(user.ID, constants.USER, user.Email)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": passwordResetSentMessage})
+}
+
+func ForgotPasswordRoot(c *fiber.Ctx) error {
+	var payload orgSchema.ForgotPasswordInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	org, err := orgRepo.FindOrgByEmail(strings.ToLower(payload.Email))
+	if err == nil && tokens.PrincipalIsActive(org.ID, constants.ORG) {
+		go sendPasswordReset(org.ID, constants.ORG, org.Email)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": passwordResetSentMessage})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. Every
+// session of the account is ended afterwards.
+func ResetPassword(c *fiber.Ctx) error {
+	var payload authSchema.ResetPasswordInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	if payload.Password != payload.ConfirmPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Password and password confirmation do not match"})
+	}
+
+	resetToken, err := tokensRepo.FindPasswordResetTokenByHash(tokens.HashToken(payload.Token))
+	if err != nil || resetToken.UsedAt != nil || resetToken.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired reset token"})
+	}
+
+	if !tokens.PrincipalIsActive(resetToken.SubjectID, resetToken.SubjectType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired reset token"})
+	}
+
+	var currentPassword string
+	var orgId uuid.UUID
+	var user model.User
+	var org model.Org
+
+	switch resetToken.SubjectType {
+	case constants.USER:
+		user, err = userRepo.FindUserByIdWithPassword(resetToken.SubjectID)
+		currentPassword, orgId = user.Password, user.OrgID
+	case constants.ORG:
+		org, err = orgRepo.FindOrgById(resetToken.SubjectID)
+		currentPassword, orgId = org.Password, org.ID
+	}
+	if err != nil || currentPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired reset token"})
+	}
+
+	if hashing.Verify(payload.Password, currentPassword) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "New password cannot be the same as the old password"})
+	}
+
+	policy, err := password.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	violation, err := password.Check(policy, resetToken.SubjectID, payload.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": violation})
+	}
+
+	used, err := tokensRepo.UsePasswordResetTokens(resetToken.SubjectID, resetToken.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if !used {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid or expired reset token"})
+	}
+
+	hashedPassword, err := hashing.Hash(payload.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	now := time.Now()
+	switch resetToken.SubjectType {
+	case constants.USER:
+		user.Password = hashedPassword
+		user.PasswordChangedAt = &now
+		user.MustChangePassword = false
+		_, err = userRepo.UpdateUser(user)
+	case constants.ORG:
+		org.Password = hashedPassword
+		org.PasswordChangedAt = &now
+		_, err = orgRepo.UpdateOrg(org)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update password"})
+	}
+
+	if err := password.Remember(resetToken.SubjectID, resetToken.SubjectType, hashedPassword); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	if err := tokens.RevokeAllTokens(resetToken.SubjectID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	// Proving control of the mailbox also lifts a lockout
+	err = lockout.Unlock(lockout.Account{ID: resetToken.SubjectID, Type: resetToken.SubjectType, OrgID: orgId}, model.AuditLog{
+		ActorID:   resetToken.SubjectID,
+		ActorType: resetToken.SubjectType,
+		IP:        c.IP(),
+		Details:   "Password reset",
+	})
+	if err != nil {
+		fmt.Println("Error unlocking account:", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Password updated successfully"})
+}
+
+// ChangeRequiredPassword replaces a password set by an admin with one the user
+// chooses, using the token SignInUser returned instead of tokens. The login
+// then continues as usual, with MFA if the user or org has it.
+func ChangeRequiredPassword(c *fiber.Ctx) error {
+	var payload authSchema.ChangeRequiredPasswordInput
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	if payload.Password != payload.ConfirmPassword {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Password and password confirmation do not match"})
+	}
+
+	subject, subjectType, err := tokens.ParseChallengeToken(payload.PasswordChangeToken, tokens.PasswordChangeUse)
+	if err != nil || subjectType != constants.USER || !tokens.PrincipalIsActive(subject, subjectType) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired password change token"})
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(subject)
+	if err != nil || !user.MustChangePassword {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired password change token"})
+	}
+
+	if hashing.Verify(payload.Password, user.Password) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "New password cannot be the same as the old password"})
+	}
+
+	policy, err := password.PolicyForOrg(user.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	violation, err := password.Check(policy, user.ID, payload.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	if violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": violation})
+	}
+
+	hashedPassword, err := hashing.Hash(payload.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	changed, err := userRepo.ReplaceRequiredPassword(user.ID, user.Password, hashedPassword, time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Failed to update password"})
+	}
+	if !changed {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid or expired password change token"})
+	}
+
+	if err := password.Remember(user.ID, constants.USER, hashedPassword); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	return completeSignIn(c, user.ID, constants.USER, orgRequiresMFA(user.OrgID))
+}
+
+// passwordChangeRequired answers the login of a user whose password was set
+// by an admin. Instead of tokens it gets a short-lived token that is only
+// accepted by /api/auth/password/change.
+func passwordChangeRequired(c *fiber.Ctx, subject uuid.UUID) error {
+	changeToken, err := tokens.GenerateChallengeToken(subject, constants.USER, tokens.PasswordChangeUse)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "password_change_required", "message": "Password was set by an admin. Choose a new one to continue", "password_change_token": changeToken})
+}
+
+// sendPasswordReset runs in the background so response times do not reveal
+// whether an account exists.
+func sendPasswordReset(subject uuid.UUID, subjectType constants.PrincipalType, email string) {
+	token, err := tokens.IssuePasswordResetToken(subject, subjectType)
+	if err != nil {
+		fmt.Println("Error issuing password reset token:", err)
+		return
+	}
+
+	link := passwordResetURL() + "?token=" + url.QueryEscape(token)
+
+	err = notifier.Send(notifier.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, open the link below within %s to choose a new password:\n\n%s\n\nIf you did not ask for this, you can ignore this message.",
+			tokens.PasswordResetTTL(), link),
+	})
+	if err != nil {
+		fmt.Println("Error sending password reset:", err)
+	}
+}
+
+// passwordResetURL is the page that reads the token from the link and posts
+// it to /api/auth/password/reset together with the new password.
+func passwordResetURL() string {
+	resetURL := os.Getenv("PASSWORD_RESET_URL")
+	if resetURL == "" {
+		return tokens.Issuer() + "/reset-password"
+	}
+	return resetURL
+}
+
+// checkUserPassword verifies the password of a user. Users synced from a
+// directory that checks passwords are verified by binding as their entry; the
+// second result tells whether that was the case.
+func checkUserPassword(user model.User, plain string) (bool, bool, error) {
+	connector, ok := bindConnector(user)
+	if !ok {
+		return hashing.Verify(plain, user.Password), false, nil
+	}
+
+	verified, err := ldapSync.Authenticate(connector, user.LDAPDN, plain)
+	return verified, true, err
+}
+
+// bindConnector returns the connector that checks the password of a synced
+// user, if there is one.
+func bindConnector(user model.User) (model.LDAPConnector, bool) {
+	if user.LDAPDN == "" {
+		return model.LDAPConnector{}, false
+	}
+
+	connector, err := ldapRepo.FindLDAPConnectorByOrgId(user.OrgID)
+	if err != nil || !connector.BindAuth {
+		return model.LDAPConnector{}, false
+	}
+	return connector, true
+}
+
+// passwordLoginDisabled reports whether users of the org have to sign in
+// through an identity provider.
+func passwordLoginDisabled(orgId uuid.UUID) bool {
+	org, err := orgRepo.FindOrgById(orgId)
+	return err == nil && org.DisablePasswordLogin
+}
+
+// passwordExpired reports whether the password of the principal is older
+// than the max age of its org's policy.
+func passwordExpired(subject uuid.UUID, subjectType constants.PrincipalType) (bool, error) {
+	var orgId uuid.UUID
+	var changedAt *time.Time
+
+	switch subjectType {
+	case constants.USER:
+		user, err := userRepo.FindUserByIdWithPassword(subject)
+		if err != nil {
+			return false, err
+		}
+		orgId, changedAt = user.OrgID, user.PasswordChangedAt
+		if changedAt == nil {
+			changedAt = user.CreatedAt
+		}
+	case constants.ORG:
+		org, err := orgRepo.FindOrgById(subject)
+		if err != nil {
+			return false, err
+		}
+		orgId, changedAt = org.ID, org.PasswordChangedAt
+		if changedAt == nil {
+			changedAt = org.CreatedAt
+		}
+	default:
+		return false, nil
+	}
+
+	policy, err := password.PolicyForOrg(orgId)
+	if err != nil || changedAt == nil {
+		return false, err
+	}
+
+	return password.Expired(policy, *changedAt), nil
+}
+
+// expiredPassword answers a login with an expired password. The caller has
+// just proven it knows the password, so instead of tokens it gets a reset
+// token to choose a new one at /api/auth/password/reset.
+func expiredPassword(c *fiber.Ctx, subject uuid.UUID, subjectType constants.PrincipalType) error {
+	resetToken, err := tokens.IssuePasswordResetToken(subject, subjectType)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "password_expired", "message": "Password expired. Choose a new one to continue", "reset_token": resetToken})
+}
+
+// rehashPassword upgrades the stored hash of a password that was just
+// verified when it was made with an older algorithm or weaker parameters. A
+// failure is only logged; the upgrade is tried again at the next login.
+func rehashPassword(subject uuid.UUID, subjectType constants.PrincipalType, encoded string, plain string) {
+	if !hashing.NeedsRehash(encoded) {
+		return
+	}
+
+	hash, err := hashing.Hash(plain)
+	if err != nil {
+		fmt.Println("Error rehashing password:", err)
+		return
+	}
+
+	switch subjectType {
+	case constants.USER:
+		err = userRepo.UpdateUserPasswordHash(subject, encoded, hash)
+	case constants.ORG:
+		err = orgRepo.UpdateOrgPasswordHash(subject, encoded, hash)
+	}
+	if err != nil {
+		fmt.Println("Error rehashing password:", err)
+	}
+}
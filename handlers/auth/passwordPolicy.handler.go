@@ -0,0 +1,83 @@
+package authHandler
+
+import (
+	passwordRepo "balkantask/database/password"
+	"balkantask/model"
+	passwordPolicySchema "balkantask/schemas/passwordPolicy"
+	"balkantask/utils/password"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPasswordPolicy returns the password policy of the caller's org, so
+// clients can show the rules before a password is chosen.
+func GetPasswordPolicy(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	policy, err := password.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": passwordPolicySchema.MapPasswordPolicyRecord(&policy)})
+}
+
+// UpdatePasswordPolicy sets the password policy of the org. It applies to
+// every password set afterwards; a max age also applies to current passwords
+// at their next login.
+func UpdatePasswordPolicy(c *fiber.Ctx) error {
+	var payload passwordPolicySchema.UpdatePasswordPolicy
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(payload)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(errors)
+	}
+
+	orgId, ok := orgAdminId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	policy, err := passwordRepo.UpsertPasswordPolicy(model.PasswordPolicy{
+		OrgID:            orgId,
+		MinLength:        payload.MinLength,
+		RequireUppercase: payload.RequireUppercase,
+		RequireLowercase: payload.RequireLowercase,
+		RequireDigit:     payload.RequireDigit,
+		RequireSpecial:   payload.RequireSpecial,
+		BannedWords:      payload.BannedWords,
+		HistoryDepth:     payload.HistoryDepth,
+		MaxAgeDays:       payload.MaxAgeDays,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Password policy updated", "data": passwordPolicySchema.MapPasswordPolicyRecord(&policy)})
+}
+
+// DeletePasswordPolicy returns the org to the default policy.
+func DeletePasswordPolicy(c *fiber.Ctx) error {
+	orgId, ok := orgAdminId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	if err := passwordRepo.DeletePasswordPolicy(orgId); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	policy, err := password.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Password policy reset to the default", "data": passwordPolicySchema.MapPasswordPolicyRecord(&policy)})
+}
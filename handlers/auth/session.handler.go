@@ -0,0 +1,177 @@
+package authHandler
+
+import (
+	sessionRepo "balkantask/database/session"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	sessionSchema "balkantask/schemas/session"
+	constants "balkantask/utils"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
+	"balkantask/utils/tokens"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// GetSessions lists where the caller is logged in. The session of the token
+// used for the request is flagged as current.
+func GetSessions(c *fiber.Ctx) error {
+	subject, currentSession, ok := sessionCaller(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Sessions can only be managed with a login token"})
+	}
+
+	return respondWithSessions(c, subject, currentSession)
+}
+
+// RevokeSession ends one session of the caller. Org admins can also end a
+// session of any user of their org.
+func RevokeSession(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	subject, _, ok := sessionCaller(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Sessions can only be managed with a login token"})
+	}
+
+	session, err := sessionRepo.FindSessionById(id)
+	if err != nil || session.RevokedAt != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Session Not Found"})
+	}
+
+	if session.SubjectID != subject {
+		if _, status, message := sessionUser(c, session.SubjectID, permissions.UserWrite); status != fiber.StatusOK {
+			if status == fiber.StatusForbidden {
+				status, message = fiber.StatusNotFound, "Session Not Found"
+			}
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+	}
+
+	if err := tokens.RevokeSession(session.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Session revoked"})
+}
+
+// RevokeSessions ends every session of the caller, or every other session
+// with ?keep_current=true.
+func RevokeSessions(c *fiber.Ctx) error {
+	subject, currentSession, ok := sessionCaller(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Sessions can only be managed with a login token"})
+	}
+
+	if !c.QueryBool("keep_current") {
+		if err := tokens.RevokeAllTokens(subject); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "All sessions revoked"})
+	}
+
+	sessions, err := sessionRepo.FindActiveSessionsBySubject(subject)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	for _, session := range sessions {
+		if session.ID == currentSession {
+			continue
+		}
+		if err := tokens.RevokeSession(session.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Other sessions revoked"})
+}
+
+// GetUserSessions lets org admins see where a user of their org is logged in.
+func GetUserSessions(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	user, status, message := sessionUser(c, id, permissions.UserRead)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	return respondWithSessions(c, user.ID, uuid.Nil)
+}
+
+// RevokeUserSessions lets org admins end every session of a user of their org.
+func RevokeUserSessions(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Invalid ID"})
+	}
+
+	user, status, message := sessionUser(c, id, permissions.UserWrite)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if err := tokens.RevokeAllTokens(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "All sessions of the user revoked"})
+}
+
+func respondWithSessions(c *fiber.Ctx, subject uuid.UUID, currentSession uuid.UUID) error {
+	sessions, err := sessionRepo.FindActiveSessionsBySubject(subject)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	response := []sessionSchema.SessionResponse{}
+	for _, session := range sessions {
+		mapped := sessionSchema.MapSessionRecord(&session)
+		mapped.Current = session.ID == currentSession
+		response = append(response, mapped)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": response})
+}
+
+// sessionCaller returns the principal and the session of the access token.
+// Requests authenticated with an API key have neither.
+func sessionCaller(c *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	claims, ok := c.Locals("claims").(jwt.MapClaims)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	currentSession, _ := uuid.Parse(fmt.Sprint(claims["sid"]))
+	return subject, currentSession, true
+}
+
+// sessionUser loads a user whose sessions the caller administers: the caller
+// is the root of the user's org, or holds the permission in it.
+func sessionUser(c *fiber.Ctx, userId uuid.UUID, permission permissions.Permission) (model.User, int, string) {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok || !permissions.Authorize(c, permission, policy.Resource("user", userId)) {
+		return model.User{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(userId)
+	if err != nil || user.OrgID != orgId || user.AccountStatus == constants.DELETED {
+		return model.User{}, fiber.StatusNotFound, "User Not Found"
+	}
+
+	return user, fiber.StatusOK, ""
+}
@@ -5,8 +5,9 @@ import (
 	rolesRepo "balkantask/database/roles"
 	"balkantask/model"
 	groupSchema "balkantask/schemas/group"
-	orgSchema "balkantask/schemas/org"
 	userSchema "balkantask/schemas/user"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
 	"balkantask/utils/roles"
 	"encoding/csv"
 	"fmt"
@@ -21,22 +22,20 @@ import (
 )
 
 func GetAllGroups(c *fiber.Ctx) error {
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess, roles.OrgReadAccess, roles.GroupReadAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	groups, err := groupRepo.GetAllGroups()
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": groups})
+	// Leave out the groups a policy denies reading
+	readable := []model.Group{}
+	for _, group := range groups {
+		if permissions.Authorize(c, permissions.GroupRead, policy.Resource("group", group.ID)) {
+			readable = append(readable, group)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": readable})
 }
 
 func GetGroupById(c *fiber.Ctx) error {
@@ -49,16 +48,6 @@ func GetGroupById(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess, roles.OrgReadAccess, roles.GroupReadAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	group, err := groupRepo.GetGroupById(id)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
@@ -74,16 +63,6 @@ func CreateGroup(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	errors := model.ValidateStruct(group)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -93,6 +72,8 @@ func CreateGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
+
 	var rolesExist []model.Role
 	var err error
 	// Check if the roles (id) exist in the database
@@ -109,7 +90,7 @@ func CreateGroup(c *fiber.Ctx) error {
 	var rolesExist2 []model.Role
 	// Check if the roles (name) exist in the database
 	if len(group.RoleNames) > 0 {
-		rolesExist2, err = rolesRepo.GetRolesByNames(group.RoleNames)
+		rolesExist2, err = rolesRepo.GetRolesByNames(group.RoleNames, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Invalid Role Names",
@@ -118,6 +99,16 @@ func CreateGroup(c *fiber.Ctx) error {
 		}
 	}
 
+	// CUSTOM roles can only be given within their org
+	for _, role := range rolesExist {
+		if !roles.AvailableTo(role, orgId) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Role doesn't exist",
+				"status":  "error",
+			})
+		}
+	}
+
 	rolesExist = append(rolesExist, rolesExist2...)
 	rolesExist = roles.RemoveDuplicates(rolesExist)
 
@@ -174,16 +165,6 @@ func DeleteGroupById(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	groupExists, err := groupRepo.GetGroupById(id)
 
 	if err != nil || groupExists.ID == uuid.Nil {
@@ -217,16 +198,6 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.GroupFullAccess, roles.OrgWriteAccess, roles.GroupWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -236,6 +207,13 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := policy.ValidateConditions(input.Conditions); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+			"status":  "error",
+		})
+	}
+
 	// Check if the input contains Group ID or Group Name
 	var group model.Group
 	if input.GroupId != uuid.Nil {
@@ -256,6 +234,15 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	if !permissions.Authorize(c, permissions.GroupWrite, policy.Resource("group", group.ID)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	orgId, _ := permissions.CallerOrgId(c)
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -267,7 +254,7 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -281,6 +268,14 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	// CUSTOM roles can only be given within their org
+	if !roles.AvailableTo(role, orgId) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Role doesn't exist",
+			"status":  "error",
+		})
+	}
+
 	if roles.GroupHasRole(group.Roles, []model.Role{role}) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Group already has the role",
@@ -296,6 +291,14 @@ func AddRoleToGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	err = rolesRepo.SetRoleConditions(group.ID, role.ID, input.Conditions)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Role added to group",
 		"status":  "success",
@@ -313,16 +316,6 @@ func DeleteRoleFromGroup(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -352,6 +345,15 @@ func DeleteRoleFromGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	if !permissions.Authorize(c, permissions.GroupWrite, policy.Resource("group", group.ID)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	orgId, _ := permissions.CallerOrgId(c)
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -363,7 +365,7 @@ func DeleteRoleFromGroup(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -393,6 +395,14 @@ func DeleteRoleFromGroup(c *fiber.Ctx) error {
 		})
 	}
 
+	err = rolesRepo.DeleteRoleConditions(group.ID, role.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Role removed from group",
 		"status":  "success",
@@ -419,16 +429,6 @@ func SeedGroupsFromExcel(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	// Create a temporary file to save the uploaded content
 	tempFile, err := os.CreateTemp("", "upload-*.xlsx")
 	// CreateTemp function, it generates a unique temporary file name by replacing the asterisk (*) with a random string.
@@ -470,6 +470,7 @@ func SeedGroupsFromExcel(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
 	var createdGroups []model.Group
 
 	for rowIndex, row := range rows {
@@ -494,7 +495,7 @@ func SeedGroupsFromExcel(c *fiber.Ctx) error {
 		}
 
 		// Retrieve the roles from the database based on role names
-		rolesExist, err := rolesRepo.GetRolesByNames(roleNames)
+		rolesExist, err := rolesRepo.GetRolesByNames(roleNames, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": fmt.Sprintf("Invalid role names in row %d", rowIndex+1),
@@ -544,16 +545,6 @@ func SeedGroupsFromCSV(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.GroupWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.GroupFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	// Create a temporary file to save the uploaded content
 	tempFile, err := os.CreateTemp("", "upload-*.csv")
 	if err != nil {
@@ -595,6 +586,7 @@ func SeedGroupsFromCSV(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
 	var createdGroups []model.Group
 
 	for rowIndex := 1; ; rowIndex++ {
@@ -625,7 +617,7 @@ func SeedGroupsFromCSV(c *fiber.Ctx) error {
 		}
 
 		// Retrieve the roles from the database based on role names
-		rolesExist, err := rolesRepo.GetRolesByNames(roleNames)
+		rolesExist, err := rolesRepo.GetRolesByNames(roleNames, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": fmt.Sprintf("Invalid role names in row %d", rowIndex),
@@ -0,0 +1,282 @@
+package identityProviderHandler
+
+import (
+	groupRepo "balkantask/database/group"
+	identityProviderRepo "balkantask/database/identityProvider"
+	orgRepo "balkantask/database/org"
+	"balkantask/model"
+	identityProviderSchema "balkantask/schemas/identityProvider"
+	"balkantask/utils/oidc"
+	"balkantask/utils/permissions"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Claims read from the ID token when the provider does not name others
+const (
+	defaultUsernameClaim = "preferred_username"
+	defaultEmailClaim    = "email"
+	defaultGroupsClaim   = "groups"
+)
+
+var defaultScopes = []string{"openid", "profile", "email"}
+
+func GetIdentityProviders(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	providers, err := identityProviderRepo.FindIdentityProvidersByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	response := []identityProviderSchema.IdentityProviderResponse{}
+	for _, provider := range providers {
+		response = append(response, identityProviderSchema.MapIdentityProviderRecord(&provider, oidc.LoginURL(provider.ID)))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": response})
+}
+
+func GetIdentityProviderById(c *fiber.Ctx) error {
+	provider, status, message := managedProvider(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": identityProviderSchema.MapIdentityProviderRecord(&provider, oidc.LoginURL(provider.ID))})
+}
+
+// CreateIdentityProvider registers an upstream OpenID Connect provider. Its
+// discovery document is loaded right away, so a wrong issuer is caught here
+// rather than at the first login.
+func CreateIdentityProvider(c *fiber.Ctx) error {
+	var input identityProviderSchema.CreateIdentityProvider
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	provider := model.IdentityProvider{
+		OrgID:         orgId,
+		Name:          input.Name,
+		Issuer:        strings.TrimSuffix(input.Issuer, "/"),
+		ClientID:      input.ClientID,
+		ClientSecret:  input.ClientSecret,
+		Scopes:        input.Scopes,
+		UsernameClaim: withDefault(input.UsernameClaim, defaultUsernameClaim),
+		EmailClaim:    withDefault(input.EmailClaim, defaultEmailClaim),
+		GroupsClaim:   withDefault(input.GroupsClaim, defaultGroupsClaim),
+		GroupMappings: input.GroupMappings,
+		AutoProvision: input.AutoProvision,
+	}
+	if len(provider.Scopes) == 0 {
+		provider.Scopes = defaultScopes
+	}
+
+	if status, message := checkProvider(provider); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	createdProvider, err := identityProviderRepo.CreateIdentityProvider(provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "message": "Created", "data": identityProviderSchema.MapIdentityProviderRecord(&createdProvider, oidc.LoginURL(createdProvider.ID))})
+}
+
+func UpdateIdentityProvider(c *fiber.Ctx) error {
+	var input identityProviderSchema.UpdateIdentityProvider
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	provider, status, message := managedProvider(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	provider.Name = withDefault(input.Name, provider.Name)
+	provider.Issuer = strings.TrimSuffix(withDefault(input.Issuer, provider.Issuer), "/")
+	provider.ClientID = withDefault(input.ClientID, provider.ClientID)
+	provider.ClientSecret = withDefault(input.ClientSecret, provider.ClientSecret)
+	provider.UsernameClaim = withDefault(input.UsernameClaim, provider.UsernameClaim)
+	provider.EmailClaim = withDefault(input.EmailClaim, provider.EmailClaim)
+	provider.GroupsClaim = withDefault(input.GroupsClaim, provider.GroupsClaim)
+	if input.Scopes != nil {
+		provider.Scopes = input.Scopes
+	}
+	if input.GroupMappings != nil {
+		provider.GroupMappings = input.GroupMappings
+	}
+	if input.AutoProvision != nil {
+		provider.AutoProvision = *input.AutoProvision
+	}
+
+	if input.Disabled != nil && *input.Disabled && !provider.Disabled {
+		if status, message := checkLastProvider(provider.OrgID); status != fiber.StatusOK {
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+		provider.Disabled = true
+	} else if input.Disabled != nil {
+		provider.Disabled = *input.Disabled
+	}
+
+	if status, message := checkProvider(provider); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	updatedProvider, err := identityProviderRepo.UpdateIdentityProvider(provider)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Updated", "data": identityProviderSchema.MapIdentityProviderRecord(&updatedProvider, oidc.LoginURL(updatedProvider.ID))})
+}
+
+// DeleteIdentityProvider removes a provider and unlinks every identity of it.
+// Linked users are kept, but can only sign in with a password afterwards.
+func DeleteIdentityProvider(c *fiber.Ctx) error {
+	provider, status, message := managedProvider(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if !provider.Disabled {
+		if status, message := checkLastProvider(provider.OrgID); status != fiber.StatusOK {
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+	}
+
+	if err := identityProviderRepo.DeleteIdentityProvider(provider); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": true})
+}
+
+// SetPasswordLogin turns password login off or on for the users of the org.
+// It can only be turned off while users have a provider to sign in with.
+func SetPasswordLogin(c *fiber.Ctx) error {
+	var input identityProviderSchema.PasswordLoginInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	if *input.Disabled {
+		count, err := identityProviderRepo.CountEnabledIdentityProviders(orgId)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+		}
+		if count == 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": "Add an identity provider before disabling password login"})
+		}
+	}
+
+	if err := orgRepo.SetDisablePasswordLogin(orgId, *input.Disabled); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": fiber.Map{"disable_password_login": *input.Disabled}})
+}
+
+// checkProvider verifies the issuer serves a discovery document and every
+// mapped group exists.
+func checkProvider(provider model.IdentityProvider) (int, string) {
+	for upstream, group := range provider.GroupMappings {
+		if upstream == "" {
+			return fiber.StatusBadRequest, "Group mappings need an upstream group name"
+		}
+		if _, err := groupRepo.GetGroupByName(group); err != nil {
+			return fiber.StatusBadRequest, fmt.Sprintf("Group %q does not exist", group)
+		}
+	}
+
+	if !oidc.SecureIssuer(provider.Issuer) {
+		return fiber.StatusBadRequest, "Issuer must use https"
+	}
+
+	if _, err := oidc.Discover(provider.Issuer); err != nil {
+		return fiber.StatusBadRequest, "Could not load the OpenID configuration of the issuer"
+	}
+
+	return fiber.StatusOK, ""
+}
+
+// checkLastProvider refuses to take away the last provider of an org whose
+// users cannot sign in with a password.
+func checkLastProvider(orgId uuid.UUID) (int, string) {
+	org, err := orgRepo.FindOrgById(orgId)
+	if err != nil {
+		return fiber.StatusInternalServerError, "Internal Server Error"
+	}
+	if !org.DisablePasswordLogin {
+		return fiber.StatusOK, ""
+	}
+
+	count, err := identityProviderRepo.CountEnabledIdentityProviders(orgId)
+	if err != nil {
+		return fiber.StatusInternalServerError, "Internal Server Error"
+	}
+	if count <= 1 {
+		return fiber.StatusConflict, "Enable password login before removing the last identity provider"
+	}
+
+	return fiber.StatusOK, ""
+}
+
+func managedProvider(c *fiber.Ctx) (model.IdentityProvider, int, string) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return model.IdentityProvider{}, fiber.StatusBadRequest, "Invalid ID"
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return model.IdentityProvider{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	provider, err := identityProviderRepo.FindIdentityProviderById(id)
+	if err != nil || provider.OrgID != orgId {
+		return model.IdentityProvider{}, fiber.StatusNotFound, "Identity Provider Not Found"
+	}
+
+	return provider, fiber.StatusOK, ""
+}
+
+func withDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
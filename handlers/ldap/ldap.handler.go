@@ -0,0 +1,173 @@
+package ldapHandler
+
+import (
+	groupRepo "balkantask/database/group"
+	ldapRepo "balkantask/database/ldap"
+	"balkantask/model"
+	ldapSchema "balkantask/schemas/ldap"
+	"balkantask/utils/ldapSync"
+	"balkantask/utils/permissions"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Defaults fit an OpenLDAP directory with inetOrgPerson entries
+const (
+	defaultUserFilter           = "(objectClass=inetOrgPerson)"
+	defaultGroupFilter          = "(objectClass=groupOfNames)"
+	defaultUsernameAttribute    = "uid"
+	defaultEmailAttribute       = "mail"
+	defaultGroupNameAttribute   = "cn"
+	defaultGroupMemberAttribute = "member"
+)
+
+func GetLDAPConnector(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	connector, err := ldapRepo.FindLDAPConnectorByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "LDAP Connector Not Found"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": ldapSchema.MapLDAPConnectorRecord(&connector)})
+}
+
+// SaveLDAPConnector creates or replaces the connector of the org. The
+// directory is bound with the given credentials first, so a wrong URL or
+// password is caught here rather than at the next sync.
+func SaveLDAPConnector(c *fiber.Ctx) error {
+	var input ldapSchema.SaveLDAPConnector
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	validationErrors := model.ValidateStruct(input)
+	if validationErrors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": validationErrors})
+	}
+
+	if !strings.HasPrefix(input.URL, "ldap://") && !strings.HasPrefix(input.URL, "ldaps://") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "URL must start with ldap:// or ldaps://"})
+	}
+
+	// Passwords of users are sent to the directory on every login
+	if input.BindAuth && !input.StartTLS && !strings.HasPrefix(input.URL, "ldaps://") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bind authentication needs ldaps:// or StartTLS"})
+	}
+
+	connector, err := ldapRepo.FindLDAPConnectorByOrgId(orgId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	status := fiber.StatusOK
+	if err != nil {
+		connector = model.LDAPConnector{OrgID: orgId}
+		status = fiber.StatusCreated
+	}
+
+	if input.BindPassword != "" {
+		connector.BindPassword = input.BindPassword
+	}
+	if connector.BindPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "bindPassword is required"})
+	}
+
+	connector.URL = input.URL
+	connector.StartTLS = input.StartTLS
+	connector.BindDN = input.BindDN
+	connector.BaseDN = input.BaseDN
+	connector.UserFilter = withDefault(input.UserFilter, defaultUserFilter)
+	connector.GroupBaseDN = input.GroupBaseDN
+	connector.GroupFilter = withDefault(input.GroupFilter, defaultGroupFilter)
+	connector.UsernameAttribute = withDefault(input.UsernameAttribute, defaultUsernameAttribute)
+	connector.EmailAttribute = withDefault(input.EmailAttribute, defaultEmailAttribute)
+	connector.GroupNameAttribute = withDefault(input.GroupNameAttribute, defaultGroupNameAttribute)
+	connector.GroupMemberAttribute = withDefault(input.GroupMemberAttribute, defaultGroupMemberAttribute)
+	connector.GroupMappings = input.GroupMappings
+	connector.BindAuth = input.BindAuth
+	connector.SyncDisabled = input.SyncDisabled
+
+	for directoryGroup, group := range connector.GroupMappings {
+		if directoryGroup == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Group mappings need a directory group name"})
+		}
+		if _, err := groupRepo.GetGroupByName(group); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": fmt.Sprintf("Group %q does not exist", group)})
+		}
+	}
+
+	if err := ldapSync.CheckConnection(connector); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Could not bind to the directory: " + err.Error()})
+	}
+
+	savedConnector, err := ldapRepo.SaveLDAPConnector(connector)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(status).JSON(fiber.Map{"status": "success", "message": "Saved", "data": ldapSchema.MapLDAPConnectorRecord(&savedConnector)})
+}
+
+// DeleteLDAPConnector stops syncing. Synced users are kept, but can no longer
+// sign in by bind.
+func DeleteLDAPConnector(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	connector, err := ldapRepo.FindLDAPConnectorByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "LDAP Connector Not Found"})
+	}
+
+	if err := ldapRepo.DeleteLDAPConnector(connector); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "data": true})
+}
+
+// SyncLDAPDirectory runs a sync right away, e.g. after a change in the
+// directory that should not wait for the scheduler.
+func SyncLDAPDirectory(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	connector, err := ldapRepo.FindLDAPConnectorByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "LDAP Connector Not Found"})
+	}
+
+	result, err := ldapSync.Run(connector)
+	if errors.Is(err, ldapSync.ErrSyncRunning) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": "A sync is already running"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"status": "error", "message": "Sync failed: " + err.Error(), "data": result})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Synced", "data": result})
+}
+
+func withDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,280 @@
+package oauthHandler
+
+import (
+	orgRepo "balkantask/database/org"
+	serviceAccountRepo "balkantask/database/serviceAccount"
+	tokensRepo "balkantask/database/tokens"
+	userRepo "balkantask/database/user"
+	oauthSchema "balkantask/schemas/oauth"
+	constants "balkantask/utils"
+	"balkantask/utils/tokens"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// tokenOwner is the principal a token was issued to, as seen by introspection.
+type tokenOwner struct {
+	OrgID    uuid.UUID
+	Username string
+	Roles    []string
+}
+
+// Introspect tells a resource server of an org whether a token is active and
+// who it belongs to (RFC 7662). The caller authenticates with the credentials
+// of a service account and only learns about tokens of its own org; any other
+// token is reported as inactive.
+func Introspect(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var input oauthSchema.TokenHintInput
+	if err := c.BodyParser(&input); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Malformed introspection request")
+	}
+
+	serviceAccount, ok := authenticateServiceAccount(c, input.ClientID, input.ClientSecret)
+	if !ok || !tokens.PrincipalIsActive(serviceAccount.ID, constants.SERVICE_ACCOUNT) {
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	if input.Token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Missing token")
+	}
+
+	inspectors := []func(string, uuid.UUID) (fiber.Map, error){inspectAccessToken, inspectRefreshToken}
+	if input.TokenTypeHint == "refresh_token" {
+		inspectors[0], inspectors[1] = inspectors[1], inspectors[0]
+	}
+
+	for _, inspect := range inspectors {
+		response, err := inspect(input.Token, serviceAccount.OrgID)
+		if err != nil {
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to introspect token")
+		}
+		if response != nil {
+			return c.Status(fiber.StatusOK).JSON(response)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"active": false})
+}
+
+// Revoke ends a token of the caller's org (RFC 7009). Revoking a refresh token
+// ends its whole session. Unknown tokens are not an error, so the response
+// does not tell whether the token existed.
+func Revoke(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var input oauthSchema.TokenHintInput
+	if err := c.BodyParser(&input); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Malformed revocation request")
+	}
+
+	serviceAccount, ok := authenticateServiceAccount(c, input.ClientID, input.ClientSecret)
+	if !ok || !tokens.PrincipalIsActive(serviceAccount.ID, constants.SERVICE_ACCOUNT) {
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	if input.Token == "" {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Missing token")
+	}
+
+	revokers := []func(string, uuid.UUID) (bool, error){revokeAccessToken, revokeRefreshToken}
+	if input.TokenTypeHint == "refresh_token" {
+		revokers[0], revokers[1] = revokers[1], revokers[0]
+	}
+
+	for _, revoke := range revokers {
+		found, err := revoke(input.Token, serviceAccount.OrgID)
+		if err != nil {
+			return oauthError(c, fiber.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+		}
+		if found {
+			break
+		}
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// inspectAccessToken returns the introspection response for an active access
+// token of the org, or nil if the token is not one.
+func inspectAccessToken(token string, orgId uuid.UUID) (fiber.Map, error) {
+	claims, err := tokens.ValidateAccessToken(token)
+	if errors.Is(err, tokens.ErrInvalidAccessToken) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := uuid.Parse(fmt.Sprint(claims["sub"]))
+	subjectType := constants.PrincipalType(fmt.Sprint(claims["principal_type"]))
+
+	owner, err := findTokenOwner(subject, subjectType)
+	if err != nil || owner.OrgID != orgId {
+		return nil, ignoreNotFound(err)
+	}
+
+	response := fiber.Map{
+		"active":         true,
+		"token_type":     "access_token",
+		"sub":            subject,
+		"principal_type": subjectType,
+		"org_id":         owner.OrgID,
+		"username":       owner.Username,
+		"roles":          owner.Roles,
+		"iss":            claims["iss"],
+		"jti":            claims["jti"],
+		"exp":            claims["exp"],
+		"iat":            claims["iat"],
+		"nbf":            claims["nbf"],
+	}
+	for _, claim := range []string{"sid", "aud", "client_id", "scope", "amr", "act"} {
+		if value, ok := claims[claim]; ok {
+			response[claim] = value
+		}
+	}
+
+	return response, nil
+}
+
+// inspectRefreshToken returns the introspection response for an active
+// refresh token of the org, or nil if the token is not one.
+func inspectRefreshToken(token string, orgId uuid.UUID) (fiber.Map, error) {
+	storedToken, err := tokensRepo.FindRefreshTokenByHash(tokens.HashToken(token))
+	if err != nil {
+		return nil, ignoreNotFound(err)
+	}
+
+	if storedToken.UsedAt != nil || storedToken.RevokedAt != nil || storedToken.ExpiresAt.Before(time.Now()) {
+		return nil, nil
+	}
+
+	if !tokens.PrincipalIsActive(storedToken.SubjectID, storedToken.SubjectType) {
+		return nil, nil
+	}
+
+	owner, err := findTokenOwner(storedToken.SubjectID, storedToken.SubjectType)
+	if err != nil || owner.OrgID != orgId {
+		return nil, ignoreNotFound(err)
+	}
+
+	response := fiber.Map{
+		"active":         true,
+		"token_type":     "refresh_token",
+		"sub":            storedToken.SubjectID,
+		"principal_type": storedToken.SubjectType,
+		"org_id":         owner.OrgID,
+		"username":       owner.Username,
+		"roles":          owner.Roles,
+		"iss":            tokens.Issuer(),
+		"sid":            storedToken.FamilyID,
+		"exp":            storedToken.ExpiresAt.Unix(),
+	}
+	if storedToken.CreatedAt != nil {
+		response["iat"] = storedToken.CreatedAt.Unix()
+	}
+	if storedToken.ClientID != "" {
+		response["client_id"] = storedToken.ClientID
+		response["scope"] = storedToken.Scope
+	}
+
+	return response, nil
+}
+
+// revokeAccessToken blocks an access token of the org. It reports whether the
+// token was an access token at all.
+func revokeAccessToken(token string, orgId uuid.UUID) (bool, error) {
+	tokenByte, err := tokens.ParseToken(token)
+	if err != nil {
+		return false, nil
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid || claims["token_use"] != tokens.AccessTokenUse {
+		return false, nil
+	}
+
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return false, nil
+	}
+
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return false, nil
+	}
+
+	owner, err := findTokenOwner(subject, constants.PrincipalType(fmt.Sprint(claims["principal_type"])))
+	if err != nil || owner.OrgID != orgId {
+		return true, ignoreNotFound(err)
+	}
+
+	return true, tokens.RevokeAccessToken(fmt.Sprint(claims["jti"]), subject, expiresAt.Time)
+}
+
+// revokeRefreshToken ends the session of a refresh token of the org. It
+// reports whether the token was a refresh token at all.
+func revokeRefreshToken(token string, orgId uuid.UUID) (bool, error) {
+	storedToken, err := tokensRepo.FindRefreshTokenByHash(tokens.HashToken(token))
+	if err != nil {
+		return false, ignoreNotFound(err)
+	}
+
+	owner, err := findTokenOwner(storedToken.SubjectID, storedToken.SubjectType)
+	if err != nil || owner.OrgID != orgId {
+		return true, ignoreNotFound(err)
+	}
+
+	return true, tokens.RevokeSession(storedToken.FamilyID)
+}
+
+// findTokenOwner loads the org, name and effective roles of a principal. The
+// org root holds no roles, it is allowed everything in its org.
+func findTokenOwner(subject uuid.UUID, subjectType constants.PrincipalType) (tokenOwner, error) {
+	switch subjectType {
+	case constants.USER:
+		user, err := userRepo.FindUserByIdWithPassword(subject)
+		if err != nil {
+			return tokenOwner{}, err
+		}
+		userRoles, err := roleNames(user.OrgID, user.Roles, user.Groups)
+		if err != nil {
+			return tokenOwner{}, err
+		}
+		return tokenOwner{OrgID: user.OrgID, Username: user.Username, Roles: userRoles}, nil
+	case constants.ORG:
+		org, err := orgRepo.FindOrgById(subject)
+		if err != nil {
+			return tokenOwner{}, err
+		}
+		return tokenOwner{OrgID: org.ID, Username: org.Username, Roles: []string{}}, nil
+	case constants.SERVICE_ACCOUNT:
+		serviceAccount, err := serviceAccountRepo.FindServiceAccountById(subject)
+		if err != nil {
+			return tokenOwner{}, err
+		}
+		serviceAccountRoles, err := roleNames(serviceAccount.OrgID, serviceAccount.Roles, serviceAccount.Groups)
+		if err != nil {
+			return tokenOwner{}, err
+		}
+		return tokenOwner{OrgID: serviceAccount.OrgID, Username: serviceAccount.Name, Roles: serviceAccountRoles}, nil
+	}
+
+	return tokenOwner{}, gorm.ErrRecordNotFound
+}
+
+// ignoreNotFound treats a missing record as an unknown token rather than a
+// server error.
+func ignoreNotFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,629 @@
+package oauthHandler
+
+import (
+	oauthRepo "balkantask/database/oauth"
+	rolesRepo "balkantask/database/roles"
+	serviceAccountRepo "balkantask/database/serviceAccount"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	oauthSchema "balkantask/schemas/oauth"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/permissions"
+	"balkantask/utils/roles"
+	"balkantask/utils/tokens"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var supportedScopes = []string{"openid", "profile", "offline_access"}
+
+const authorizationCodeTTL = 5 * time.Minute
+
+func GetClients(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	clients, err := oauthRepo.GetClientsByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	response := []oauthSchema.ClientResponse{}
+	for _, client := range clients {
+		response = append(response, oauthSchema.MapClientRecord(&client))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+func CreateClient(c *fiber.Ctx) error {
+	var input oauthSchema.CreateClient
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	client := model.OAuthClient{
+		OrgID:        orgId,
+		Name:         input.Name,
+		ClientID:     uuid.NewString(),
+		RedirectURIs: input.RedirectURIs,
+	}
+
+	var clientSecret string
+	if !input.Public {
+		secret, secretHash, err := tokens.GenerateOpaqueToken()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Internal Server Error",
+				"status":  "error",
+			})
+		}
+		clientSecret = secret
+		client.SecretHash = secretHash
+	}
+
+	createdClient, err := oauthRepo.CreateClient(client)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	// The secret is only ever shown once
+	response := oauthSchema.MapClientRecord(&createdClient)
+	response.ClientSecret = clientSecret
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Created",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+func DeleteClient(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	client, err := oauthRepo.GetClientById(id)
+	if err != nil || client.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Client Not Found",
+			"status":  "error",
+		})
+	}
+
+	err = oauthRepo.DeleteClient(client)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   true,
+	})
+}
+
+// Authorize handles both the authorization request (GET) and the user's
+// consent decision (POST). The user must already be logged in.
+func Authorize(c *fiber.Ctx) error {
+	var input oauthSchema.AuthorizeInput
+
+	var err error
+	if c.Method() == fiber.MethodGet {
+		err = c.QueryParser(&input)
+	} else {
+		err = c.BodyParser(&input)
+	}
+	if err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Malformed authorization request")
+	}
+
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	if !userOK {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Only users can sign in to applications",
+			"status":  "error",
+		})
+	}
+
+	client, err := oauthRepo.GetClientByClientId(input.ClientID)
+	if err != nil || client.OrgID != user.OrgId {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_client", "Unknown client")
+	}
+
+	// Never redirect to an unregistered URI, report the error to the user instead
+	if !containsString(client.RedirectURIs, input.RedirectURI) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+	}
+
+	if input.ResponseType != "code" {
+		return redirectWithError(c, input, "unsupported_response_type", "Only the code response type is supported")
+	}
+
+	scopes := strings.Fields(input.Scope)
+	if !containsString(scopes, "openid") {
+		return redirectWithError(c, input, "invalid_scope", "The openid scope is required")
+	}
+	for _, scope := range scopes {
+		if !containsString(supportedScopes, scope) {
+			return redirectWithError(c, input, "invalid_scope", "Unsupported scope: "+scope)
+		}
+	}
+
+	if input.CodeChallenge == "" || input.CodeChallengeMethod != "S256" {
+		return redirectWithError(c, input, "invalid_request", "PKCE with the S256 method is required")
+	}
+
+	consent, _ := oauthRepo.FindConsent(user.ID, client.ClientID)
+	consentGiven := scopesCovered(strings.Fields(consent.Scope), scopes)
+
+	if c.Method() == fiber.MethodPost {
+		if !input.Approve {
+			return redirectWithError(c, input, "access_denied", "The user denied the request")
+		}
+
+		err = oauthRepo.UpsertConsent(model.OAuthConsent{
+			UserID:   user.ID,
+			ClientID: client.ClientID,
+			Scope:    strings.Join(mergeScopes(strings.Fields(consent.Scope), scopes), " "),
+		})
+		if err != nil {
+			return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to store consent")
+		}
+		consentGiven = true
+	}
+
+	if !consentGiven {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":  "consent_required",
+			"message": "The user has to approve the requested scopes",
+			"data": fiber.Map{
+				"client_id":   client.ClientID,
+				"client_name": client.Name,
+				"scopes":      scopes,
+			},
+		})
+	}
+
+	code, codeHash, err := tokens.GenerateOpaqueToken()
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue code")
+	}
+
+	_, err = oauthRepo.CreateAuthorizationCode(model.AuthorizationCode{
+		CodeHash:            codeHash,
+		ClientID:            client.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         input.RedirectURI,
+		Scope:               strings.Join(scopes, " "),
+		Nonce:               input.Nonce,
+		CodeChallenge:       input.CodeChallenge,
+		CodeChallengeMethod: input.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue code")
+	}
+
+	return redirectTo(c, input, url.Values{"code": {code}})
+}
+
+func Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	var input oauthSchema.TokenInput
+	if err := c.BodyParser(&input); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Malformed token request")
+	}
+
+	// Service accounts are not OAuth clients, they authenticate on their own
+	if input.GrantType == "client_credentials" {
+		return clientCredentialsGrant(c, input)
+	}
+
+	client, ok := authenticateClient(c, &input)
+	if !ok {
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	switch input.GrantType {
+	case "authorization_code":
+		return exchangeAuthorizationCode(c, client, input)
+	case "refresh_token":
+		return exchangeRefreshToken(c, client, input)
+	}
+
+	return oauthError(c, fiber.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+}
+
+func UserInfo(c *fiber.Ctx) error {
+	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	if !userOK {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	userRoles, err := roleNames(user.OrgId, user.Roles, user.Groups)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"sub":                user.ID,
+		"preferred_username": user.Username,
+		"org_id":             user.OrgId,
+		"roles":              userRoles,
+		"groups":             groupNames(user.Groups),
+	})
+}
+
+func GetOpenIDConfiguration(c *fiber.Ctx) error {
+	issuer := tokens.Issuer()
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"issuer":                                        issuer,
+		"authorization_endpoint":                        issuer + "/oauth/authorize",
+		"token_endpoint":                                issuer + "/oauth/token",
+		"userinfo_endpoint":                             issuer + "/oauth/userinfo",
+		"introspection_endpoint":                        issuer + "/oauth/introspect",
+		"revocation_endpoint":                           issuer + "/oauth/revoke",
+		"jwks_uri":                                      issuer + "/.well-known/jwks.json",
+		"response_types_supported":                      []string{"code"},
+		"grant_types_supported":                         []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":                       []string{"public"},
+		"id_token_signing_alg_values_supported":         []string{tokens.SigningAlgorithm()},
+		"scopes_supported":                              supportedScopes,
+		"token_endpoint_auth_methods_supported":         []string{"client_secret_basic", "client_secret_post", "none"},
+		"introspection_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"revocation_endpoint_auth_methods_supported":    []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":              []string{"S256"},
+		"claims_supported":                              []string{"sub", "iss", "aud", "exp", "iat", "nonce", "preferred_username", "org_id", "roles", "groups"},
+	})
+}
+
+func exchangeAuthorizationCode(c *fiber.Ctx, client model.OAuthClient, input oauthSchema.TokenInput) error {
+	code, err := oauthRepo.FindAuthorizationCodeByHash(tokens.HashToken(input.Code))
+	if err != nil || code.ClientID != client.ClientID || code.RedirectURI != input.RedirectURI {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+	}
+
+	if code.ExpiresAt.Before(time.Now()) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Authorization code expired")
+	}
+
+	if !verifyCodeChallenge(code.CodeChallenge, input.CodeVerifier) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+	}
+
+	marked, err := oauthRepo.MarkAuthorizationCodeUsed(code.ID)
+	if err != nil || !marked {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Authorization code already used")
+	}
+
+	return issueClientTokens(c, client, code.UserID, uuid.Nil, code.Scope, code.Nonce)
+}
+
+func exchangeRefreshToken(c *fiber.Ctx, client model.OAuthClient, input oauthSchema.TokenInput) error {
+	storedToken, err := tokens.ConsumeRefreshToken(input.RefreshToken)
+	if err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", err.Error())
+	}
+
+	// Someone else's refresh token in the hands of this client means it leaked
+	if storedToken.ClientID != client.ClientID {
+		tokens.RevokeSession(storedToken.FamilyID)
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+	}
+
+	return issueClientTokens(c, client, storedToken.SubjectID, storedToken.FamilyID, storedToken.Scope, "")
+}
+
+func issueClientTokens(c *fiber.Ctx, client model.OAuthClient, userId uuid.UUID, familyId uuid.UUID, scope string, nonce string) error {
+	user, err := userRepo.FindUserByIdWithPassword(userId)
+	if err != nil || !tokens.PrincipalIsActive(user.ID, constants.USER) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Account is not active")
+	}
+
+	userRoles, err := roleNames(user.OrgID, user.Roles, user.Groups)
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue tokens")
+	}
+
+	tokenPair, err := tokens.IssueTokens(tokens.TokenRequest{
+		Subject:     user.ID,
+		SubjectType: constants.USER,
+		FamilyID:    familyId,
+		ClientID:    client.ClientID,
+		Scope:       scope,
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+		IP:          c.IP(),
+	})
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue tokens")
+	}
+
+	scopes := strings.Fields(scope)
+
+	now := time.Now().UTC()
+	idTokenClaims := jwt.MapClaims{
+		"iss":    tokens.Issuer(),
+		"sub":    user.ID,
+		"aud":    client.ClientID,
+		"exp":    now.Add(tokens.AccessTokenTTL()).Unix(),
+		"iat":    now.Unix(),
+		"org_id": user.OrgID,
+		"roles":  userRoles,
+		"groups": groupNames(user.Groups),
+	}
+	if nonce != "" {
+		idTokenClaims["nonce"] = nonce
+	}
+	if containsString(scopes, "profile") {
+		idTokenClaims["preferred_username"] = user.Username
+	}
+
+	idToken, err := tokens.SignToken(idTokenClaims)
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue tokens")
+	}
+
+	response := fiber.Map{
+		"access_token": tokenPair.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   tokenPair.ExpiresIn,
+		"id_token":     idToken,
+		"scope":        scope,
+	}
+	if containsString(scopes, "offline_access") {
+		response["refresh_token"] = tokenPair.RefreshToken
+	}
+
+	return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// clientCredentialsGrant issues an access token to a service account. There is
+// no refresh token, the service account simply authenticates again.
+func clientCredentialsGrant(c *fiber.Ctx, input oauthSchema.TokenInput) error {
+	serviceAccount, ok := authenticateServiceAccount(c, input.ClientID, input.ClientSecret)
+	if !ok {
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Client authentication failed")
+	}
+
+	if !tokens.PrincipalIsActive(serviceAccount.ID, constants.SERVICE_ACCOUNT) {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_grant", "Account is not active")
+	}
+
+	accessToken, err := tokens.GenerateAccessToken(tokens.TokenRequest{
+		Subject:     serviceAccount.ID,
+		SubjectType: constants.SERVICE_ACCOUNT,
+		ClientID:    serviceAccount.ClientID,
+	})
+	if err != nil {
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue tokens")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(tokens.AccessTokenTTL().Seconds()),
+	})
+}
+
+// authenticateClient accepts client_secret_basic, client_secret_post and, for
+// public clients, just the client_id.
+func authenticateClient(c *fiber.Ctx, input *oauthSchema.TokenInput) (model.OAuthClient, bool) {
+	clientId, clientSecret, ok := clientCredentials(c, input.ClientID, input.ClientSecret)
+	if !ok {
+		return model.OAuthClient{}, false
+	}
+
+	client, err := oauthRepo.GetClientByClientId(clientId)
+	if err != nil {
+		return model.OAuthClient{}, false
+	}
+
+	if client.SecretHash == "" {
+		return client, true
+	}
+
+	return client, subtle.ConstantTimeCompare([]byte(tokens.HashToken(clientSecret)), []byte(client.SecretHash)) == 1
+}
+
+// authenticateServiceAccount checks the client ID and secret of a service
+// account, as sent to the token, introspection and revocation endpoints.
+func authenticateServiceAccount(c *fiber.Ctx, bodyClientId string, bodyClientSecret string) (model.ServiceAccount, bool) {
+	clientId, clientSecret, ok := clientCredentials(c, bodyClientId, bodyClientSecret)
+	if !ok {
+		return model.ServiceAccount{}, false
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountByClientId(clientId)
+	if err != nil || subtle.ConstantTimeCompare([]byte(tokens.HashToken(clientSecret)), []byte(serviceAccount.SecretHash)) != 1 {
+		return model.ServiceAccount{}, false
+	}
+
+	return serviceAccount, true
+}
+
+// clientCredentials reads the client ID and secret from the Basic
+// authorization header, falling back to the ones from the request body.
+func clientCredentials(c *fiber.Ctx, clientId string, clientSecret string) (string, string, bool) {
+
+	if authorization := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(authorization, "Basic ") {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+		if err != nil {
+			return "", "", false
+		}
+		id, secret, found := strings.Cut(string(decoded), ":")
+		if !found {
+			return "", "", false
+		}
+		clientId, _ = url.QueryUnescape(id)
+		clientSecret, _ = url.QueryUnescape(secret)
+	}
+
+	return clientId, clientSecret, clientId != ""
+}
+
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func redirectWithError(c *fiber.Ctx, input oauthSchema.AuthorizeInput, code string, description string) error {
+	return redirectTo(c, input, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// redirectTo sends the browser back to the client. A consent POST comes from
+// our own frontend via fetch, so it gets the URL in JSON instead of a 302.
+func redirectTo(c *fiber.Ctx, input oauthSchema.AuthorizeInput, params url.Values) error {
+	target, err := url.Parse(input.RedirectURI)
+	if err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid redirect_uri")
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if input.State != "" {
+		query.Set("state", input.State)
+	}
+	target.RawQuery = query.Encode()
+
+	if c.Method() == fiber.MethodPost {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":      "success",
+			"redirect_to": target.String(),
+		})
+	}
+
+	return c.Redirect(target.String(), fiber.StatusFound)
+}
+
+func oauthError(c *fiber.Ctx, status int, code string, description string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// roleNames lists the effective roles of a principal, including the roles
+// held through groups and those included by the held roles.
+func roleNames(orgId uuid.UUID, userRoles []model.Role, userGroups []model.Group) ([]string, error) {
+	available, err := rolesRepo.GetRolesWithIncludes(orgId)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, role := range roles.NewGraph(available).Expand(roles.EffectiveRoles(userRoles, userGroups)) {
+		names = append(names, role.Name)
+	}
+	return names, nil
+}
+
+func groupNames(userGroups []model.Group) []string {
+	names := []string{}
+	for _, group := range userGroups {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+func scopesCovered(granted []string, requested []string) bool {
+	for _, scope := range requested {
+		if !containsString(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeScopes(granted []string, requested []string) []string {
+	merged := append([]string{}, granted...)
+	for _, scope := range requested {
+		if !containsString(merged, scope) {
+			merged = append(merged, scope)
+		}
+	}
+	return merged
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package policyHandler
+
+import (
+	groupRepo "balkantask/database/group"
+	policyRepo "balkantask/database/policy"
+	rolesRepo "balkantask/database/roles"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	policySchema "balkantask/schemas/policy"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
+	"balkantask/utils/roles"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func GetPolicies(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	policies, err := policyRepo.FindPoliciesByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	response := []policySchema.PolicyResponse{}
+	for _, policy := range policies {
+		response = append(response, policySchema.MapPolicyRecord(&policy))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": response})
+}
+
+// GetPolicyById returns a policy with the users, groups and roles it is
+// attached to.
+func GetPolicyById(c *fiber.Ctx) error {
+	policy, status, message := managedPolicy(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	attachments, err := policyRepo.FindAttachments(policy.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	response := policySchema.MapPolicyRecord(&policy)
+	response.Attachments = policySchema.MapAttachmentRecords(attachments)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "OK", "data": response})
+}
+
+func CreatePolicy(c *fiber.Ctx) error {
+	var input policySchema.CreatePolicy
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	newPolicy := model.Policy{
+		OrgID:       orgId,
+		Name:        input.Name,
+		Description: input.Description,
+		Document:    input.Document,
+	}
+
+	if status, message := checkPolicy(newPolicy); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	createdPolicy, err := policyRepo.CreatePolicy(newPolicy)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"status": "success", "message": "Created", "data": policySchema.MapPolicyRecord(&createdPolicy)})
+}
+
+func UpdatePolicy(c *fiber.Ctx) error {
+	var input policySchema.UpdatePolicy
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	policy, status, message := managedPolicy(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if input.Name != "" {
+		policy.Name = input.Name
+	}
+	if input.Description != nil {
+		policy.Description = *input.Description
+	}
+	if input.Document != nil {
+		policy.Document = *input.Document
+	}
+
+	if status, message := checkPolicy(policy); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	updatedPolicy, err := policyRepo.UpdatePolicy(policy)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Updated", "data": policySchema.MapPolicyRecord(&updatedPolicy)})
+}
+
+func DeletePolicy(c *fiber.Ctx) error {
+	policy, status, message := managedPolicy(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if err := policyRepo.DeletePolicy(policy); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Deleted"})
+}
+
+// AttachPolicy gives a policy to a user of the org, a group or a role.
+func AttachPolicy(c *fiber.Ctx) error {
+	var input policySchema.AttachPolicy
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	policy, status, message := managedPolicy(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if status, message := checkPrincipal(policy.OrgID, input.PrincipalType, input.PrincipalId); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if _, err := policyRepo.FindAttachment(policy.ID, input.PrincipalType, input.PrincipalId); err == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Policy is already attached"})
+	}
+
+	_, err := policyRepo.CreateAttachment(model.PolicyAttachment{
+		PolicyID:      policy.ID,
+		PrincipalType: input.PrincipalType,
+		PrincipalID:   input.PrincipalId,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Policy attached"})
+}
+
+func DetachPolicy(c *fiber.Ctx) error {
+	var input policySchema.AttachPolicy
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Bad Request"})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "Validation Error", "errors": errors})
+	}
+
+	policy, status, message := managedPolicy(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	attachment, err := policyRepo.FindAttachment(policy.ID, input.PrincipalType, input.PrincipalId)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "error", "message": "Policy is not attached"})
+	}
+
+	if err := policyRepo.DeleteAttachment(attachment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "success", "message": "Policy detached"})
+}
+
+// checkPolicy validates the document and makes sure the name is free in the
+// org.
+func checkPolicy(newPolicy model.Policy) (int, string) {
+	if err := policy.Validate(newPolicy.Document, permissions.Names()); err != nil {
+		return fiber.StatusBadRequest, err.Error()
+	}
+
+	existing, err := policyRepo.FindPolicyByName(newPolicy.OrgID, newPolicy.Name)
+	if err == nil && existing.ID != newPolicy.ID {
+		return fiber.StatusBadRequest, "Policy already exists"
+	}
+
+	return fiber.StatusOK, ""
+}
+
+// checkPrincipal makes sure a policy of the org can be attached to the
+// principal: a user of the org, any group or a role available to the org.
+func checkPrincipal(orgId uuid.UUID, principalType string, principalId uuid.UUID) (int, string) {
+	switch principalType {
+	case policy.PrincipalUser:
+		user, err := userRepo.FindUserById(principalId)
+		if err != nil || user.OrgId != orgId {
+			return fiber.StatusNotFound, "User Not Found"
+		}
+	case policy.PrincipalGroup:
+		if _, err := groupRepo.GetGroupById(principalId); err != nil {
+			return fiber.StatusNotFound, "Group Not Found"
+		}
+	case policy.PrincipalRole:
+		role, err := rolesRepo.GetRoleById(principalId)
+		if err != nil || !roles.AvailableTo(role, orgId) {
+			return fiber.StatusNotFound, "Role Not Found"
+		}
+	default:
+		return fiber.StatusBadRequest, "Invalid principal type"
+	}
+
+	return fiber.StatusOK, ""
+}
+
+func managedPolicy(c *fiber.Ctx) (model.Policy, int, string) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return model.Policy{}, fiber.StatusBadRequest, "Invalid ID"
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return model.Policy{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	policy, err := policyRepo.FindPolicyById(id)
+	if err != nil || policy.OrgID != orgId {
+		return model.Policy{}, fiber.StatusNotFound, "Policy Not Found"
+	}
+
+	return policy, fiber.StatusOK, ""
+}
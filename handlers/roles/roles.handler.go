@@ -3,9 +3,9 @@ package rolesHandler
 import (
 	rolesRepo "balkantask/database/roles"
 	"balkantask/model"
-	orgSchema "balkantask/schemas/org"
 	roleSchema "balkantask/schemas/role"
 	userSchema "balkantask/schemas/user"
+	"balkantask/utils/permissions"
 	"balkantask/utils/roles"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,13 +13,24 @@ import (
 )
 
 func GetAllRoles(c *fiber.Ctx) error {
-	roles, err := rolesRepo.GetAllRoles()
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	orgRoles, err := rolesRepo.GetRolesWithIncludes(orgId)
 
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": roles})
+	graph := roles.NewGraph(orgRoles)
+	response := []roleSchema.RoleResponse{}
+	for _, role := range orgRoles {
+		response = append(response, describeRole(role, graph))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": response})
 }
 
 func GetRoleById(c *fiber.Ctx) error {
@@ -33,30 +44,47 @@ func GetRoleById(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
 	role, err := rolesRepo.GetRoleById(id)
+	if err != nil || !roles.AvailableTo(role, orgId) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"status": "false", "message": "Role Not Found"})
+	}
 
+	graph, err := roleGraph(orgId)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": role})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": describeRole(role, graph)})
 }
 
-func CreateRole(c *fiber.Ctx) error {
-	var role roleSchema.AddOrDeleteRole
+// GetPermissions lists the permission catalogue and which roles grant each
+// permission.
+func GetPermissions(c *fiber.Ctx) error {
+	catalogue := []roleSchema.PermissionResponse{}
+	for _, definition := range permissions.Catalogue {
+		granting := []string{}
+		for _, role := range permissions.RolesGranting(definition.Name) {
+			granting = append(granting, string(role))
+		}
 
-	if err := c.BodyParser(&role); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+		catalogue = append(catalogue, roleSchema.PermissionResponse{Name: string(definition.Name), Description: definition.Description, Roles: granting})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": catalogue})
+}
 
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.RoleWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.RoleFullAccess, roles.OrgReadAccess, roles.RoleReadAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
+// CreateRole creates a CUSTOM role of the caller's org. Only permissions
+// the caller holds can be put in it, directly or through included roles.
+func CreateRole(c *fiber.Ctx) error {
+	var role roleSchema.CreateRole
+
+	if err := c.BodyParser(&role); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
 	}
 
 	errors := model.ValidateStruct(role)
@@ -68,17 +96,40 @@ func CreateRole(c *fiber.Ctx) error {
 		})
 	}
 
-	exisitingRole, err := rolesRepo.GetRoleByName(role.RoleName)
-	if err == nil && exisitingRole.Name == role.RoleName {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Role already exists",
-			"status":  "error",
-		})
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Forbidden"})
+	}
+
+	if status, message := checkRoleName(role.RoleName, orgId, uuid.Nil); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	granted, status, message := checkPermissions(c, role.Permissions)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	graph, err := roleGraph(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+
+	included, status, message := checkIncludes(c, role.Includes, uuid.Nil, graph)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	if len(granted) == 0 && len(included) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "A role needs permissions or included roles"})
 	}
 
 	newRole := model.Role{
-		Name: role.RoleName,
-		Type: role.Type,
+		Name:        role.RoleName,
+		Type:        roles.CustomType,
+		OrgID:       &orgId,
+		Permissions: granted,
+		Includes:    included,
 	}
 
 	createdRole, err := rolesRepo.CreateRole(newRole)
@@ -91,39 +142,95 @@ func CreateRole(c *fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status": "success",
-		"data":   createdRole,
+		"data":   describeRole(createdRole, graph),
 	})
 }
 
-func DeleteRole(c *fiber.Ctx) error {
-	id_ := c.Params("id")
-	id, err := uuid.Parse(id_)
-	if err != nil {
-		return c.Status(400).JSON(fiber.Map{
-			"message": "Invalid ID",
+// UpdateRole renames a CUSTOM role or replaces its permissions or included
+// roles. SYSTEM roles cannot be changed.
+func UpdateRole(c *fiber.Ctx) error {
+	var input roleSchema.UpdateRole
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
 			"status":  "error",
+			"errors":  errors,
 		})
 	}
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
 
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.RoleWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.RoleFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+	role, status, message := customRole(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+	}
+
+	graph, err := roleGraph(*role.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
+	role.Includes = graph.Includes(role)
+
+	if input.RoleName != "" && input.RoleName != role.Name {
+		if status, message := checkRoleName(input.RoleName, *role.OrgID, role.ID); status != fiber.StatusOK {
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+		role.Name = input.RoleName
+	}
+
+	if input.Permissions != nil {
+		granted, status, message := checkPermissions(c, input.Permissions)
+		if status != fiber.StatusOK {
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+		role.Permissions = granted
+	}
+
+	if input.Includes != nil {
+		included, status, message := checkIncludes(c, input.Includes, role.ID, graph)
+		if status != fiber.StatusOK {
+			return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
+		}
+		role.Includes = included
+	}
+
+	if len(role.Permissions) == 0 && len(role.Includes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": "A role needs permissions or included roles"})
+	}
+
+	err = rolesRepo.UpdateRole(&role)
+	if err == nil && input.Includes != nil {
+		err = rolesRepo.ReplaceIncludes(&role, role.Includes)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Forbidden",
+			"message": "Internal Server Error",
 		})
 	}
 
-	roleExists, err := rolesRepo.GetRoleById(id)
+	graph, err = roleGraph(*role.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"status": "error", "message": "Internal Server Error"})
+	}
 
-	if err != nil || roleExists.ID == uuid.Nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "Role Not Found",
-			"status":  "false",
-		})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   describeRole(role, graph),
+	})
+}
+
+func DeleteRole(c *fiber.Ctx) error {
+	roleExists, status, message := customRole(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{"status": "error", "message": message})
 	}
 
-	err = rolesRepo.DeleteRole(&roleExists)
+	err := rolesRepo.DeleteRole(&roleExists)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
@@ -159,7 +266,7 @@ func TestUserRole(c *fiber.Ctx) error {
 			})
 		}
 	} else if role.RoleName != "" {
-		roleExists, err = rolesRepo.GetRoleByName(role.RoleName)
+		roleExists, err = rolesRepo.GetRoleByName(role.RoleName, user.OrgId)
 		if err != nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"message": "Role Not Found",
@@ -173,7 +280,23 @@ func TestUserRole(c *fiber.Ctx) error {
 		})
 	}
 
-	if !userOK || !roles.UserHasRole(user.Roles, roleExists) {
+	if !userOK {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Forbidden",
+		})
+	}
+
+	// A role is also held through the roles including it
+	graph, err := roleGraph(user.OrgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Internal Server Error",
+		})
+	}
+
+	if !roles.UserHasRole(graph.Expand(roles.EffectiveRoles(user.Roles, user.Groups)), roleExists) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Forbidden",
@@ -186,70 +309,12 @@ func TestUserRole(c *fiber.Ctx) error {
 }
 
 func SeedRoles(c *fiber.Ctx) error {
-	roles := []model.Role{
-		{
-			Name: "ORG_READ_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "ORG_WRITE_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "ORG_FULL_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "GROUP_READ_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "GROUP_WRITE_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "GROUP_FULL_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "TASKS_READ_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "TASKS_WRITE_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "TASKS_FULL_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "ROLE_READ_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "ROLE_WRITE_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "ROLE_FULL_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "USER_READ_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "USER_WRITE_ACCESS",
-			Type: "SYSTEM",
-		},
-		{
-			Name: "USER_FULL_ACCESS",
-			Type: "SYSTEM",
-		},
-	}
-
-	roles, err := rolesRepo.CreateRoles(roles)
+	systemRoles := []model.Role{}
+	for _, role := range roles.SystemRoles {
+		systemRoles = append(systemRoles, model.Role{Name: string(role), Type: roles.SystemType})
+	}
+
+	_, err := rolesRepo.CreateRoles(systemRoles)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
@@ -262,3 +327,129 @@ func SeedRoles(c *fiber.Ctx) error {
 		"data":   true,
 	})
 }
+
+// customRole loads the CUSTOM role named by the id param. Roles of other orgs
+// are not found and SYSTEM roles are refused.
+func customRole(c *fiber.Ctx) (model.Role, int, string) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return model.Role{}, fiber.StatusBadRequest, "Invalid ID"
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return model.Role{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	role, err := rolesRepo.GetRoleById(id)
+	if err != nil || !roles.AvailableTo(role, orgId) {
+		return model.Role{}, fiber.StatusNotFound, "Role Not Found"
+	}
+
+	if role.OrgID == nil {
+		return model.Role{}, fiber.StatusForbidden, "System roles cannot be changed"
+	}
+
+	return role, fiber.StatusOK, ""
+}
+
+// checkRoleName makes sure a name is free in the org. The names of the SYSTEM
+// roles are reserved.
+func checkRoleName(name string, orgId uuid.UUID, roleId uuid.UUID) (int, string) {
+	if roles.IsSystemRole(name) {
+		return fiber.StatusBadRequest, "Role name is reserved"
+	}
+
+	existingRole, err := rolesRepo.GetRoleByName(name, orgId)
+	if err == nil && existingRole.ID != roleId {
+		return fiber.StatusBadRequest, "Role already exists"
+	}
+
+	return fiber.StatusOK, ""
+}
+
+// checkPermissions validates the permissions of a CUSTOM role against the
+// catalogue. A caller cannot grant permissions it does not hold itself.
+func checkPermissions(c *fiber.Ctx, requested []string) ([]string, int, string) {
+	granted := []string{}
+	seen := map[string]bool{}
+	for _, name := range requested {
+		permission := permissions.Permission(name)
+		if !permissions.IsValid(permission) {
+			return nil, fiber.StatusBadRequest, "Unknown permission: " + name
+		}
+		if !permissions.Granted(c, permission) {
+			return nil, fiber.StatusForbidden, "Cannot grant a permission you do not hold: " + name
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			granted = append(granted, name)
+		}
+	}
+
+	return granted, fiber.StatusOK, ""
+}
+
+// checkIncludes resolves the roles a CUSTOM role includes. Including a role
+// that includes the role itself, directly or not, would form a cycle. A
+// caller cannot include roles granting permissions it does not hold itself.
+func checkIncludes(c *fiber.Ctx, names []string, roleId uuid.UUID, graph roles.Graph) ([]model.Role, int, string) {
+	included := []model.Role{}
+	for _, name := range names {
+		role, ok := graph.ByName(name)
+		if !ok {
+			return nil, fiber.StatusBadRequest, "Unknown role: " + name
+		}
+		if !roles.UserHasRole(included, role) {
+			included = append(included, role)
+		}
+	}
+
+	if roleId != uuid.Nil && graph.Reaches(included, roleId) {
+		return nil, fiber.StatusBadRequest, "Included roles would form a cycle"
+	}
+
+	for _, permission := range permissions.ForRoles(graph.Expand(included)) {
+		if !permissions.Granted(c, permission) {
+			return nil, fiber.StatusForbidden, "Cannot include a role granting a permission you do not hold: " + string(permission)
+		}
+	}
+
+	return included, fiber.StatusOK, ""
+}
+
+// describeRole shows a role with the roles it includes, directly or not, and
+// everything it grants.
+func describeRole(role model.Role, graph roles.Graph) roleSchema.RoleResponse {
+	expanded := graph.Expand([]model.Role{role})
+
+	inherited := []string{}
+	for _, included := range expanded[1:] {
+		inherited = append(inherited, included.Name)
+	}
+
+	effective := []string{}
+	for _, permission := range permissions.ForRoles(expanded) {
+		effective = append(effective, string(permission))
+	}
+
+	own := []string{}
+	for _, permission := range permissions.ForRole(role) {
+		own = append(own, string(permission))
+	}
+	role.Permissions = own
+	role.Includes = graph.Includes(role)
+
+	return roleSchema.RoleResponse{Role: role, InheritedRoles: inherited, EffectivePermissions: effective}
+}
+
+// roleGraph loads the roles available to the org to expand what they
+// include.
+func roleGraph(orgId uuid.UUID) (roles.Graph, error) {
+	available, err := rolesRepo.GetRolesWithIncludes(orgId)
+	if err != nil {
+		return roles.Graph{}, err
+	}
+	return roles.NewGraph(available), nil
+}
@@ -0,0 +1,583 @@
+package serviceAccountHandler
+
+import (
+	groupRepo "balkantask/database/group"
+	rolesRepo "balkantask/database/roles"
+	serviceAccountRepo "balkantask/database/serviceAccount"
+	"balkantask/model"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	constants "balkantask/utils"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
+	"balkantask/utils/roles"
+	"balkantask/utils/tokens"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+func GetServiceAccounts(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	serviceAccounts, err := serviceAccountRepo.FindServiceAccountsByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    serviceAccounts,
+	})
+}
+
+func GetServiceAccountById(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&serviceAccount),
+	})
+}
+
+func CreateServiceAccount(c *fiber.Ctx) error {
+	var input serviceAccountSchema.CreateServiceAccount
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	secret, secretHash, err := tokens.GenerateOpaqueToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	createdServiceAccount, err := serviceAccountRepo.CreateServiceAccount(model.ServiceAccount{
+		Name:          input.Name,
+		OrgID:         orgId,
+		ClientID:      uuid.NewString(),
+		SecretHash:    secretHash,
+		AccountStatus: constants.ACTIVATED,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	// The secret is only ever shown once
+	response := serviceAccountSchema.MapServiceAccountRecord(&createdServiceAccount)
+	response.ClientSecret = secret
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Created",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+// RotateServiceAccountSecret replaces the client secret. Tokens issued with
+// the old secret are revoked.
+func RotateServiceAccountSecret(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	secret, secretHash, err := tokens.GenerateOpaqueToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount.SecretHash = secretHash
+	updatedServiceAccount, err := serviceAccountRepo.UpdateServiceAccount(serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	tokens.RevokeAllTokens(updatedServiceAccount.ID)
+
+	response := serviceAccountSchema.MapServiceAccountRecord(&updatedServiceAccount)
+	response.ClientSecret = secret
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Secret rotated",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+func DeleteServiceAccount(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	err = serviceAccountRepo.DeleteServiceAccount(serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	tokens.RevokeAllTokens(serviceAccount.ID)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Service account deleted successfully",
+		"status":  "success",
+		"data":    true,
+	})
+}
+
+func DeactivateServiceAccount(c *fiber.Ctx) error {
+	return setServiceAccountStatus(c, constants.DEACTIVATED)
+}
+
+func ReactivateServiceAccount(c *fiber.Ctx) error {
+	return setServiceAccountStatus(c, constants.ACTIVATED)
+}
+
+func AddRoleToServiceAccount(c *fiber.Ctx) error {
+	var input serviceAccountSchema.AddOrDeleteRole
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	if err := policy.ValidateConditions(input.Conditions); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(input.ServiceAccountId)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	role, err := findRole(input.RoleId, input.RoleName, serviceAccount.OrgID)
+	if err != nil || !roles.AvailableTo(role, serviceAccount.OrgID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Role doesn't exist",
+			"status":  "error",
+		})
+	}
+
+	if roles.UserHasRole(serviceAccount.Roles, role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Service account already has the role",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err = serviceAccountRepo.AddRoleToServiceAccount(role, serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	err = rolesRepo.SetRoleConditions(serviceAccount.ID, role.ID, input.Conditions)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role added to service account",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&serviceAccount),
+	})
+}
+
+func DeleteRoleFromServiceAccount(c *fiber.Ctx) error {
+	var input serviceAccountSchema.AddOrDeleteRole
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(input.ServiceAccountId)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	role, err := findRole(input.RoleId, input.RoleName, serviceAccount.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Role doesn't exist",
+			"status":  "error",
+		})
+	}
+
+	if !roles.UserHasRole(serviceAccount.Roles, role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Service account does not have the role",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err = serviceAccountRepo.DeleteRoleFromServiceAccount(role, serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	err = rolesRepo.DeleteRoleConditions(serviceAccount.ID, role.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Role removed from service account",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&serviceAccount),
+	})
+}
+
+func AddGroupToServiceAccount(c *fiber.Ctx) error {
+	var input serviceAccountSchema.AddOrDeleteGroup
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(input.ServiceAccountId)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	group, err := findGroup(input.GroupId, input.GroupName)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Group doesn't exist",
+			"status":  "error",
+		})
+	}
+
+	if roles.UserHasGroup(serviceAccount.Groups, []model.Group{group}) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Service account already has the group",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err = serviceAccountRepo.AddGroupToServiceAccount(group, serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Group added to service account",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&serviceAccount),
+	})
+}
+
+func DeleteGroupFromServiceAccount(c *fiber.Ctx) error {
+	var input serviceAccountSchema.AddOrDeleteGroup
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(input.ServiceAccountId)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	group, err := findGroup(input.GroupId, input.GroupName)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Group doesn't exist",
+			"status":  "error",
+		})
+	}
+
+	if !roles.UserHasGroup(serviceAccount.Groups, []model.Group{group}) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Service account does not have the group",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err = serviceAccountRepo.DeleteGroupFromServiceAccount(group, serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Group removed from service account",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&serviceAccount),
+	})
+}
+
+func setServiceAccountStatus(c *fiber.Ctx, status constants.AccountStatus) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id)
+	if err != nil || serviceAccount.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "Service Account Not Found",
+			"status":  "error",
+		})
+	}
+
+	if serviceAccount.AccountStatus == status {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Service account is already " + string(status),
+			"status":  "error",
+		})
+	}
+
+	serviceAccount.AccountStatus = status
+	updatedServiceAccount, err := serviceAccountRepo.UpdateServiceAccount(serviceAccount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	if status == constants.DEACTIVATED {
+		tokens.RevokeAllTokens(updatedServiceAccount.ID)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    serviceAccountSchema.MapServiceAccountRecord(&updatedServiceAccount),
+	})
+}
+
+func findRole(roleId uuid.UUID, roleName string, orgId uuid.UUID) (model.Role, error) {
+	if roleId != uuid.Nil {
+		return rolesRepo.GetRoleById(roleId)
+	}
+	return rolesRepo.GetRoleByName(roleName, orgId)
+}
+
+func findGroup(groupId uuid.UUID, groupName string) (model.Group, error) {
+	if groupId != uuid.Nil {
+		return groupRepo.GetGroupById(groupId)
+	}
+	return groupRepo.GetGroupByName(groupName)
+}
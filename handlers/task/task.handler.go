@@ -4,9 +4,10 @@ import (
 	rolesRepo "balkantask/database/roles"
 	taskRepo "balkantask/database/tasks"
 	"balkantask/model"
-	orgSchema "balkantask/schemas/org"
 	taskSchema "balkantask/schemas/task"
 	userSchema "balkantask/schemas/user"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
 	"balkantask/utils/roles"
 	"encoding/csv"
 	"fmt"
@@ -21,22 +22,20 @@ import (
 )
 
 func GetAllTasks(c *fiber.Ctx) error {
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.TasksWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess, roles.OrgReadAccess, roles.TasksReadAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	tasks, err := taskRepo.GetAllTasks()
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": tasks})
+	// Leave out the tasks a policy denies reading
+	readable := []model.Task{}
+	for _, task := range tasks {
+		if permissions.Authorize(c, permissions.TaskRead, policy.Resource("task", task.ID)) {
+			readable = append(readable, task)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "true", "data": readable})
 }
 
 func GetTaskById(c *fiber.Ctx) error {
@@ -49,16 +48,6 @@ func GetTaskById(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.TasksWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess, roles.OrgReadAccess, roles.TasksReadAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	task, err := taskRepo.GetTaskById(id)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "false", "message": err.Error()})
@@ -74,16 +63,6 @@ func CreateTask(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error()})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.RoleWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	errors := model.ValidateStruct(task)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -93,6 +72,8 @@ func CreateTask(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
+
 	// Check if the roles (id) exist in the database
 	rolesExist, err := rolesRepo.GetRolesByIds(task.RoleIds)
 	if err != nil {
@@ -103,7 +84,7 @@ func CreateTask(c *fiber.Ctx) error {
 	}
 
 	// Check if the roles (name) exist in the database
-	rolesExist2, err := rolesRepo.GetRolesByNames(task.RoleNames)
+	rolesExist2, err := rolesRepo.GetRolesByNames(task.RoleNames, orgId)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Invalid Role IDs",
@@ -111,6 +92,16 @@ func CreateTask(c *fiber.Ctx) error {
 		})
 	}
 
+	// CUSTOM roles can only be given within their org
+	for _, role := range rolesExist {
+		if !roles.AvailableTo(role, orgId) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Role doesn't exist",
+				"status":  "error",
+			})
+		}
+	}
+
 	rolesExist = append(rolesExist, rolesExist2...)
 	rolesExist = roles.RemoveDuplicates(rolesExist)
 
@@ -167,16 +158,6 @@ func DeleteTaskById(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.TasksWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Forbidden",
-		})
-	}
-
 	taskExists, err := taskRepo.GetTaskById(id)
 
 	if err != nil || taskExists.ID == uuid.Nil {
@@ -210,16 +191,6 @@ func AddRoleToTask(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.TasksFullAccess, roles.OrgWriteAccess, roles.TasksWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -249,6 +220,15 @@ func AddRoleToTask(c *fiber.Ctx) error {
 		})
 	}
 
+	if !permissions.Authorize(c, permissions.TaskWrite, policy.Resource("task", task.ID)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	orgId, _ := permissions.CallerOrgId(c)
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -260,7 +240,7 @@ func AddRoleToTask(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -274,6 +254,14 @@ func AddRoleToTask(c *fiber.Ctx) error {
 		})
 	}
 
+	// CUSTOM roles can only be given within their org
+	if !roles.AvailableTo(role, orgId) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Role doesn't exist",
+			"status":  "error",
+		})
+	}
+
 	if roles.TaskHasRole(task.Roles, []model.Role{role}) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Task already has the role",
@@ -306,16 +294,6 @@ func DeleteRoleFromTask(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.TasksFullAccess, roles.OrgWriteAccess, roles.TasksWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -345,6 +323,15 @@ func DeleteRoleFromTask(c *fiber.Ctx) error {
 		})
 	}
 
+	if !permissions.Authorize(c, permissions.TaskWrite, policy.Resource("task", task.ID)) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	orgId, _ := permissions.CallerOrgId(c)
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -356,7 +343,7 @@ func DeleteRoleFromTask(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -412,16 +399,6 @@ func SeedTasksFromExcel(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.TasksWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	// Create a temporary file to save the uploaded content
 	tempFile, err := os.CreateTemp("", "upload-*.xlsx")
 	// CreateTemp function, it generates a unique temporary file name by replacing the asterisk (*) with a random string.
@@ -463,6 +440,7 @@ func SeedTasksFromExcel(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
 	var createdTasks []model.Task
 
 	for rowIndex, row := range rows {
@@ -487,7 +465,7 @@ func SeedTasksFromExcel(c *fiber.Ctx) error {
 		}
 
 		// Retrieve the roles from the database based on role names
-		rolesExist, err := rolesRepo.GetRolesByNames(roleNames)
+		rolesExist, err := rolesRepo.GetRolesByNames(roleNames, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": fmt.Sprintf("Invalid role names in row %d", rowIndex+1),
@@ -537,16 +515,6 @@ func SeedTasksFromCSV(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.TasksWriteAccess, roles.OrgFullAccess, roles.OrgWriteAccess, roles.TasksFullAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	// Create a temporary file to save the uploaded content
 	tempFile, err := os.CreateTemp("", "upload-*.csv")
 	if err != nil {
@@ -588,6 +556,7 @@ func SeedTasksFromCSV(c *fiber.Ctx) error {
 		})
 	}
 
+	orgId, _ := permissions.CallerOrgId(c)
 	var createdTasks []model.Task
 
 	for rowIndex := 1; ; rowIndex++ {
@@ -618,7 +587,7 @@ func SeedTasksFromCSV(c *fiber.Ctx) error {
 		}
 
 		// Retrieve the roles from the database based on role names
-		rolesExist, err := rolesRepo.GetRolesByNames(roleNames)
+		rolesExist, err := rolesRepo.GetRolesByNames(roleNames, orgId)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": fmt.Sprintf("Invalid role names in row %d", rowIndex),
@@ -684,7 +653,24 @@ func TestUserTask(c *fiber.Ctx) error {
 		})
 	}
 
-	if !userOK || !roles.UserHasTaskAuthorization(user.Roles, user.Groups, taskExists) {
+	if !userOK {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Forbidden",
+		})
+	}
+
+	// A task role is also held through the roles including it
+	orgRoles, err := rolesRepo.GetRolesWithIncludes(user.OrgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Internal Server Error",
+		})
+	}
+	heldRoles := roles.NewGraph(orgRoles).Expand(roles.EffectiveRoles(user.Roles, user.Groups))
+
+	if !roles.UserHasTaskAuthorization(heldRoles, nil, taskExists) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Forbidden",
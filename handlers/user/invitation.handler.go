@@ -0,0 +1,232 @@
+package userHandler
+
+import (
+	invitationRepo "balkantask/database/invitation"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	invitationSchema "balkantask/schemas/invitation"
+	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/invitation"
+	"balkantask/utils/permissions"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetInvitations lists the invitations of the org that were not accepted yet.
+func GetInvitations(c *fiber.Ctx) error {
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	invitations, err := invitationRepo.FindInvitationsByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	users, err := userRepo.FindUsersByOrgId(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	invitedUsers := map[uuid.UUID]userSchema.UserResponse{}
+	for _, user := range users {
+		if user.AccountStatus == constants.INVITED {
+			invitedUsers[user.ID] = user
+		}
+	}
+
+	response := []invitationSchema.InvitationResponse{}
+	for _, invitation := range invitations {
+		user, ok := invitedUsers[invitation.UserID]
+		if !ok {
+			continue
+		}
+		response = append(response, invitationSchema.MapInvitationRecord(&invitation, user.Username, user.Email))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "OK",
+		"status":  "success",
+		"data":    response,
+	})
+}
+
+// ResendInvitation mails a new link with a fresh expiry. Links sent before
+// stop working.
+func ResendInvitation(c *fiber.Ctx) error {
+	pending, user, status, message := findManagedInvitation(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{
+			"message": message,
+			"status":  "error",
+		})
+	}
+
+	inviterId, inviterType := callerPrincipal(c)
+	resent, err := invitation.Send(user, inviterId, inviterType)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+	resent.ID = pending.ID
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invitation sent",
+		"status":  "success",
+		"data":    invitationSchema.MapInvitationRecord(&resent, user.Username, user.Email),
+	})
+}
+
+// CancelInvitation withdraws an invitation. The invited user never had a
+// password, so it is deleted along with it.
+func CancelInvitation(c *fiber.Ctx) error {
+	pending, user, status, message := findManagedInvitation(c)
+	if status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{
+			"message": message,
+			"status":  "error",
+		})
+	}
+
+	if err := invitationRepo.DeleteInvitation(pending); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	if _, err := userRepo.DeleteUser(user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Invitation cancelled",
+		"status":  "success",
+	})
+}
+
+// inviteUser creates a user in the INVITED state and mails it a link to
+// choose its password, instead of returning a generated passcode.
+func inviteUser(c *fiber.Ctx, input userSchema.CreateUser, orgId uuid.UUID) error {
+	if input.Password != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "An invited user chooses their own password",
+			"status":  "error",
+		})
+	}
+
+	if input.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Email is required to invite a user",
+			"status":  "error",
+		})
+	}
+
+	newUser := model.User{
+		Username:      input.Username,
+		Email:         strings.ToLower(input.Email),
+		AccountStatus: constants.INVITED,
+		OrgID:         orgId,
+	}
+
+	errors := model.ValidateStruct(newUser)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	createdUser, err := userRepo.CreateUser(newUser)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	newUser.ID = createdUser.ID
+	inviterId, inviterType := callerPrincipal(c)
+	if _, err := invitation.Send(newUser, inviterId, inviterType); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Invited",
+		"status":  "success",
+		"data": userSchema.CreateUserResponse{
+			ID:            createdUser.ID,
+			Username:      createdUser.Username,
+			Email:         createdUser.Email,
+			CreatedAt:     createdUser.CreatedAt,
+			UpdatedAt:     createdUser.UpdatedAt,
+			Roles:         createdUser.Roles,
+			OrgId:         createdUser.OrgId,
+			AccountStatus: createdUser.AccountStatus,
+		},
+	})
+}
+
+// findManagedInvitation loads the invitation from the :id param together with
+// its user, if the caller administers users of its org.
+func findManagedInvitation(c *fiber.Ctx) (model.Invitation, model.User, int, string) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return model.Invitation{}, model.User{}, fiber.StatusBadRequest, "Invalid ID"
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return model.Invitation{}, model.User{}, fiber.StatusForbidden, "Forbidden"
+	}
+
+	pending, err := invitationRepo.FindInvitationById(id)
+	if err != nil || pending.OrgID != orgId {
+		return model.Invitation{}, model.User{}, fiber.StatusNotFound, "Invitation Not Found"
+	}
+
+	user, err := userRepo.FindUserByIdWithPassword(pending.UserID)
+	if err != nil || user.OrgID != orgId || user.AccountStatus != constants.INVITED {
+		return model.Invitation{}, model.User{}, fiber.StatusNotFound, "Invitation Not Found"
+	}
+
+	return pending, user, fiber.StatusOK, ""
+}
+
+// callerPrincipal returns who is making the request, recorded as the inviter.
+func callerPrincipal(c *fiber.Ctx) (uuid.UUID, constants.PrincipalType) {
+	if org, ok := c.Locals("org").(orgSchema.OrgResponse); ok {
+		return org.ID, constants.ORG
+	}
+	if user, ok := c.Locals("user").(userSchema.UserResponse); ok {
+		return user.ID, constants.USER
+	}
+	if serviceAccount, ok := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse); ok {
+		return serviceAccount.ID, constants.SERVICE_ACCOUNT
+	}
+	return uuid.Nil, ""
+}
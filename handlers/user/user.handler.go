@@ -6,28 +6,37 @@ import (
 	userRepo "balkantask/database/user"
 	"balkantask/model"
 	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
 	userSchema "balkantask/schemas/user"
 	constants "balkantask/utils"
+	"balkantask/utils/hashing"
+	"balkantask/utils/invitation"
+	"balkantask/utils/lockout"
+	pass "balkantask/utils/password"
+	"balkantask/utils/permissions"
+	"balkantask/utils/policy"
 	"balkantask/utils/roles"
+	"balkantask/utils/tokens"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
-	pass "github.com/sethvargo/go-password/password"
 	"github.com/xuri/excelize/v2"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 func GetUsers(c *fiber.Ctx) error {
 	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
 	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
 
-	if !orgOK && !userOK {
+	if !orgOK && !userOK && !serviceAccountOK {
 		return c.Status(400).JSON(fiber.Map{
 			"message": "Unauthorized",
 			"status":  "error",
@@ -40,14 +49,9 @@ func GetUsers(c *fiber.Ctx) error {
 	if orgOK && org.ID != uuid.Nil {
 		users, err = userRepo.FindUsersByOrgId(org.ID)
 	} else if userOK {
-		if !roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.UserReadAccess, roles.OrgFullAccess, roles.OrgReadAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}) {
-			return c.Status(403).JSON(fiber.Map{
-				"message": "Forbidden",
-				"status":  "error",
-			})
-		}
-
 		users, err = userRepo.FindUsersByOrgId(user.OrgId)
+	} else if serviceAccountOK {
+		users, err = userRepo.FindUsersByOrgId(serviceAccount.OrgId)
 	} else {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "Invalid token",
@@ -81,9 +85,17 @@ func GetUserById(c *fiber.Ctx) error {
 
 	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
 	user, userOK := c.Locals("user").(userSchema.UserResponse)
+	_, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
 
 	if userOK {
-		if user.ID != id_uuid && !roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.UserReadAccess, roles.OrgFullAccess, roles.OrgReadAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}) {
+		if user.ID != id_uuid && !permissions.Authorize(c, permissions.UserRead, policy.Resource("user", id_uuid)) {
+			return c.Status(403).JSON(fiber.Map{
+				"message": "Forbidden",
+				"status":  "error",
+			})
+		}
+	} else if serviceAccountOK {
+		if !permissions.Authorize(c, permissions.UserRead, policy.Resource("user", id_uuid)) {
 			return c.Status(403).JSON(fiber.Map{
 				"message": "Forbidden",
 				"status":  "error",
@@ -121,20 +133,16 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
+	org, _ := c.Locals("org").(orgSchema.OrgResponse)
+	user, _ := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
 
 	var orgId uuid.UUID
 
 	if org.ID != uuid.Nil {
 		orgId = org.ID
+	} else if serviceAccountOK {
+		orgId = serviceAccount.OrgId
 	} else {
 		orgId = user.OrgId
 	}
@@ -163,20 +171,38 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if input.Invite {
+		return inviteUser(c, input, orgId)
+	}
+
+	policy, err := pass.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	// If password is not provided, generate a random password
 	if input.Password == "" {
-		input.Password, err = pass.Generate(10, 4, 2, true, true)
+		input.Password, err = pass.Generate(policy)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"message": "Internal Server Error",
 				"status":  "error",
 			})
 		}
+	} else if violation := pass.Violation(policy, input.Password); violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": violation,
+			"status":  "error",
+		})
 	}
 
 	// Create the new user
 	newUser := model.User{
 		Username: input.Username,
+		Email:    strings.ToLower(input.Email),
 	}
 
 	// Validate the new user data
@@ -190,7 +216,7 @@ func CreateUser(c *fiber.Ctx) error {
 	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashing.Hash(input.Password)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "Internal Server Error",
@@ -198,13 +224,12 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	newUser.Password = string(hashedPassword)
+	now := time.Now()
+	newUser.Password = hashedPassword
+	newUser.PasswordChangedAt = &now
+	newUser.MustChangePassword = true
 
-	if org.ID != uuid.Nil {
-		newUser.OrgID = org.ID
-	} else {
-		newUser.OrgID = user.OrgId
-	}
+	newUser.OrgID = orgId
 
 	createdUser, err := userRepo.CreateUser(newUser)
 	if err != nil {
@@ -214,9 +239,14 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := pass.Remember(createdUser.ID, constants.USER, newUser.Password); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
 	resData := userSchema.CreateUserResponse{
 		ID:            createdUser.ID,
 		Username:      createdUser.Username,
+		Email:         createdUser.Email,
 		CreatedAt:     createdUser.CreatedAt,
 		UpdatedAt:     createdUser.UpdatedAt,
 		Roles:         createdUser.Roles,
@@ -257,7 +287,7 @@ func UpdateUser(c *fiber.Ctx) error {
 	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
 	user, userOK := c.Locals("user").(userSchema.UserResponse)
 
-	if !(orgOK || (userOK && user.ID == updatedUser.ID && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
+	if !(orgOK || (userOK && user.ID == updatedUser.ID && permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", updatedUser.ID)))) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"message": "Forbidden",
 			"status":  "error",
@@ -321,34 +351,9 @@ func DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	userLoggedIn, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(userLoggedIn.Roles, userLoggedIn.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
+	orgId, _ := permissions.CallerOrgId(c)
 	userToDelete, err := userRepo.FindUserByIdWithPassword(id)
-	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "User Not Found",
-			"status":  "false",
-		})
-	}
-
-	if userToDelete.ID == uuid.Nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "User Not Found",
-			"status":  "false",
-		})
-	}
-
-	userToDelete, err = userRepo.FindUserByIdWithPassword(id)
-
-	if err != nil {
+	if err != nil || userToDelete.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
@@ -382,16 +387,6 @@ func AddRoleToUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -401,20 +396,36 @@ func AddRoleToUser(c *fiber.Ctx) error {
 		})
 	}
 
-	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
-	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+	if err := policy.ValidateConditions(input.Conditions); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": err.Error(),
+			"status":  "error",
+		})
+	}
+
+	if !permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", input.UserId)) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Account is deactivated",
+			"message": "Forbidden",
 			"status":  "error",
 		})
 	}
-	if err != nil {
+
+	orgId, _ := permissions.CallerOrgId(c)
+	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
+	if err != nil || user_.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
+	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -426,7 +437,7 @@ func AddRoleToUser(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, user_.OrgID)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -440,6 +451,14 @@ func AddRoleToUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// CUSTOM roles can only be given within their org
+	if !roles.AvailableTo(role, user_.OrgID) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Role doesn't exist",
+			"status":  "error",
+		})
+	}
+
 	// Check if the user already has the role
 	if roles.UserHasRole(user_.Roles, role) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -456,6 +475,14 @@ func AddRoleToUser(c *fiber.Ctx) error {
 		})
 	}
 
+	err = rolesRepo.SetRoleConditions(user_.ID, role.ID, input.Conditions)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	mappedUser := userSchema.MapUserRecord(&user_)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -475,16 +502,6 @@ func DeleteRoleFromUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -494,20 +511,29 @@ func DeleteRoleFromUser(c *fiber.Ctx) error {
 		})
 	}
 
-	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
-	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+	if !permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", input.UserId)) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Account is deactivated",
+			"message": "Forbidden",
 			"status":  "error",
 		})
 	}
-	if err != nil {
+
+	orgId, _ := permissions.CallerOrgId(c)
+	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
+	if err != nil || user_.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
+	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
 	// Check if the input contains Role ID or Role Name
 	var role model.Role
 	if input.RoleId != uuid.Nil {
@@ -519,7 +545,7 @@ func DeleteRoleFromUser(c *fiber.Ctx) error {
 			})
 		}
 	} else if input.RoleName != "" {
-		role, err = rolesRepo.GetRoleByName(input.RoleName)
+		role, err = rolesRepo.GetRoleByName(input.RoleName, user_.OrgID)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"message": "Role doesn't exist",
@@ -549,6 +575,14 @@ func DeleteRoleFromUser(c *fiber.Ctx) error {
 		})
 	}
 
+	err = rolesRepo.DeleteRoleConditions(user_.ID, role.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	mappedUser := userSchema.MapUserRecord(&updatedUser)
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -568,18 +602,15 @@ func DeactivateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	userLoggedIn, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(userLoggedIn.Roles, userLoggedIn.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
+	orgId, _ := permissions.CallerOrgId(c)
+	userToDeactivate, err := userRepo.FindUserByIdWithPassword(id)
+	if err != nil || userToDeactivate.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User Not Found",
+			"status":  "false",
 		})
 	}
 
-	userToDeactivate, err := userRepo.FindUserByIdWithPassword(id)
-
 	if userToDeactivate.AccountStatus == constants.DELETED {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"message": "User is deleted",
@@ -601,15 +632,16 @@ func DeactivateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	userToDeactivate.AccountStatus = constants.DEACTIVATED
+	updatedUser, err := userRepo.UpdateUser(userToDeactivate)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"message": "User Not Found",
-			"status":  "false",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
 		})
 	}
 
-	userToDeactivate.AccountStatus = constants.DEACTIVATED
-	updatedUser, err := userRepo.UpdateUser(userToDeactivate)
+	err = tokens.RevokeAllTokens(userToDeactivate.ID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Internal Server Error",
@@ -634,18 +666,15 @@ func ReactivateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	userLoggedIn, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(userLoggedIn.Roles, userLoggedIn.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
+	orgId, _ := permissions.CallerOrgId(c)
+	userToReactivate, err := userRepo.FindUserByIdWithPassword(id)
+	if err != nil || userToReactivate.OrgID != orgId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User Not Found",
+			"status":  "false",
 		})
 	}
 
-	userToReactivate, err := userRepo.FindUserByIdWithPassword(id)
-
 	if userToReactivate.AccountStatus != constants.DEACTIVATED {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"message": "User Account is already activated",
@@ -660,15 +689,62 @@ func ReactivateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	userToReactivate.AccountStatus = constants.ACTIVATED
+	updatedUser, err := userRepo.UpdateUser(userToReactivate)
 	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "User reactivated successfully.",
+		"status":  "success",
+		"data":    updatedUser,
+	})
+}
+
+// UnlockUser lifts a lockout caused by failed logins before it runs out.
+func UnlockUser(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
+	userLoggedIn, userOK := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, _ := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
+
+	entry := model.AuditLog{IP: c.IP(), Details: "Unlocked by admin"}
+	var orgId uuid.UUID
+	if orgOK {
+		orgId, entry.ActorID, entry.ActorType = org.ID, org.ID, constants.ORG
+	} else if userOK {
+		orgId, entry.ActorID, entry.ActorType = userLoggedIn.OrgId, userLoggedIn.ID, constants.USER
+	} else {
+		orgId, entry.ActorID, entry.ActorType = serviceAccount.OrgId, serviceAccount.ID, constants.SERVICE_ACCOUNT
+	}
+
+	userToUnlock, err := userRepo.FindUserByIdWithPassword(id)
+	if err != nil || userToUnlock.OrgID != orgId || userToUnlock.AccountStatus == constants.DELETED {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
-	userToReactivate.AccountStatus = constants.ACTIVATED
-	updatedUser, err := userRepo.UpdateUser(userToReactivate)
+	if userToUnlock.AccountStatus != constants.LOCKED {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "User Account is not locked",
+			"status":  "error",
+		})
+	}
+
+	err = lockout.Unlock(lockout.Account{ID: userToUnlock.ID, Type: constants.USER, OrgID: userToUnlock.OrgID}, entry)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Internal Server Error",
@@ -677,9 +753,8 @@ func ReactivateUser(c *fiber.Ctx) error {
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"message": "User reactivated successfully.",
+		"message": "User unlocked successfully.",
 		"status":  "success",
-		"data":    updatedUser,
 	})
 }
 
@@ -702,20 +777,16 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
+	org, _ := c.Locals("org").(orgSchema.OrgResponse)
+	user, _ := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
 
 	var orgId uuid.UUID
 
 	if org.ID != uuid.Nil {
 		orgId = org.ID
+	} else if serviceAccountOK {
+		orgId = serviceAccount.OrgId
 	} else {
 		orgId = user.OrgId
 	}
@@ -752,6 +823,7 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 	// Define the columns to read from the Excel file (adjust the column numbers accordingly)
 	usernameCol := 1
 	passwordCol := 2
+	emailCol := 3
 
 	rows, err := xlsx.GetRows("Sheet1")
 	if err != nil {
@@ -761,6 +833,18 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 		})
 	}
 
+	policy, err := pass.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	// With invite=true, users without a password are invited by email
+	invite := c.FormValue("invite") == "true"
+	inviterId, inviterType := callerPrincipal(c)
+
 	var seededUsers []userSchema.CreateUserResponse
 
 	for rowIndex, row := range rows {
@@ -769,17 +853,19 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 			continue
 		}
 
-		// Check if the row has enough columns, if not, set an empty password
-		if len(row) < passwordCol {
+		// Check if the row has enough columns, if not, set an empty password and email
+		for len(row) < emailCol {
 			row = append(row, "")
 		}
 
 		username := row[usernameCol-1]
 		password := row[passwordCol-1]
+		email := strings.ToLower(row[emailCol-1])
 
 		excelUser := model.User{
 			Username: username,
 			Password: password,
+			Email:    email,
 		}
 		errors := model.ValidateStruct(excelUser)
 		if errors != nil {
@@ -805,31 +891,54 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 			})
 		}
 
-		if excelUser.Password == "" {
-			excelUser.Password, err = pass.Generate(10, 4, 2, true, true)
+		// In invite mode users without a password choose their own
+		invited := invite && excelUser.Password == ""
+
+		if invited {
+			if excelUser.Email == "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": fmt.Sprintf("Email is required to invite the user in row %d", rowIndex+1),
+					"status":  "error",
+				})
+			}
+		} else if excelUser.Password == "" {
+			excelUser.Password, err = pass.Generate(policy)
 			if err != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 					"message": "Internal Server Error",
 					"status":  "error",
 				})
 			}
-		}
-
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(excelUser.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": "Internal Server Error",
+		} else if violation := pass.Violation(policy, excelUser.Password); violation != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": fmt.Sprintf("Invalid password in row %d: %s", rowIndex+1, violation),
 				"status":  "error",
 			})
 		}
 
 		newUser := model.User{
 			Username:      excelUser.Username,
-			Password:      string(hashedPassword),
-			AccountStatus: constants.ACTIVATED,
+			Email:         excelUser.Email,
+			AccountStatus: constants.INVITED,
 			OrgID:         orgId,
 		}
 
+		if !invited {
+			hashedPassword, err := hashing.Hash(excelUser.Password)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"message": "Internal Server Error",
+					"status":  "error",
+				})
+			}
+
+			now := time.Now()
+			newUser.Password = hashedPassword
+			newUser.AccountStatus = constants.ACTIVATED
+			newUser.PasswordChangedAt = &now
+			newUser.MustChangePassword = true
+		}
+
 		createdUser, err := userRepo.CreateUser(newUser)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -838,15 +947,31 @@ func SeedUsersFromExcel(c *fiber.Ctx) error {
 			})
 		}
 
+		if invited {
+			newUser.ID = createdUser.ID
+			if _, err := invitation.Send(newUser, inviterId, inviterType); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"message": fmt.Sprintf("Failed to invite user in row %d", rowIndex+1),
+					"status":  "error",
+				})
+			}
+		} else if err := pass.Remember(createdUser.ID, constants.USER, newUser.Password); err != nil {
+			fmt.Println("Error recording password history:", err)
+		}
+
 		resData := userSchema.CreateUserResponse{
 			ID:            createdUser.ID,
 			Username:      createdUser.Username,
+			Email:         createdUser.Email,
 			CreatedAt:     createdUser.CreatedAt,
 			UpdatedAt:     createdUser.UpdatedAt,
 			Roles:         createdUser.Roles,
 			OrgId:         createdUser.OrgId,
 			AccountStatus: createdUser.AccountStatus,
-			Passcode:      excelUser.Password,
+		}
+		// Passcodes are only returned outside invite mode
+		if !invite {
+			resData.Passcode = excelUser.Password
 		}
 		seededUsers = append(seededUsers, resData)
 	}
@@ -877,20 +1002,16 @@ func SeedUsersFromCSV(c *fiber.Ctx) error {
 	// Close the file after the function returns
 	defer uploadedFile.Close()
 
-	org, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
+	org, _ := c.Locals("org").(orgSchema.OrgResponse)
+	user, _ := c.Locals("user").(userSchema.UserResponse)
+	serviceAccount, serviceAccountOK := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse)
 
 	var orgId uuid.UUID
 
 	if org.ID != uuid.Nil {
 		orgId = org.ID
+	} else if serviceAccountOK {
+		orgId = serviceAccount.OrgId
 	} else {
 		orgId = user.OrgId
 	}
@@ -935,6 +1056,18 @@ func SeedUsersFromCSV(c *fiber.Ctx) error {
 		})
 	}
 
+	policy, err := pass.PolicyForOrg(orgId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	// With invite=true, users without a password are invited by email
+	invite := c.FormValue("invite") == "true"
+	inviterId, inviterType := callerPrincipal(c)
+
 	var seededUsers []userSchema.CreateUserResponse
 
 	for rowIndex := 1; ; rowIndex++ {
@@ -948,17 +1081,19 @@ func SeedUsersFromCSV(c *fiber.Ctx) error {
 			})
 		}
 
-		// Check if the row has enough columns, if not, set an empty password
-		if len(row) < 2 {
+		// Check if the row has enough columns, if not, set an empty password and email
+		for len(row) < 3 {
 			row = append(row, "")
 		}
 
 		username := row[0]
 		password := row[1]
+		email := strings.ToLower(row[2])
 
 		csvUser := model.User{
 			Username: username,
 			Password: password,
+			Email:    email,
 		}
 		errors := model.ValidateStruct(csvUser)
 		if errors != nil {
@@ -984,31 +1119,54 @@ func SeedUsersFromCSV(c *fiber.Ctx) error {
 			})
 		}
 
-		if csvUser.Password == "" {
-			csvUser.Password, err = pass.Generate(10, 4, 2, true, true)
+		// In invite mode users without a password choose their own
+		invited := invite && csvUser.Password == ""
+
+		if invited {
+			if csvUser.Email == "" {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": fmt.Sprintf("Email is required to invite the user in row %d", rowIndex),
+					"status":  "error",
+				})
+			}
+		} else if csvUser.Password == "" {
+			csvUser.Password, err = pass.Generate(policy)
 			if err != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 					"message": "Internal Server Error",
 					"status":  "error",
 				})
 			}
-		}
-
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(csvUser.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": "Internal Server Error",
+		} else if violation := pass.Violation(policy, csvUser.Password); violation != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": fmt.Sprintf("Invalid password in row %d: %s", rowIndex, violation),
 				"status":  "error",
 			})
 		}
 
 		newUser := model.User{
 			Username:      csvUser.Username,
-			Password:      string(hashedPassword),
-			AccountStatus: constants.ACTIVATED,
+			Email:         csvUser.Email,
+			AccountStatus: constants.INVITED,
 			OrgID:         orgId,
 		}
 
+		if !invited {
+			hashedPassword, err := hashing.Hash(csvUser.Password)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"message": "Internal Server Error",
+					"status":  "error",
+				})
+			}
+
+			now := time.Now()
+			newUser.Password = hashedPassword
+			newUser.AccountStatus = constants.ACTIVATED
+			newUser.PasswordChangedAt = &now
+			newUser.MustChangePassword = true
+		}
+
 		createdUser, err := userRepo.CreateUser(newUser)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -1017,15 +1175,31 @@ func SeedUsersFromCSV(c *fiber.Ctx) error {
 			})
 		}
 
+		if invited {
+			newUser.ID = createdUser.ID
+			if _, err := invitation.Send(newUser, inviterId, inviterType); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"message": fmt.Sprintf("Failed to invite user in row %d", rowIndex),
+					"status":  "error",
+				})
+			}
+		} else if err := pass.Remember(createdUser.ID, constants.USER, newUser.Password); err != nil {
+			fmt.Println("Error recording password history:", err)
+		}
+
 		resData := userSchema.CreateUserResponse{
 			ID:            createdUser.ID,
 			Username:      createdUser.Username,
+			Email:         createdUser.Email,
 			CreatedAt:     createdUser.CreatedAt,
 			UpdatedAt:     createdUser.UpdatedAt,
 			Roles:         createdUser.Roles,
 			OrgId:         createdUser.OrgId,
 			AccountStatus: createdUser.AccountStatus,
-			Passcode:      csvUser.Password,
+		}
+		// Passcodes are only returned outside invite mode
+		if !invite {
+			resData.Passcode = csvUser.Password
 		}
 		seededUsers = append(seededUsers, resData)
 	}
@@ -1054,14 +1228,7 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
 	user, userOK := c.Locals("user").(userSchema.UserResponse)
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
 
 	// Validate the input fields
 	errors := model.ValidateStruct(input)
@@ -1073,31 +1240,60 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
-	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
-	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+	if !permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", input.UserId)) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Account is deactivated",
+			"message": "Forbidden",
 			"status":  "error",
 		})
 	}
 
-	if err != nil {
+	orgId, _ := permissions.CallerOrgId(c)
+	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
+	if err != nil || user_.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
+	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
 	// check if new password is the same as the old password
-	err = bcrypt.CompareHashAndPassword([]byte(user_.Password), []byte(input.Password))
-	if err == nil {
+	if hashing.Verify(input.Password, user_.Password) {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"message": "New password cannot be the same as the old password",
 			"status":  "error",
 		})
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	policy, err := pass.PolicyForOrg(user_.OrgID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	violation, err := pass.Check(policy, user_.ID, input.Password)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+	if violation != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": violation,
+			"status":  "error",
+		})
+	}
+
+	hashedPassword, err := hashing.Hash(input.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Internal Server Error",
@@ -1105,7 +1301,11 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
-	user_.Password = string(hashedPassword)
+	now := time.Now()
+	user_.Password = hashedPassword
+	user_.PasswordChangedAt = &now
+	// A password set by an admin has to be replaced at the next login
+	user_.MustChangePassword = !(userOK && user.ID == user_.ID)
 
 	updatedUser, err := userRepo.UpdateUser(user_)
 	if err != nil {
@@ -1115,6 +1315,18 @@ func ChangePassword(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := pass.Remember(user_.ID, constants.USER, user_.Password); err != nil {
+		fmt.Println("Error recording password history:", err)
+	}
+
+	err = tokens.RevokeAllTokens(user_.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"message": "Password updated successfully",
 		"status":  "success",
@@ -1132,16 +1344,6 @@ func AddGroupToUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1151,22 +1353,29 @@ func AddGroupToUser(c *fiber.Ctx) error {
 		})
 	}
 
-	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
-
-	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+	if !permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", input.UserId)) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Account is deactivated",
+			"message": "Forbidden",
 			"status":  "error",
 		})
 	}
 
-	if err != nil {
+	orgId, _ := permissions.CallerOrgId(c)
+	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
+	if err != nil || user_.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
+	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
 	// Check if the input contains Group ID or Group Name
 	var group model.Group
 	if input.GroupId != uuid.Nil {
@@ -1227,16 +1436,6 @@ func DeleteGroupFromUser(c *fiber.Ctx) error {
 		})
 	}
 
-	_, orgOK := c.Locals("org").(orgSchema.OrgResponse)
-	user, userOK := c.Locals("user").(userSchema.UserResponse)
-
-	if !(orgOK || (userOK && roles.UserIsAuthorized(user.Roles, user.Groups, []roles.Role{roles.OrgFullAccess, roles.UserFullAccess, roles.OrgWriteAccess, roles.UserWriteAccess}))) {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Forbidden",
-			"status":  "error",
-		})
-	}
-
 	errors := model.ValidateStruct(input)
 	if errors != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1246,20 +1445,29 @@ func DeleteGroupFromUser(c *fiber.Ctx) error {
 		})
 	}
 
-	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
-	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+	if !permissions.Authorize(c, permissions.UserWrite, policy.Resource("user", input.UserId)) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-			"message": "Account is deactivated",
+			"message": "Forbidden",
 			"status":  "error",
 		})
 	}
-	if err != nil {
+
+	orgId, _ := permissions.CallerOrgId(c)
+	user_, err := userRepo.FindUserByIdWithPassword(input.UserId)
+	if err != nil || user_.OrgID != orgId {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"message": "User Not Found",
 			"status":  "false",
 		})
 	}
 
+	if user_.AccountStatus == constants.DEACTIVATED || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Account is deactivated",
+			"status":  "error",
+		})
+	}
+
 	// Check if the input contains Group ID or Group Name
 	var group model.Group
 	if input.GroupId != uuid.Nil {
@@ -1307,3 +1515,72 @@ func DeleteGroupFromUser(c *fiber.Ctx) error {
 		"data":    updatedUser,
 	})
 }
+
+// UpdateUserAttributes replaces the attributes of a user of the org, which
+// conditions of roles and policies can test. Callers cannot change their own
+// attributes, they may be what restricts them.
+func UpdateUserAttributes(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid ID",
+			"status":  "error",
+		})
+	}
+
+	var input userSchema.UpdateAttributes
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Bad Request",
+			"status":  "error",
+		})
+	}
+
+	errors := model.ValidateStruct(input)
+	if errors != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation Error",
+			"status":  "error",
+			"errors":  errors,
+		})
+	}
+
+	orgId, ok := permissions.CallerOrgId(c)
+	if !ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Forbidden",
+			"status":  "error",
+		})
+	}
+
+	if callerId, _ := callerPrincipal(c); callerId == id {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"message": "Cannot change your own attributes",
+			"status":  "error",
+		})
+	}
+
+	user_, err := userRepo.FindUserByIdWithPassword(id)
+	if err != nil || user_.OrgID != orgId || user_.AccountStatus == constants.DELETED {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"message": "User Not Found",
+			"status":  "false",
+		})
+	}
+
+	err = userRepo.UpdateUserAttributes(user_.ID, input.Attributes)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Internal Server Error",
+			"status":  "error",
+		})
+	}
+
+	user_.Attributes = input.Attributes
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Attributes updated",
+		"status":  "success",
+		"data":    userSchema.MapUserRecord(&user_),
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"balkantask/database"
 	"balkantask/router"
 	"balkantask/utils/schedulers"
+	"balkantask/utils/tokens"
 	"log"
+	"os"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -13,19 +15,25 @@ import (
 )
 
 func main() {
-	app := fiber.New()
-
-	app.Use(logger.New())
-	app.Use(cors.New())
-
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
+	app := fiber.New()
+
+	app.Use(logger.New())
+	app.Use(cors.New(corsConfig()))
+
 	database.Connect()
 
+	err = tokens.LoadKeys()
+	if err != nil {
+		log.Fatal("Failed to load JWT signing keys.\n", err)
+	}
+
 	go schedulers.Scheduler()
+	go schedulers.LDAPSyncScheduler()
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{
@@ -45,3 +53,14 @@ func main() {
 
 	log.Fatal(app.Listen(":3000"))
 }
+
+// corsConfig lets the browser apps on CORS_ALLOWED_ORIGINS send their
+// session cookies along. Without it any origin is allowed, but no cookies.
+func corsConfig() cors.Config {
+	origins := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if origins == "" {
+		return cors.ConfigDefault
+	}
+
+	return cors.Config{AllowOrigins: origins, AllowCredentials: true}
+}
@@ -2,13 +2,17 @@ package middleware
 
 import (
 	orgrepository "balkantask/database/org"
+	serviceAccountRepo "balkantask/database/serviceAccount"
+	tokensRepo "balkantask/database/tokens"
 	userRepo "balkantask/database/user"
 	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
 	userSchema "balkantask/schemas/user"
 	constants "balkantask/utils"
+	"balkantask/utils/sessionCookie"
+	"balkantask/utils/tokens"
 
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,39 +21,64 @@ import (
 )
 
 func CheckJWT(c *fiber.Ctx) error {
+	return checkJWT(c, false)
+}
+
+// CheckClientJWT also accepts access tokens a user delegated to an OAuth
+// client. Only the OIDC endpoints such as /oauth/userinfo use it; the rest
+// of the API stays out of reach of third party clients.
+func CheckClientJWT(c *fiber.Ctx) error {
+	return checkJWT(c, true)
+}
+
+func checkJWT(c *fiber.Ctx, allowClientTokens bool) error {
+	if apiKey := c.Get("X-API-Key"); apiKey != "" {
+		return checkAPIKey(c, apiKey)
+	}
+
 	var tokenString string
+	fromCookie := false
 	authorization := c.Get("Authorization")
 
 	if strings.HasPrefix(authorization, "Bearer ") {
 		tokenString = strings.TrimPrefix(authorization, "Bearer ")
 	} else if len(authorization) > 0 {
 		tokenString = authorization
-	} else if c.Cookies("token") != "" {
-		tokenString = c.Cookies("token")
+	} else if c.Cookies(sessionCookie.AccessTokenCookie) != "" {
+		tokenString, fromCookie = c.Cookies(sessionCookie.AccessTokenCookie), true
 	}
 
 	if tokenString == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "You are not logged in"})
 	}
 
-	tokenByte, err := jwt.Parse(tokenString, func(jwtToken *jwt.Token) (interface{}, error) {
-		if _, ok := jwtToken.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %s", jwtToken.Header["alg"])
-		}
-
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+	tokenByte, err := tokens.ParseToken(tokenString)
 
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": fmt.Sprintf("Invalid token: %v", err)})
 	}
 
 	claims, ok := tokenByte.Claims.(jwt.MapClaims)
-	if !ok || !tokenByte.Valid {
+	if !ok || !tokenByte.Valid || claims["token_use"] != tokens.AccessTokenUse {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid token"})
 
 	}
 
+	// A service account's own client_credentials token carries its client_id
+	// too, but it acts for itself and not on behalf of a user
+	if _, delegated := claims["client_id"]; delegated && !allowClientTokens && claims["principal_type"] != string(constants.SERVICE_ACCOUNT) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "Tokens issued to an OAuth client are only accepted by the OIDC endpoints"})
+	}
+
+	// The browser sends cookies on its own, so state-changing requests also
+	// need the CSRF token that was issued together with the access token
+	if fromCookie && !sessionCookie.SafeMethod(c) {
+		csrfHash, _ := claims["csrf"].(string)
+		if !sessionCookie.ValidHeader(c, csrfHash) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "Invalid CSRF token"})
+		}
+	}
+
 	id_uuid, err := uuid.Parse(fmt.Sprint(claims["sub"]))
 
 	if err != nil {
@@ -57,6 +86,40 @@ func CheckJWT(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "error", "message": "Something Went Wrong"})
 	}
 
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid token"})
+	}
+
+	revoked, err := tokensRepo.IsAccessTokenRevoked(fmt.Sprint(claims["jti"]), id_uuid, issuedAt.Time)
+	if err != nil || revoked {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Token has been revoked"})
+	}
+
+	if sessionRevoked(c, claims, id_uuid) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Session has been revoked"})
+	}
+
+	if claims["principal_type"] == string(constants.SERVICE_ACCOUNT) {
+		serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id_uuid)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Invalid token"})
+		}
+
+		if serviceAccount.Org != nil && serviceAccount.Org.AccountStatus == constants.DELETED {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Account does not exist"})
+		}
+
+		if serviceAccount.AccountStatus != constants.ACTIVATED {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Account deactivated"})
+		}
+
+		c.Locals("serviceAccount", serviceAccountSchema.MapServiceAccountRecord(&serviceAccount))
+		c.Locals("claims", claims)
+
+		return c.Next()
+	}
+
 	user, err := userRepo.FindUserByIdWithPassword(id_uuid)
 	org, orgErr := orgrepository.FindOrgById(id_uuid)
 	if err != nil && orgErr != nil {
@@ -71,13 +134,23 @@ func CheckJWT(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Account deactivated"})
 	}
 
+	var subjectType constants.PrincipalType
+	var orgId uuid.UUID
 	if user.ID.String() == claims["sub"] {
 		c.Locals("user", userSchema.MapUserRecord(&user))
+		subjectType, orgId = constants.USER, user.OrgID
 	} else if org.ID.String() == claims["sub"] {
 		c.Locals("org", orgSchema.MapOrgRecord(&org))
+		subjectType, orgId = constants.ORG, org.ID
 	} else {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "false", "message": "Invalid token"})
 	}
 
+	c.Locals("claims", claims)
+
+	if actor, ok := tokens.ActorFromClaims(claims); ok {
+		return impersonatedRequest(c, actor, id_uuid, subjectType, orgId)
+	}
+
 	return c.Next()
 }
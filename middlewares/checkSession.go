@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	sessionRepo "balkantask/database/session"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sessions are not written on every request, only when they were last seen
+// longer ago than this or from another IP
+const lastSeenInterval = time.Minute
+
+// sessionRevoked checks the session (sid claim) of an access token and
+// records that it was used. Tokens outside a session, e.g. from the
+// client_credentials grant, pass.
+func sessionRevoked(c *fiber.Ctx, claims jwt.MapClaims, subject uuid.UUID) bool {
+	sessionId, err := uuid.Parse(fmt.Sprint(claims["sid"]))
+	if err != nil || sessionId == uuid.Nil {
+		return false
+	}
+
+	session, err := sessionRepo.FindSessionById(sessionId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false
+	}
+	if err != nil || session.RevokedAt != nil || session.SubjectID != subject {
+		return true
+	}
+
+	if time.Since(session.LastSeenAt) > lastSeenInterval || session.IP != c.IP() {
+		if err := sessionRepo.TouchSession(session.ID, c.IP()); err != nil {
+			fmt.Println("Error updating session:", err)
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"balkantask/model"
+	constants "balkantask/utils"
+	"balkantask/utils/audit"
+	"balkantask/utils/tokens"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// DenyImpersonation guards routes an impersonating admin must not use, e.g.
+// changing the user's password or MFA. Put it after CheckJWT.
+func DenyImpersonation(c *fiber.Ctx) error {
+	if _, ok := c.Locals("actor").(tokens.Actor); ok {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"status": "error", "message": "Not allowed while impersonating"})
+	}
+
+	return c.Next()
+}
+
+// impersonatedRequest runs a request made with an impersonation token. The
+// actor is exposed to handlers as the actor local and every request is
+// written to the audit log of the org.
+func impersonatedRequest(c *fiber.Ctx, actor tokens.Actor, subject uuid.UUID, subjectType constants.PrincipalType, orgId uuid.UUID) error {
+	if !tokens.PrincipalIsActive(actor.ID, actor.Type) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"status": "false", "message": "Impersonation has ended"})
+	}
+
+	c.Locals("actor", actor)
+	c.Set("X-Impersonated-By", actor.ID.String())
+
+	err := c.Next()
+
+	audit.Record(model.AuditLog{
+		OrgID:      orgId,
+		ActorID:    actor.ID,
+		ActorType:  actor.Type,
+		Action:     audit.ImpersonatedRequest,
+		TargetID:   subject,
+		TargetType: subjectType,
+		IP:         c.IP(),
+		Details:    fmt.Sprintf("%s %s %d", c.Method(), c.Path(), c.Response().StatusCode()),
+	})
+
+	return err
+}
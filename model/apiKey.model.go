@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential a user hands to scripts. When Roles is
+// not empty the key only carries those of the owner's roles.
+type APIKey struct {
+	BaseModel
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	User       *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	OrgID      uuid.UUID `gorm:"type:uuid;not null;index"`
+	Label      string    `gorm:"type:varchar(100);not null"`
+	Prefix     string    `gorm:"type:varchar(16);not null"`
+	KeyHash    string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Roles      []Role    `gorm:"many2many:api_key_roles;constraint:OnDelete:CASCADE;"`
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+	LastUsedIP string `gorm:"type:varchar(45)"`
+}
+
+func (APIKey) PrimaryKey() string {
+	return "Id"
+}
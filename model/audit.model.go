@@ -0,0 +1,25 @@
+package model
+
+import (
+	constants "balkantask/utils"
+
+	"github.com/google/uuid"
+)
+
+// AuditLog records a security relevant event. ActorID is empty for events
+// raised by the system itself, like an automatic lockout.
+type AuditLog struct {
+	BaseModel
+	OrgID      uuid.UUID               `gorm:"type:uuid;index"`
+	ActorID    uuid.UUID               `gorm:"type:uuid"`
+	ActorType  constants.PrincipalType `gorm:"type:varchar(20)"`
+	Action     string                  `gorm:"type:varchar(64);not null;index"`
+	TargetID   uuid.UUID               `gorm:"type:uuid;index"`
+	TargetType constants.PrincipalType `gorm:"type:varchar(20)"`
+	IP         string                  `gorm:"type:varchar(64)"`
+	Details    string                  `gorm:"type:text"`
+}
+
+func (AuditLog) PrimaryKey() string {
+	return "Id"
+}
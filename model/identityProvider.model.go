@@ -0,0 +1,47 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdentityProvider is an upstream OpenID Connect provider an org lets its
+// users sign in with. The client secret is kept in clear because it has to be
+// presented to the provider's token endpoint.
+type IdentityProvider struct {
+	BaseModel
+	OrgID         uuid.UUID `gorm:"type:uuid;not null;index"`
+	Org           *Org      `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	Name          string    `gorm:"type:varchar(100);not null"`
+	Issuer        string    `gorm:"type:varchar(255);not null"`
+	ClientID      string    `gorm:"type:varchar(255);not null"`
+	ClientSecret  string    `gorm:"type:varchar(255);not null"`
+	Scopes        []string  `gorm:"type:text;serializer:json"`
+	UsernameClaim string    `gorm:"type:varchar(100);not null"`
+	EmailClaim    string    `gorm:"type:varchar(100);not null"`
+	GroupsClaim   string    `gorm:"type:varchar(100);not null"`
+	// Upstream group name to the name of one of our groups
+	GroupMappings map[string]string `gorm:"type:text;serializer:json"`
+	// Create a user on the first login of an identity that matches none
+	AutoProvision bool `gorm:"not null;default:false"`
+	Disabled      bool `gorm:"not null;default:false"`
+}
+
+func (IdentityProvider) PrimaryKey() string {
+	return "Id"
+}
+
+// FederatedIdentity links the subject of an upstream provider to a user.
+type FederatedIdentity struct {
+	BaseModel
+	ProviderID  uuid.UUID         `gorm:"type:uuid;not null;uniqueIndex:idx_federated_subject"`
+	Provider    *IdentityProvider `gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE;"`
+	Subject     string            `gorm:"type:varchar(255);not null;uniqueIndex:idx_federated_subject"`
+	UserID      uuid.UUID         `gorm:"type:uuid;not null;index"`
+	LastLoginAt *time.Time
+}
+
+func (FederatedIdentity) PrimaryKey() string {
+	return "Id"
+}
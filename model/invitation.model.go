@@ -0,0 +1,25 @@
+package model
+
+import (
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invitation lets an INVITED user choose a password. Only the hash of the
+// token from the link is stored; resending the invitation replaces it.
+type Invitation struct {
+	BaseModel
+	UserID        uuid.UUID               `gorm:"type:uuid;not null;uniqueIndex"`
+	OrgID         uuid.UUID               `gorm:"type:uuid;not null;index"`
+	TokenHash     string                  `gorm:"type:varchar(64);not null;uniqueIndex"`
+	InvitedByID   uuid.UUID               `gorm:"type:uuid"`
+	InvitedByType constants.PrincipalType `gorm:"type:varchar(20)"`
+	SentAt        time.Time               `gorm:"not null"`
+	ExpiresAt     time.Time               `gorm:"not null"`
+}
+
+func (Invitation) PrimaryKey() string {
+	return "Id"
+}
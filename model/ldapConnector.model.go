@@ -0,0 +1,40 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LDAPConnector syncs the users and groups of an org from its LDAP
+// directory. The bind password is kept in clear because it has to be sent to
+// the directory.
+type LDAPConnector struct {
+	BaseModel
+	OrgID        uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	Org          *Org      `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	URL          string    `gorm:"type:varchar(255);not null"`
+	StartTLS     bool      `gorm:"not null;default:false"`
+	BindDN       string    `gorm:"type:varchar(512);not null"`
+	BindPassword string    `gorm:"type:varchar(255);not null"`
+	BaseDN       string    `gorm:"type:varchar(512);not null"`
+	UserFilter   string    `gorm:"type:varchar(512);not null"`
+	// Defaults to BaseDN
+	GroupBaseDN          string `gorm:"type:varchar(512)"`
+	GroupFilter          string `gorm:"type:varchar(512);not null"`
+	UsernameAttribute    string `gorm:"type:varchar(100);not null"`
+	EmailAttribute       string `gorm:"type:varchar(100);not null"`
+	GroupNameAttribute   string `gorm:"type:varchar(100);not null"`
+	GroupMemberAttribute string `gorm:"type:varchar(100);not null"`
+	// Directory group name to the name of one of our groups
+	GroupMappings map[string]string `gorm:"type:text;serializer:json"`
+	// Check the passwords of synced users by binding as them
+	BindAuth      bool `gorm:"not null;default:false"`
+	SyncDisabled  bool `gorm:"not null;default:false"`
+	LastSyncAt    *time.Time
+	LastSyncError string `gorm:"type:text"`
+}
+
+func (LDAPConnector) PrimaryKey() string {
+	return "Id"
+}
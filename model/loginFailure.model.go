@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+// LoginFailure counts recent failed logins for one key: an account
+// ("account:<id>") or a client IP ("ip:<address>").
+type LoginFailure struct {
+	BaseModel
+	Key          string    `gorm:"type:varchar(100);not null;uniqueIndex"`
+	Failures     int       `gorm:"not null;default:0"`
+	LastFailedAt time.Time `gorm:"not null"`
+	LockedUntil  *time.Time
+}
+
+func (LoginFailure) PrimaryKey() string {
+	return "Id"
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFAFactor is the TOTP secret of a user or org root. It only counts as a
+// second factor once ConfirmedAt is set, i.e. after a first valid code.
+type MFAFactor struct {
+	BaseModel
+	SubjectID    uuid.UUID               `gorm:"type:uuid;not null;uniqueIndex"`
+	SubjectType  constants.PrincipalType `gorm:"type:varchar(20);not null"`
+	Secret       string                  `gorm:"type:varchar(64);not null"`
+	ConfirmedAt  *time.Time
+	LastUsedStep int64 `gorm:"not null;default:0"`
+}
+
+func (MFAFactor) PrimaryKey() string {
+	return "Id"
+}
+
+type RecoveryCode struct {
+	BaseModel
+	SubjectID uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time
+}
+
+func (RecoveryCode) PrimaryKey() string {
+	return "Id"
+}
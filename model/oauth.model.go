@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthClient is an application registered by an org to sign its users in
+// through the OpenID Connect endpoints. Public clients have no secret.
+type OAuthClient struct {
+	BaseModel
+	OrgID        uuid.UUID `gorm:"type:uuid;not null;index"`
+	Org          *Org      `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	Name         string    `gorm:"type:varchar(100);not null"`
+	ClientID     string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	SecretHash   string    `gorm:"type:varchar(255)"`
+	RedirectURIs []string  `gorm:"type:text;serializer:json"`
+}
+
+func (OAuthClient) PrimaryKey() string {
+	return "Id"
+}
+
+type AuthorizationCode struct {
+	BaseModel
+	CodeHash            string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	ClientID            string    `gorm:"type:varchar(64);not null"`
+	UserID              uuid.UUID `gorm:"type:uuid;not null"`
+	RedirectURI         string    `gorm:"type:text;not null"`
+	Scope               string    `gorm:"type:varchar(255)"`
+	Nonce               string    `gorm:"type:varchar(255)"`
+	CodeChallenge       string    `gorm:"type:varchar(128);not null"`
+	CodeChallengeMethod string    `gorm:"type:varchar(10);not null"`
+	ExpiresAt           time.Time `gorm:"not null"`
+	UsedAt              *time.Time
+}
+
+func (AuthorizationCode) PrimaryKey() string {
+	return "Id"
+}
+
+// OAuthConsent remembers the scopes a user already granted to a client.
+type OAuthConsent struct {
+	BaseModel
+	UserID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_consent_user_client"`
+	ClientID string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_consent_user_client"`
+	Scope    string    `gorm:"type:varchar(255)"`
+}
+
+func (OAuthConsent) PrimaryKey() string {
+	return "Id"
+}
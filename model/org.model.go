@@ -9,11 +9,18 @@ type Org struct {
 	BaseModel
 	Username      string                  `gorm:"type:varchar(100);not null"`
 	Email         string                  `gorm:"type:varchar(100);uniqueIndex;not null"`
-	Password      string                  `gorm:"type:varchar(100);not null"`
+	Password      string                  `gorm:"type:varchar(255);not null"`
 	Users         []User                  `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
 	AccountStatus constants.AccountStatus `gorm:"type:varchar(100);not null;default:'active'"`
-	CreatedAt     *time.Time              `gorm:"not null;default:now()"`
-	UpdatedAt     *time.Time              `gorm:"not null;default:now()"`
+	RequireMFA    bool                    `gorm:"not null;default:false"`
+	// Users can only sign in through an identity provider; the root keeps its password
+	DisablePasswordLogin bool       `gorm:"not null;default:false"`
+	CreatedAt            *time.Time `gorm:"not null;default:now()"`
+	UpdatedAt            *time.Time `gorm:"not null;default:now()"`
+	PasswordChangedAt    *time.Time
+	EmailVerifiedAt      *time.Time
+	// When the last verification link was sent, to throttle re-sends
+	VerificationSentAt *time.Time
 }
 
 func (Org) PrimaryKey() string {
@@ -0,0 +1,38 @@
+package model
+
+import (
+	constants "balkantask/utils"
+
+	"github.com/google/uuid"
+)
+
+// PasswordPolicy holds the password rules of an org. Orgs without one use
+// the default policy.
+type PasswordPolicy struct {
+	BaseModel
+	OrgID            uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	MinLength        int       `gorm:"not null;default:8"`
+	RequireUppercase bool      `gorm:"not null;default:true"`
+	RequireLowercase bool      `gorm:"not null;default:true"`
+	RequireDigit     bool      `gorm:"not null;default:true"`
+	RequireSpecial   bool      `gorm:"not null;default:true"`
+	BannedWords      []string  `gorm:"type:text;serializer:json"`
+	HistoryDepth     int       `gorm:"not null;default:0"`
+	MaxAgeDays       int       `gorm:"not null;default:0"`
+}
+
+func (PasswordPolicy) PrimaryKey() string {
+	return "Id"
+}
+
+// PasswordHistory keeps the hashes of passwords a principal used before.
+type PasswordHistory struct {
+	BaseModel
+	SubjectID    uuid.UUID               `gorm:"type:uuid;not null;index"`
+	SubjectType  constants.PrincipalType `gorm:"type:varchar(20);not null"`
+	PasswordHash string                  `gorm:"type:varchar(255);not null"`
+}
+
+func (PasswordHistory) PrimaryKey() string {
+	return "Id"
+}
@@ -0,0 +1,49 @@
+package model
+
+import "github.com/google/uuid"
+
+// Policy is a JSON policy document of an org. It is attached to users,
+// groups and roles through PolicyAttachment.
+type Policy struct {
+	BaseModel
+	OrgID       uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_policy_org_name"`
+	Org         *Org           `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	Name        string         `gorm:"type:varchar(100);not null;uniqueIndex:idx_policy_org_name"`
+	Description string         `gorm:"type:varchar(255)"`
+	Document    PolicyDocument `gorm:"type:text;serializer:json"`
+}
+
+func (Policy) PrimaryKey() string {
+	return "Id"
+}
+
+// PolicyDocument is the document an admin writes, e.g.
+//
+//	{"statement": [{"effect": "Allow", "actions": ["task:*"], "resources": ["task/<id>"]}]}
+type PolicyDocument struct {
+	Version   string            `json:"version,omitempty"`
+	Statement []PolicyStatement `json:"statement"`
+}
+
+type PolicyStatement struct {
+	Sid       string   `json:"sid,omitempty"`
+	Effect    string   `json:"effect"`
+	Actions   []string `json:"actions"`
+	Resources []string `json:"resources"`
+	// Operator to the keys it tests and their allowed values, e.g.
+	// {"StringEquals": {"user:username": ["alice"]}}
+	Conditions map[string]map[string][]string `json:"conditions,omitempty"`
+}
+
+// PolicyAttachment gives a policy to a user, a group or a role.
+type PolicyAttachment struct {
+	BaseModel
+	PolicyID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_policy_attachment"`
+	Policy        *Policy   `gorm:"foreignKey:PolicyID;constraint:OnDelete:CASCADE;"`
+	PrincipalType string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_policy_attachment"`
+	PrincipalID   uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_policy_attachment;index"`
+}
+
+func (PolicyAttachment) PrimaryKey() string {
+	return "Id"
+}
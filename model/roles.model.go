@@ -1,14 +1,38 @@
 package model
 
+import "github.com/google/uuid"
+
+// Role is either one of the SYSTEM roles, whose permissions are built in, or
+// a CUSTOM role of an org made of permissions from the catalogue. A role also
+// grants what the roles it includes grant.
 type Role struct {
 	BaseModel
-	Name   string  `gorm:"type:varchar(100);not null; uniqueIndex"`
-	Type   string  `gorm:"type:varchar(100);not null"`
-	Users  []User  `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
-	Groups []Group `gorm:"many2many:group_roles;constraint:OnDelete:CASCADE;"`
-	Tasks  []Task  `gorm:"many2many:task_roles;constraint:OnDelete:CASCADE;"`
+	// Names are unique within an org; SYSTEM roles have no org
+	Name        string     `gorm:"type:varchar(100);not null;uniqueIndex:idx_role_org_name,priority:2;uniqueIndex:idx_role_system_name,where:org_id IS NULL"`
+	Type        string     `gorm:"type:varchar(100);not null"`
+	OrgID       *uuid.UUID `gorm:"type:uuid;index;uniqueIndex:idx_role_org_name,priority:1"`
+	Permissions []string   `gorm:"type:text;serializer:json"`
+	// Only stored for CUSTOM roles, the hierarchy of SYSTEM roles is built in
+	Includes []Role  `gorm:"many2many:role_includes;joinForeignKey:RoleID;joinReferences:IncludedRoleID;constraint:OnDelete:CASCADE;"`
+	Users    []User  `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
+	Groups   []Group `gorm:"many2many:group_roles;constraint:OnDelete:CASCADE;"`
+	Tasks    []Task  `gorm:"many2many:task_roles;constraint:OnDelete:CASCADE;"`
 }
 
 func (Role) PrimaryKey() string {
 	return "Id"
 }
+
+// RoleCondition limits a role held by a user, a service account or a group
+// to requests that meet the conditions, e.g. from the office network. The
+// conditions are written like the ones of a policy statement.
+type RoleCondition struct {
+	BaseModel
+	PrincipalID uuid.UUID                      `gorm:"type:uuid;not null;uniqueIndex:idx_role_condition"`
+	RoleID      uuid.UUID                      `gorm:"type:uuid;not null;uniqueIndex:idx_role_condition"`
+	Conditions  map[string]map[string][]string `gorm:"type:text;serializer:json"`
+}
+
+func (RoleCondition) PrimaryKey() string {
+	return "Id"
+}
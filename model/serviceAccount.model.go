@@ -0,0 +1,26 @@
+package model
+
+import (
+	constants "balkantask/utils"
+
+	"github.com/google/uuid"
+)
+
+// ServiceAccount is a non-human principal owned by an org. It authenticates
+// with a client ID and secret through the client_credentials grant and gets
+// its permissions from roles and groups, the same way users do.
+type ServiceAccount struct {
+	BaseModel
+	Name          string                  `gorm:"type:varchar(100);not null"`
+	OrgID         uuid.UUID               `gorm:"type:uuid;not null;index"`
+	Org           *Org                    `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	ClientID      string                  `gorm:"type:varchar(64);not null;uniqueIndex"`
+	SecretHash    string                  `gorm:"type:varchar(255);not null"`
+	Roles         []Role                  `gorm:"many2many:service_account_roles;constraint:OnDelete:CASCADE;"`
+	Groups        []Group                 `gorm:"many2many:service_account_groups;constraint:OnDelete:CASCADE;"`
+	AccountStatus constants.AccountStatus `gorm:"type:varchar(100);not null;default:'ACTIVATED'"`
+}
+
+func (ServiceAccount) PrimaryKey() string {
+	return "Id"
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one login of a principal, e.g. on one device. Its ID is the
+// refresh token family and the sid claim of every access token of the login.
+// ActorID is set when an admin impersonates the principal.
+type Session struct {
+	BaseModel
+	SubjectID   uuid.UUID               `gorm:"type:uuid;not null;index"`
+	SubjectType constants.PrincipalType `gorm:"type:varchar(20);not null"`
+	ActorID     uuid.UUID               `gorm:"type:uuid"`
+	ClientID    string                  `gorm:"type:varchar(64)"`
+	UserAgent   string                  `gorm:"type:varchar(255)"`
+	IP          string                  `gorm:"type:varchar(64)"`
+	AuthMethods string                  `gorm:"type:varchar(64)"`
+	LastSeenAt  time.Time               `gorm:"not null"`
+	ExpiresAt   time.Time               `gorm:"not null"`
+	RevokedAt   *time.Time
+}
+
+func (Session) PrimaryKey() string {
+	return "Id"
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type RefreshToken struct {
+	BaseModel
+	TokenHash   string                  `gorm:"type:varchar(64);not null;uniqueIndex"`
+	FamilyID    uuid.UUID               `gorm:"type:uuid;not null;index"`
+	SubjectID   uuid.UUID               `gorm:"type:uuid;not null;index"`
+	SubjectType constants.PrincipalType `gorm:"type:varchar(20);not null"`
+	ClientID    string                  `gorm:"type:varchar(64)"`
+	Scope       string                  `gorm:"type:varchar(255)"`
+	AuthMethods string                  `gorm:"type:varchar(64)"`
+	ExpiresAt   time.Time               `gorm:"not null"`
+	UsedAt      *time.Time
+	RevokedAt   *time.Time
+}
+
+func (RefreshToken) PrimaryKey() string {
+	return "Id"
+}
+
+// RevokedToken is a single access token (by jti) that was revoked before expiry.
+type RevokedToken struct {
+	BaseModel
+	JTI       string    `gorm:"type:varchar(64);not null;uniqueIndex"`
+	SubjectID uuid.UUID `gorm:"type:uuid;not null;index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (RevokedToken) PrimaryKey() string {
+	return "Id"
+}
+
+// SubjectRevocation invalidates every access token of a principal issued
+// up to the second of RevokedAt. The row is useless once those tokens have expired.
+type SubjectRevocation struct {
+	BaseModel
+	SubjectID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	RevokedAt time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func (SubjectRevocation) PrimaryKey() string {
+	return "Id"
+}
+
+// SigningKey is one entry of the JWT key ring. Retired keys are kept until
+// ExpiresAt so tokens they signed can still be verified.
+type SigningKey struct {
+	BaseModel
+	Kid        string `gorm:"type:varchar(64);not null;uniqueIndex"`
+	Algorithm  string `gorm:"type:varchar(20);not null"`
+	PrivateKey string `gorm:"type:text;not null"`
+	PublicKey  string `gorm:"type:text;not null"`
+	RetiredAt  *time.Time
+	ExpiresAt  *time.Time
+}
+
+func (SigningKey) PrimaryKey() string {
+	return "Id"
+}
+
+// PasswordResetToken is a single-use token mailed to a principal who forgot
+// its password. Only the hash is stored.
+type PasswordResetToken struct {
+	BaseModel
+	TokenHash   string                  `gorm:"type:varchar(64);not null;uniqueIndex"`
+	SubjectID   uuid.UUID               `gorm:"type:uuid;not null;index"`
+	SubjectType constants.PrincipalType `gorm:"type:varchar(20);not null"`
+	ExpiresAt   time.Time               `gorm:"not null"`
+	UsedAt      *time.Time
+}
+
+func (PasswordResetToken) PrimaryKey() string {
+	return "Id"
+}
@@ -10,15 +10,23 @@ import (
 
 type User struct {
 	BaseModel
-	Username      string                  `gorm:"primaryKey;autoIncrement:false;type:varchar(100);not null;"`
-	Password      string                  `gorm:"type:varchar(100);not null;" `
-	OrgID         uuid.UUID               `gorm:"primaryKey;autoIncrement:false;type:uuid;"`
-	Roles         []Role                  `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
-	Groups        []Group                 `gorm:"many2many:user_groups;constraint:OnDelete:CASCADE;"`
-	Org           *Org                    `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
-	AccountStatus constants.AccountStatus `gorm:"type:varchar(100);not null;default:'active'"`
-	CreatedAt     *time.Time              `gorm:"not null;default:now()"`
-	UpdatedAt     *time.Time              `gorm:"not null;default:now()"`
+	Username          string                  `gorm:"primaryKey;autoIncrement:false;type:varchar(100);not null;"`
+	Password          string                  `gorm:"type:varchar(255);not null;" `
+	Email             string                  `gorm:"type:varchar(100);" validate:"omitempty,email"`
+	OrgID             uuid.UUID               `gorm:"primaryKey;autoIncrement:false;type:uuid;"`
+	Roles             []Role                  `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE;"`
+	Groups            []Group                 `gorm:"many2many:user_groups;constraint:OnDelete:CASCADE;"`
+	Org               *Org                    `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE;"`
+	AccountStatus     constants.AccountStatus `gorm:"type:varchar(100);not null;default:'active'"`
+	CreatedAt         *time.Time              `gorm:"not null;default:now()"`
+	UpdatedAt         *time.Time              `gorm:"not null;default:now()"`
+	PasswordChangedAt *time.Time
+	// Set when an admin chose the password; login only allows changing it
+	MustChangePassword bool `gorm:"not null;default:false"`
+	// DN of the directory entry the user is synced from
+	LDAPDN string `gorm:"column:ldap_dn;type:varchar(512);index"`
+	// Set by admins and tested by conditions, e.g. department or employment
+	Attributes map[string]string `gorm:"type:text;serializer:json"`
 }
 
 var validate = validator.New()
@@ -15,4 +15,14 @@ func SetupRoutes(app *fiber.App) {
 	routes.SetupRolesRoutes(api)
 	routes.SetupGroupRoutes(api)
 	routes.SetupTaskRoutes(api)
+	routes.SetupServiceAccountRoutes(api)
+	routes.SetupAPIKeyRoutes(api)
+	routes.SetupOAuthClientRoutes(api)
+	routes.SetupAuditRoutes(api)
+	routes.SetupIdentityProviderRoutes(api)
+	routes.SetupLDAPRoutes(api)
+	routes.SetupPolicyRoutes(api)
+
+	routes.SetupWellKnownRoutes(app)
+	routes.SetupOAuthRoutes(app)
 }
@@ -0,0 +1,18 @@
+package routes
+
+import (
+	apiKeyHandler "balkantask/handlers/apiKey"
+	middleware "balkantask/middlewares"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupAPIKeyRoutes(router fiber.Router) {
+
+	apiKeyRouter := router.Group("/apiKey", middleware.CheckJWT)
+
+	apiKeyRouter.Get("/", apiKeyHandler.GetAPIKeys)
+	apiKeyRouter.Post("/", middleware.DenyImpersonation, apiKeyHandler.CreateAPIKey)
+	apiKeyRouter.Put("/:id", middleware.DenyImpersonation, apiKeyHandler.UpdateAPIKey)
+	apiKeyRouter.Delete("/:id", apiKeyHandler.RevokeAPIKey)
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	auditHandler "balkantask/handlers/audit"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupAuditRoutes(router fiber.Router) {
+
+	auditRouter := router.Group("/audit", middleware.CheckJWT)
+
+	auditRouter.Get("/", middleware.RequirePermission(permissions.AuditRead), auditHandler.GetAuditLogs)
+}
@@ -14,7 +14,38 @@ func SetupAuthRoutes(router fiber.Router) {
 	userRouter.Get("/me", middleware.CheckJWT, authHandler.GetMe)
 	userRouter.Post("/login", authHandler.SignInUser)
 	userRouter.Post("/login/root", authHandler.SignInOrg)
+	userRouter.Post("/login/mfa", authHandler.VerifyMFALogin)
+	userRouter.Post("/login/mfa/enroll", authHandler.EnrollMFAChallenge)
+	userRouter.Post("/login/mfa/confirm", authHandler.ConfirmMFAChallenge)
+	userRouter.Get("/login/options", authHandler.GetLoginOptions)
+	userRouter.Get("/oidc/callback", authHandler.FederatedCallback)
+	userRouter.Get("/oidc/:id/login", authHandler.FederatedLogin)
 	userRouter.Post("/signup", authHandler.SignUpOrg)
-	userRouter.Delete("/:id", middleware.CheckJWT, authHandler.DeleteAccount)
-	userRouter.Put("/password", middleware.CheckJWT, authHandler.ChangePassword)
+	userRouter.Post("/email/verify", authHandler.VerifyEmail)
+	userRouter.Post("/email/verify/resend", authHandler.ResendVerification)
+	userRouter.Post("/refresh", authHandler.RefreshToken)
+	userRouter.Post("/password/forgot", authHandler.ForgotPassword)
+	userRouter.Post("/password/forgot/root", authHandler.ForgotPasswordRoot)
+	userRouter.Post("/password/reset", authHandler.ResetPassword)
+	userRouter.Post("/password/change", authHandler.ChangeRequiredPassword)
+	userRouter.Post("/invitation/accept", authHandler.AcceptInvitation)
+	userRouter.Get("/password/policy", middleware.CheckJWT, authHandler.GetPasswordPolicy)
+	userRouter.Put("/password/policy", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.UpdatePasswordPolicy)
+	userRouter.Delete("/password/policy", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.DeletePasswordPolicy)
+	userRouter.Post("/logout", middleware.CheckJWT, authHandler.Logout)
+	userRouter.Post("/logout/all", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.LogoutAll)
+	userRouter.Post("/mfa/enroll", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.EnrollMFA)
+	userRouter.Post("/mfa/confirm", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.ConfirmMFA)
+	userRouter.Post("/mfa/recovery-codes", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.RegenerateRecoveryCodes)
+	userRouter.Put("/mfa/require", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.SetMFARequirement)
+	userRouter.Delete("/mfa", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.DisableMFA)
+	userRouter.Delete("/mfa/:id", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.ResetMFA)
+	userRouter.Get("/sessions", middleware.CheckJWT, authHandler.GetSessions)
+	userRouter.Delete("/sessions", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.RevokeSessions)
+	userRouter.Delete("/sessions/:id", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.RevokeSession)
+	userRouter.Get("/sessions/user/:id", middleware.CheckJWT, authHandler.GetUserSessions)
+	userRouter.Delete("/sessions/user/:id", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.RevokeUserSessions)
+	userRouter.Post("/impersonate/:id", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.Impersonate)
+	userRouter.Delete("/:id", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.DeleteAccount)
+	userRouter.Put("/password", middleware.CheckJWT, middleware.DenyImpersonation, authHandler.ChangePassword)
 }
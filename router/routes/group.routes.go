@@ -3,6 +3,7 @@ package routes
 import (
 	groupHandler "balkantask/handlers/group"
 	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -10,13 +11,13 @@ import (
 func SetupGroupRoutes(router fiber.Router) {
 	groupRouter := router.Group("/group", middleware.CheckJWT)
 
-	groupRouter.Get("/", groupHandler.GetAllGroups)
-	groupRouter.Get("/:id", groupHandler.GetGroupById)
-	groupRouter.Post("/", groupHandler.CreateGroup)
+	groupRouter.Get("/", middleware.RequirePermission(permissions.GroupRead), groupHandler.GetAllGroups)
+	groupRouter.Get("/:id", middleware.RequireResourcePermission(permissions.GroupRead), groupHandler.GetGroupById)
+	groupRouter.Post("/", middleware.RequirePermission(permissions.GroupWrite), groupHandler.CreateGroup)
 	groupRouter.Post("/test", groupHandler.TestUserGroup)
-	groupRouter.Post("/excel", groupHandler.SeedGroupsFromExcel)
-	groupRouter.Post("/csv", groupHandler.SeedGroupsFromCSV)
-	groupRouter.Delete("/:id", groupHandler.DeleteGroupById)
-	groupRouter.Post("/role/add", groupHandler.AddRoleToGroup)
-	groupRouter.Delete("/role/remove", groupHandler.DeleteRoleFromGroup)
+	groupRouter.Post("/excel", middleware.RequirePermission(permissions.GroupWrite), groupHandler.SeedGroupsFromExcel)
+	groupRouter.Post("/csv", middleware.RequirePermission(permissions.GroupWrite), groupHandler.SeedGroupsFromCSV)
+	groupRouter.Delete("/:id", middleware.RequireResourcePermission(permissions.GroupWrite), groupHandler.DeleteGroupById)
+	groupRouter.Post("/role/add", middleware.RequirePermission(permissions.GroupWrite), groupHandler.AddRoleToGroup)
+	groupRouter.Delete("/role/remove", middleware.RequirePermission(permissions.GroupWrite), groupHandler.DeleteRoleFromGroup)
 }
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	identityProviderHandler "balkantask/handlers/identityProvider"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupIdentityProviderRoutes(router fiber.Router) {
+	identityProviderRouter := router.Group("/idp", middleware.CheckJWT, middleware.DenyServiceAccounts)
+
+	identityProviderRouter.Get("/", middleware.RequirePermission(permissions.OrgRead), identityProviderHandler.GetIdentityProviders)
+	identityProviderRouter.Post("/", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), identityProviderHandler.CreateIdentityProvider)
+	identityProviderRouter.Put("/password-login", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), identityProviderHandler.SetPasswordLogin)
+	identityProviderRouter.Get("/:id", middleware.RequirePermission(permissions.OrgRead), identityProviderHandler.GetIdentityProviderById)
+	identityProviderRouter.Put("/:id", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), identityProviderHandler.UpdateIdentityProvider)
+	identityProviderRouter.Delete("/:id", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), identityProviderHandler.DeleteIdentityProvider)
+}
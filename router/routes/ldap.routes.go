@@ -0,0 +1,18 @@
+package routes
+
+import (
+	ldapHandler "balkantask/handlers/ldap"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupLDAPRoutes(router fiber.Router) {
+	ldapRouter := router.Group("/ldap", middleware.CheckJWT, middleware.DenyServiceAccounts)
+
+	ldapRouter.Get("/", middleware.RequirePermission(permissions.OrgRead), ldapHandler.GetLDAPConnector)
+	ldapRouter.Put("/", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), ldapHandler.SaveLDAPConnector)
+	ldapRouter.Delete("/", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), ldapHandler.DeleteLDAPConnector)
+	ldapRouter.Post("/sync", middleware.DenyImpersonation, middleware.RequirePermission(permissions.OrgAdmin), ldapHandler.SyncLDAPDirectory)
+}
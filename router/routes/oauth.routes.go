@@ -0,0 +1,28 @@
+package routes
+
+import (
+	oauthHandler "balkantask/handlers/oauth"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupOAuthRoutes(router fiber.Router) {
+	oauthRouter := router.Group("/oauth")
+
+	oauthRouter.Get("/authorize", middleware.CheckJWT, middleware.DenyImpersonation, oauthHandler.Authorize)
+	oauthRouter.Post("/authorize", middleware.CheckJWT, middleware.DenyImpersonation, oauthHandler.Authorize)
+	oauthRouter.Post("/token", oauthHandler.Token)
+	oauthRouter.Post("/introspect", oauthHandler.Introspect)
+	oauthRouter.Post("/revoke", oauthHandler.Revoke)
+	oauthRouter.Get("/userinfo", middleware.CheckClientJWT, oauthHandler.UserInfo)
+}
+
+func SetupOAuthClientRoutes(router fiber.Router) {
+	clientRouter := router.Group("/oauth/clients", middleware.CheckJWT)
+
+	clientRouter.Get("/", middleware.RequirePermission(permissions.OrgWrite), oauthHandler.GetClients)
+	clientRouter.Post("/", middleware.RequirePermission(permissions.OrgWrite), oauthHandler.CreateClient)
+	clientRouter.Delete("/:id", middleware.RequirePermission(permissions.OrgWrite), oauthHandler.DeleteClient)
+}
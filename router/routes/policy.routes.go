@@ -0,0 +1,21 @@
+package routes
+
+import (
+	policyHandler "balkantask/handlers/policy"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupPolicyRoutes(router fiber.Router) {
+	policyRouter := router.Group("/policy", middleware.CheckJWT)
+
+	policyRouter.Get("/", middleware.RequirePermission(permissions.PolicyRead), policyHandler.GetPolicies)
+	policyRouter.Post("/", middleware.DenyImpersonation, middleware.RequirePermission(permissions.PolicyWrite), policyHandler.CreatePolicy)
+	policyRouter.Get("/:id", middleware.RequireResourcePermission(permissions.PolicyRead), policyHandler.GetPolicyById)
+	policyRouter.Put("/:id", middleware.DenyImpersonation, middleware.RequireResourcePermission(permissions.PolicyWrite), policyHandler.UpdatePolicy)
+	policyRouter.Delete("/:id", middleware.DenyImpersonation, middleware.RequireResourcePermission(permissions.PolicyWrite), policyHandler.DeletePolicy)
+	policyRouter.Post("/:id/attach", middleware.DenyImpersonation, middleware.RequireResourcePermission(permissions.PolicyWrite), policyHandler.AttachPolicy)
+	policyRouter.Delete("/:id/detach", middleware.DenyImpersonation, middleware.RequireResourcePermission(permissions.PolicyWrite), policyHandler.DetachPolicy)
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	rolesHandler "balkantask/handlers/roles"
 	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -10,10 +11,12 @@ import (
 func SetupRolesRoutes(router fiber.Router) {
 	roles := router.Group("/roles")
 
-	roles.Get("/", rolesHandler.GetAllRoles)
-	roles.Get("/:id", rolesHandler.GetRoleById)
-	roles.Post("/", middleware.CheckJWT, rolesHandler.CreateRole)
+	roles.Get("/", middleware.CheckJWT, middleware.RequirePermission(permissions.RoleRead), rolesHandler.GetAllRoles)
+	roles.Get("/permissions", rolesHandler.GetPermissions)
+	roles.Get("/:id", middleware.CheckJWT, middleware.RequirePermission(permissions.RoleRead), rolesHandler.GetRoleById)
+	roles.Post("/", middleware.CheckJWT, middleware.RequirePermission(permissions.RoleWrite), rolesHandler.CreateRole)
 	roles.Post("/test", middleware.CheckJWT, rolesHandler.TestUserRole)
 	roles.Post("/seed", middleware.CheckJWT, rolesHandler.SeedRoles)
-	roles.Delete("/:id", middleware.CheckJWT, rolesHandler.DeleteRole)
+	roles.Put("/:id", middleware.CheckJWT, middleware.RequirePermission(permissions.RoleWrite), rolesHandler.UpdateRole)
+	roles.Delete("/:id", middleware.CheckJWT, middleware.RequirePermission(permissions.RoleWrite), rolesHandler.DeleteRole)
 }
@@ -0,0 +1,26 @@
+package routes
+
+import (
+	serviceAccountHandler "balkantask/handlers/serviceAccount"
+	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupServiceAccountRoutes(router fiber.Router) {
+
+	serviceAccountRouter := router.Group("/serviceAccount", middleware.CheckJWT)
+
+	serviceAccountRouter.Get("/", middleware.RequirePermission(permissions.UserRead), serviceAccountHandler.GetServiceAccounts)
+	serviceAccountRouter.Get("/:id", middleware.RequirePermission(permissions.UserRead), serviceAccountHandler.GetServiceAccountById)
+	serviceAccountRouter.Post("/", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.CreateServiceAccount)
+	serviceAccountRouter.Delete("/:id", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.DeleteServiceAccount)
+	serviceAccountRouter.Put("/secret/:id", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.RotateServiceAccountSecret)
+	serviceAccountRouter.Post("/role/add", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.AddRoleToServiceAccount)
+	serviceAccountRouter.Delete("/role/remove", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.DeleteRoleFromServiceAccount)
+	serviceAccountRouter.Post("/group/add", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.AddGroupToServiceAccount)
+	serviceAccountRouter.Delete("/group/remove", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.DeleteGroupFromServiceAccount)
+	serviceAccountRouter.Put("/deactivate/:id", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.DeactivateServiceAccount)
+	serviceAccountRouter.Put("/reactivate/:id", middleware.RequirePermission(permissions.UserWrite), serviceAccountHandler.ReactivateServiceAccount)
+}
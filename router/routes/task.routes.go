@@ -3,6 +3,7 @@ package routes
 import (
 	taskHandler "balkantask/handlers/task"
 	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -10,13 +11,13 @@ import (
 func SetupTaskRoutes(router fiber.Router) {
 	taskRouter := router.Group("/task", middleware.CheckJWT)
 
-	taskRouter.Get("/", taskHandler.GetAllTasks)
-	taskRouter.Get("/:id", taskHandler.GetTaskById)
-	taskRouter.Post("/", taskHandler.CreateTask)
+	taskRouter.Get("/", middleware.RequirePermission(permissions.TaskRead), taskHandler.GetAllTasks)
+	taskRouter.Get("/:id", middleware.RequireResourcePermission(permissions.TaskRead), taskHandler.GetTaskById)
+	taskRouter.Post("/", middleware.RequirePermission(permissions.TaskWrite), taskHandler.CreateTask)
 	taskRouter.Post("/test", taskHandler.TestUserTask)
-	taskRouter.Post("/excel", taskHandler.SeedTasksFromExcel)
-	taskRouter.Post("/csv", taskHandler.SeedTasksFromCSV)
-	taskRouter.Delete("/:id", taskHandler.DeleteTaskById)
-	taskRouter.Post("/role/add", taskHandler.AddRoleToTask)
-	taskRouter.Delete("/role/remove", taskHandler.DeleteRoleFromTask)
+	taskRouter.Post("/excel", middleware.RequirePermission(permissions.TaskWrite), taskHandler.SeedTasksFromExcel)
+	taskRouter.Post("/csv", middleware.RequirePermission(permissions.TaskWrite), taskHandler.SeedTasksFromCSV)
+	taskRouter.Delete("/:id", middleware.RequireResourcePermission(permissions.TaskWrite), taskHandler.DeleteTaskById)
+	taskRouter.Post("/role/add", middleware.RequirePermission(permissions.TaskWrite), taskHandler.AddRoleToTask)
+	taskRouter.Delete("/role/remove", middleware.RequirePermission(permissions.TaskWrite), taskHandler.DeleteRoleFromTask)
 }
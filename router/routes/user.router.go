@@ -3,6 +3,7 @@ package routes
 import (
 	userHandler "balkantask/handlers/user"
 	middleware "balkantask/middlewares"
+	"balkantask/utils/permissions"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -11,18 +12,23 @@ func SetupUserRoutes(router fiber.Router) {
 
 	userRouter := router.Group("/user", middleware.CheckJWT)
 
-	userRouter.Get("/", userHandler.GetUsers)
+	userRouter.Get("/", middleware.RequirePermission(permissions.UserRead), userHandler.GetUsers)
+	userRouter.Get("/invitations", middleware.RequirePermission(permissions.UserRead), userHandler.GetInvitations)
+	userRouter.Post("/invitations/:id/resend", middleware.RequirePermission(permissions.UserWrite), userHandler.ResendInvitation)
+	userRouter.Delete("/invitations/:id", middleware.RequirePermission(permissions.UserWrite), userHandler.CancelInvitation)
 	userRouter.Get("/:id", userHandler.GetUserById)
-	userRouter.Post("/", userHandler.CreateUser)
-	userRouter.Post("/excel", userHandler.SeedUsersFromExcel)
-	userRouter.Post("/csv", userHandler.SeedUsersFromCSV)
+	userRouter.Post("/", middleware.RequirePermission(permissions.UserWrite), userHandler.CreateUser)
+	userRouter.Post("/excel", middleware.RequirePermission(permissions.UserWrite), userHandler.SeedUsersFromExcel)
+	userRouter.Post("/csv", middleware.RequirePermission(permissions.UserWrite), userHandler.SeedUsersFromCSV)
 	userRouter.Put("/:id", userHandler.UpdateUser)
-	userRouter.Delete("/:id", userHandler.DeleteUser)
+	userRouter.Put("/:id/attributes", middleware.DenyImpersonation, middleware.RequireResourcePermission(permissions.UserWrite), userHandler.UpdateUserAttributes)
+	userRouter.Delete("/:id", middleware.RequireResourcePermission(permissions.UserDelete), userHandler.DeleteUser)
 	userRouter.Post("/role/add", userHandler.AddRoleToUser)
 	userRouter.Delete("/role/remove", userHandler.DeleteRoleFromUser)
 	userRouter.Post("/group/add", userHandler.AddGroupToUser)
 	userRouter.Delete("/group/remove", userHandler.DeleteGroupFromUser)
-	userRouter.Put("/deactivate/:id", userHandler.DeactivateUser)
-	userRouter.Put("/reactivate/:id", userHandler.ReactivateUser)
-	userRouter.Put("/update/password", userHandler.ChangePassword)
+	userRouter.Put("/deactivate/:id", middleware.RequireResourcePermission(permissions.UserDeactivate), userHandler.DeactivateUser)
+	userRouter.Put("/reactivate/:id", middleware.RequireResourcePermission(permissions.UserDeactivate), userHandler.ReactivateUser)
+	userRouter.Put("/unlock/:id", middleware.RequireResourcePermission(permissions.UserDeactivate), userHandler.UnlockUser)
+	userRouter.Put("/update/password", middleware.DenyImpersonation, userHandler.ChangePassword)
 }
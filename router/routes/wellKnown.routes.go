@@ -0,0 +1,15 @@
+package routes
+
+import (
+	authHandler "balkantask/handlers/auth"
+	oauthHandler "balkantask/handlers/oauth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetupWellKnownRoutes(router fiber.Router) {
+	wellKnownRouter := router.Group("/.well-known")
+
+	wellKnownRouter.Get("/jwks.json", authHandler.GetJWKS)
+	wellKnownRouter.Get("/openid-configuration", oauthHandler.GetOpenIDConfiguration)
+}
@@ -0,0 +1,65 @@
+package apiKeySchema
+
+import (
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateAPIKey struct {
+	UserId    uuid.UUID   `json:"userId"`
+	Label     string      `json:"label" validate:"required,max=100"`
+	ExpiresAt *time.Time  `json:"expiresAt"`
+	RoleIds   []uuid.UUID `json:"roleIds"`
+	RoleNames []string    `json:"roleNames"`
+}
+
+type UpdateAPIKey struct {
+	Label     string     `json:"label" validate:"omitempty,max=100"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type APIKeyResponse struct {
+	ID         uuid.UUID    `json:"id"`
+	UserId     uuid.UUID    `json:"user_id"`
+	OrgId      uuid.UUID    `json:"org_id"`
+	Label      string       `json:"label"`
+	Prefix     string       `json:"prefix"`
+	Key        string       `json:"key,omitempty"`
+	Roles      []model.Role `json:"roles"`
+	ExpiresAt  *time.Time   `json:"expires_at"`
+	RevokedAt  *time.Time   `json:"revoked_at"`
+	LastUsedAt *time.Time   `json:"last_used_at"`
+	LastUsedIP string       `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+func MapAPIKeyRecord(apiKey *model.APIKey) APIKeyResponse {
+	if apiKey == nil || apiKey.ID == uuid.Nil {
+		return APIKeyResponse{
+			ID: uuid.Nil,
+		}
+	}
+
+	roles := apiKey.Roles
+	if roles == nil {
+		roles = []model.Role{}
+	}
+
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		UserId:     apiKey.UserID,
+		OrgId:      apiKey.OrgID,
+		Label:      apiKey.Label,
+		Prefix:     apiKey.Prefix,
+		Roles:      roles,
+		ExpiresAt:  apiKey.ExpiresAt,
+		RevokedAt:  apiKey.RevokedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		LastUsedIP: apiKey.LastUsedIP,
+		CreatedAt:  *apiKey.CreatedAt,
+		UpdatedAt:  *apiKey.UpdatedAt,
+	}
+}
@@ -0,0 +1,43 @@
+package auditSchema
+
+import (
+	"balkantask/model"
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditLogResponse struct {
+	ID         uuid.UUID               `json:"id"`
+	OrgId      uuid.UUID               `json:"org_id"`
+	ActorId    uuid.UUID               `json:"actor_id,omitempty"`
+	ActorType  constants.PrincipalType `json:"actor_type,omitempty"`
+	Action     string                  `json:"action"`
+	TargetId   uuid.UUID               `json:"target_id"`
+	TargetType constants.PrincipalType `json:"target_type"`
+	IP         string                  `json:"ip,omitempty"`
+	Details    string                  `json:"details,omitempty"`
+	CreatedAt  time.Time               `json:"created_at"`
+}
+
+func MapAuditLogRecord(entry *model.AuditLog) AuditLogResponse {
+	if entry == nil || entry.ID == uuid.Nil {
+		return AuditLogResponse{
+			ID: uuid.Nil,
+		}
+	}
+
+	return AuditLogResponse{
+		ID:         entry.ID,
+		OrgId:      entry.OrgID,
+		ActorId:    entry.ActorID,
+		ActorType:  entry.ActorType,
+		Action:     entry.Action,
+		TargetId:   entry.TargetID,
+		TargetType: entry.TargetType,
+		IP:         entry.IP,
+		Details:    entry.Details,
+		CreatedAt:  *entry.CreatedAt,
+	}
+}
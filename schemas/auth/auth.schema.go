@@ -0,0 +1,44 @@
+package authSchema
+
+// RefreshInput is optional for cookie sessions, the refresh token is then
+// read from its cookie.
+type RefreshInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type MFALoginInput struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type MFAEnrollInput struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+type MFACodeInput struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFARequirementInput struct {
+	Required *bool `json:"required" validate:"required"`
+}
+
+type ResetPasswordInput struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	ConfirmPassword string `json:"confirmPassword" validate:"required"`
+}
+
+type ChangeRequiredPasswordInput struct {
+	PasswordChangeToken string `json:"password_change_token" validate:"required"`
+	Password            string `json:"password" validate:"required"`
+	ConfirmPassword     string `json:"confirmPassword" validate:"required"`
+}
+
+type VerifyEmailInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ImpersonateInput struct {
+	Reason string `json:"reason" validate:"required,max=255"`
+}
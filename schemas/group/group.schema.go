@@ -7,6 +7,8 @@ type AddOrDeleteRole struct {
 	RoleName  string    `json:"roleName"`
 	GroupId   uuid.UUID `json:"groupId"`
 	GroupName string    `json:"groupName"`
+	// Only when adding: the role is held only in requests meeting them
+	Conditions map[string]map[string][]string `json:"conditions,omitempty"`
 }
 
 type CreateGroup struct {
@@ -0,0 +1,103 @@
+package identityProviderSchema
+
+import (
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateIdentityProvider struct {
+	Name          string            `json:"name" validate:"required,max=100"`
+	Issuer        string            `json:"issuer" validate:"required,url,max=255"`
+	ClientID      string            `json:"clientId" validate:"required,max=255"`
+	ClientSecret  string            `json:"clientSecret" validate:"required,max=255"`
+	Scopes        []string          `json:"scopes"`
+	UsernameClaim string            `json:"usernameClaim" validate:"max=100"`
+	EmailClaim    string            `json:"emailClaim" validate:"max=100"`
+	GroupsClaim   string            `json:"groupsClaim" validate:"max=100"`
+	GroupMappings map[string]string `json:"groupMappings"`
+	AutoProvision bool              `json:"autoProvision"`
+}
+
+// UpdateIdentityProvider only changes the fields that are set. An empty
+// clientSecret keeps the current one.
+type UpdateIdentityProvider struct {
+	Name          string            `json:"name" validate:"max=100"`
+	Issuer        string            `json:"issuer" validate:"omitempty,url,max=255"`
+	ClientID      string            `json:"clientId" validate:"max=255"`
+	ClientSecret  string            `json:"clientSecret" validate:"max=255"`
+	Scopes        []string          `json:"scopes"`
+	UsernameClaim string            `json:"usernameClaim" validate:"max=100"`
+	EmailClaim    string            `json:"emailClaim" validate:"max=100"`
+	GroupsClaim   string            `json:"groupsClaim" validate:"max=100"`
+	GroupMappings map[string]string `json:"groupMappings"`
+	AutoProvision *bool             `json:"autoProvision"`
+	Disabled      *bool             `json:"disabled"`
+}
+
+type PasswordLoginInput struct {
+	Disabled *bool `json:"disabled" validate:"required"`
+}
+
+type CallbackInput struct {
+	Code             string `query:"code"`
+	State            string `query:"state"`
+	Error            string `query:"error"`
+	ErrorDescription string `query:"error_description"`
+}
+
+type IdentityProviderResponse struct {
+	ID            uuid.UUID         `json:"id"`
+	Name          string            `json:"name"`
+	Issuer        string            `json:"issuer"`
+	ClientID      string            `json:"client_id"`
+	Scopes        []string          `json:"scopes"`
+	UsernameClaim string            `json:"username_claim"`
+	EmailClaim    string            `json:"email_claim"`
+	GroupsClaim   string            `json:"groups_claim"`
+	GroupMappings map[string]string `json:"group_mappings"`
+	AutoProvision bool              `json:"auto_provision"`
+	Disabled      bool              `json:"disabled"`
+	LoginURL      string            `json:"login_url"`
+	OrgId         uuid.UUID         `json:"org_id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// LoginOptionResponse is what a login page shows for a provider.
+type LoginOptionResponse struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	LoginURL string    `json:"login_url"`
+}
+
+// The client secret is never returned
+func MapIdentityProviderRecord(provider *model.IdentityProvider, loginURL string) IdentityProviderResponse {
+	scopes := provider.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	groupMappings := provider.GroupMappings
+	if groupMappings == nil {
+		groupMappings = map[string]string{}
+	}
+
+	return IdentityProviderResponse{
+		ID:            provider.ID,
+		Name:          provider.Name,
+		Issuer:        provider.Issuer,
+		ClientID:      provider.ClientID,
+		Scopes:        scopes,
+		UsernameClaim: provider.UsernameClaim,
+		EmailClaim:    provider.EmailClaim,
+		GroupsClaim:   provider.GroupsClaim,
+		GroupMappings: groupMappings,
+		AutoProvision: provider.AutoProvision,
+		Disabled:      provider.Disabled,
+		LoginURL:      loginURL,
+		OrgId:         provider.OrgID,
+		CreatedAt:     *provider.CreatedAt,
+		UpdatedAt:     *provider.UpdatedAt,
+	}
+}
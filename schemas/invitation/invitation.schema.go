@@ -0,0 +1,47 @@
+package invitationSchema
+
+import (
+	"balkantask/model"
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AcceptInvitationInput struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	ConfirmPassword string `json:"confirmPassword" validate:"required"`
+}
+
+type InvitationResponse struct {
+	ID            uuid.UUID               `json:"id"`
+	UserId        uuid.UUID               `json:"user_id"`
+	Username      string                  `json:"username"`
+	Email         string                  `json:"email"`
+	InvitedById   uuid.UUID               `json:"invited_by_id,omitempty"`
+	InvitedByType constants.PrincipalType `json:"invited_by_type,omitempty"`
+	SentAt        time.Time               `json:"sent_at"`
+	ExpiresAt     time.Time               `json:"expires_at"`
+	Expired       bool                    `json:"expired"`
+}
+
+func MapInvitationRecord(invitation *model.Invitation, username string, email string) InvitationResponse {
+	if invitation == nil || invitation.ID == uuid.Nil {
+		return InvitationResponse{
+			ID: uuid.Nil,
+		}
+	}
+
+	return InvitationResponse{
+		ID:            invitation.ID,
+		UserId:        invitation.UserID,
+		Username:      username,
+		Email:         email,
+		InvitedById:   invitation.InvitedByID,
+		InvitedByType: invitation.InvitedByType,
+		SentAt:        invitation.SentAt,
+		ExpiresAt:     invitation.ExpiresAt,
+		Expired:       invitation.ExpiresAt.Before(time.Now()),
+	}
+}
@@ -0,0 +1,82 @@
+package ldapSchema
+
+import (
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SaveLDAPConnector replaces the connector of the org. An empty bindPassword
+// keeps the current one.
+type SaveLDAPConnector struct {
+	URL                  string            `json:"url" validate:"required,url,max=255"`
+	StartTLS             bool              `json:"startTls"`
+	BindDN               string            `json:"bindDn" validate:"required,max=512"`
+	BindPassword         string            `json:"bindPassword" validate:"max=255"`
+	BaseDN               string            `json:"baseDn" validate:"required,max=512"`
+	UserFilter           string            `json:"userFilter" validate:"max=512"`
+	GroupBaseDN          string            `json:"groupBaseDn" validate:"max=512"`
+	GroupFilter          string            `json:"groupFilter" validate:"max=512"`
+	UsernameAttribute    string            `json:"usernameAttribute" validate:"max=100"`
+	EmailAttribute       string            `json:"emailAttribute" validate:"max=100"`
+	GroupNameAttribute   string            `json:"groupNameAttribute" validate:"max=100"`
+	GroupMemberAttribute string            `json:"groupMemberAttribute" validate:"max=100"`
+	GroupMappings        map[string]string `json:"groupMappings"`
+	BindAuth             bool              `json:"bindAuth"`
+	SyncDisabled         bool              `json:"syncDisabled"`
+}
+
+type LDAPConnectorResponse struct {
+	ID                   uuid.UUID         `json:"id"`
+	URL                  string            `json:"url"`
+	StartTLS             bool              `json:"start_tls"`
+	BindDN               string            `json:"bind_dn"`
+	BaseDN               string            `json:"base_dn"`
+	UserFilter           string            `json:"user_filter"`
+	GroupBaseDN          string            `json:"group_base_dn"`
+	GroupFilter          string            `json:"group_filter"`
+	UsernameAttribute    string            `json:"username_attribute"`
+	EmailAttribute       string            `json:"email_attribute"`
+	GroupNameAttribute   string            `json:"group_name_attribute"`
+	GroupMemberAttribute string            `json:"group_member_attribute"`
+	GroupMappings        map[string]string `json:"group_mappings"`
+	BindAuth             bool              `json:"bind_auth"`
+	SyncDisabled         bool              `json:"sync_disabled"`
+	LastSyncAt           *time.Time        `json:"last_sync_at"`
+	LastSyncError        string            `json:"last_sync_error,omitempty"`
+	OrgId                uuid.UUID         `json:"org_id"`
+	CreatedAt            time.Time         `json:"created_at"`
+	UpdatedAt            time.Time         `json:"updated_at"`
+}
+
+// The bind password is never returned
+func MapLDAPConnectorRecord(connector *model.LDAPConnector) LDAPConnectorResponse {
+	groupMappings := connector.GroupMappings
+	if groupMappings == nil {
+		groupMappings = map[string]string{}
+	}
+
+	return LDAPConnectorResponse{
+		ID:                   connector.ID,
+		URL:                  connector.URL,
+		StartTLS:             connector.StartTLS,
+		BindDN:               connector.BindDN,
+		BaseDN:               connector.BaseDN,
+		UserFilter:           connector.UserFilter,
+		GroupBaseDN:          connector.GroupBaseDN,
+		GroupFilter:          connector.GroupFilter,
+		UsernameAttribute:    connector.UsernameAttribute,
+		EmailAttribute:       connector.EmailAttribute,
+		GroupNameAttribute:   connector.GroupNameAttribute,
+		GroupMemberAttribute: connector.GroupMemberAttribute,
+		GroupMappings:        groupMappings,
+		BindAuth:             connector.BindAuth,
+		SyncDisabled:         connector.SyncDisabled,
+		LastSyncAt:           connector.LastSyncAt,
+		LastSyncError:        connector.LastSyncError,
+		OrgId:                connector.OrgID,
+		CreatedAt:            *connector.CreatedAt,
+		UpdatedAt:            *connector.UpdatedAt,
+	}
+}
@@ -0,0 +1,71 @@
+package oauthSchema
+
+import (
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateClient struct {
+	Name         string   `json:"name" validate:"required"`
+	RedirectURIs []string `json:"redirectUris" validate:"required,min=1,dive,url"`
+	Public       bool     `json:"public"`
+}
+
+type ClientResponse struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Public       bool      `json:"public"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	OrgId        uuid.UUID `json:"org_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizeInput carries the authorization request, either as query
+// parameters or, when posting consent, in the body.
+type AuthorizeInput struct {
+	ResponseType        string `json:"response_type" query:"response_type" form:"response_type"`
+	ClientID            string `json:"client_id" query:"client_id" form:"client_id"`
+	RedirectURI         string `json:"redirect_uri" query:"redirect_uri" form:"redirect_uri"`
+	Scope               string `json:"scope" query:"scope" form:"scope"`
+	State               string `json:"state" query:"state" form:"state"`
+	Nonce               string `json:"nonce" query:"nonce" form:"nonce"`
+	CodeChallenge       string `json:"code_challenge" query:"code_challenge" form:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" query:"code_challenge_method" form:"code_challenge_method"`
+	Approve             bool   `json:"approve" form:"approve"`
+}
+
+type TokenInput struct {
+	GrantType    string `json:"grant_type" form:"grant_type"`
+	Code         string `json:"code" form:"code"`
+	RedirectURI  string `json:"redirect_uri" form:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	ClientID     string `json:"client_id" form:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret"`
+	Scope        string `json:"scope" form:"scope"`
+}
+
+// TokenHintInput is the body of /oauth/introspect and /oauth/revoke. The
+// token_type_hint is access_token or refresh_token.
+type TokenHintInput struct {
+	Token         string `json:"token" form:"token"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint"`
+	ClientID      string `json:"client_id" form:"client_id"`
+	ClientSecret  string `json:"client_secret" form:"client_secret"`
+}
+
+func MapClientRecord(client *model.OAuthClient) ClientResponse {
+	return ClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		ClientID:     client.ClientID,
+		Public:       client.SecretHash == "",
+		RedirectURIs: client.RedirectURIs,
+		OrgId:        client.OrgID,
+		CreatedAt:    *client.CreatedAt,
+	}
+}
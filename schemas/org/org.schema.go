@@ -3,28 +3,38 @@ package orgSchema
 import (
 	"balkantask/model"
 	constants "balkantask/utils"
-	"regexp"
+	"balkantask/utils/password"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type OrgResponse struct {
-	ID            uuid.UUID               `json:"id,omitempty"`
-	Username      string                  `json:"username,omitempty"`
-	Email         string                  `json:"email,omitempty"`
-	AccountStatus constants.AccountStatus `json:"account_status,omitempty"`
-	CreatedAt     time.Time               `json:"created_at"`
-	UpdatedAt     time.Time               `json:"updated_at"`
+	ID                   uuid.UUID               `json:"id,omitempty"`
+	Username             string                  `json:"username,omitempty"`
+	Email                string                  `json:"email,omitempty"`
+	AccountStatus        constants.AccountStatus `json:"account_status,omitempty"`
+	RequireMFA           bool                    `json:"require_mfa"`
+	DisablePasswordLogin bool                    `json:"disable_password_login"`
+	CreatedAt            time.Time               `json:"created_at"`
+	UpdatedAt            time.Time               `json:"updated_at"`
 }
 type SignInInput struct {
 	Email    string `json:"email"  validate:"required"`
 	Password string `json:"password"  validate:"required"`
 }
 
+type ForgotPasswordInput struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResendVerificationInput struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
 type SignupInput struct {
 	Username        string `json:"username" validate:"required"`
-	Email           string `json:"email" validate:"required"`
+	Email           string `json:"email" validate:"required,email"`
 	Password        string `json:"password" validate:"required,min=8"`
 	ConfirmPassword string `json:"confirmPassword" validate:"required,min=8"`
 }
@@ -37,32 +47,18 @@ type UpdatePassword struct {
 
 func MapOrgRecord(user *model.Org) OrgResponse {
 	return OrgResponse{
-		ID:            user.ID,
-		Username:      user.Username,
-		Email:         user.Email,
-		CreatedAt:     *user.CreatedAt,
-		UpdatedAt:     *user.UpdatedAt,
-		AccountStatus: user.AccountStatus,
+		ID:                   user.ID,
+		Username:             user.Username,
+		Email:                user.Email,
+		CreatedAt:            *user.CreatedAt,
+		UpdatedAt:            *user.UpdatedAt,
+		AccountStatus:        user.AccountStatus,
+		RequireMFA:           user.RequireMFA,
+		DisablePasswordLogin: user.DisablePasswordLogin,
 	}
 }
 
 // ValidatePassword checks if the password meets complexity requirements.
 func (s *SignupInput) ValidatePassword() bool {
-	if len(s.Password) < 8 {
-		return false
-	}
-
-	// Check if the password contains at least one uppercase letter
-	hasUppercase := regexp.MustCompile(`[A-Z]`).MatchString(s.Password)
-
-	// Check if the password contains at least one lowercase letter
-	hasLowercase := regexp.MustCompile(`[a-z]`).MatchString(s.Password)
-
-	// Check if the password contains at least one digit
-	hasDigit := regexp.MustCompile(`[0-9]`).MatchString(s.Password)
-
-	// Check if the password contains at least one special character
-	hasSpecialChar := regexp.MustCompile(`[@$!%*#?&]`).MatchString(s.Password)
-
-	return hasUppercase && hasLowercase && hasDigit && hasSpecialChar
+	return password.Validate(s.Password)
 }
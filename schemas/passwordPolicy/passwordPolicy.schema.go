@@ -0,0 +1,54 @@
+package passwordPolicySchema
+
+import (
+	"balkantask/model"
+	"balkantask/utils/password"
+
+	"github.com/google/uuid"
+)
+
+type UpdatePasswordPolicy struct {
+	MinLength        int      `json:"minLength" validate:"required,min=8,max=128"`
+	RequireUppercase bool     `json:"requireUppercase"`
+	RequireLowercase bool     `json:"requireLowercase"`
+	RequireDigit     bool     `json:"requireDigit"`
+	RequireSpecial   bool     `json:"requireSpecial"`
+	BannedWords      []string `json:"bannedWords" validate:"max=500,dive,min=3,max=64"`
+	HistoryDepth     int      `json:"historyDepth" validate:"min=0,max=24"`
+	MaxAgeDays       int      `json:"maxAgeDays" validate:"min=0,max=3650"`
+}
+
+type PasswordPolicyResponse struct {
+	OrgId            uuid.UUID `json:"org_id"`
+	MinLength        int       `json:"min_length"`
+	RequireUppercase bool      `json:"require_uppercase"`
+	RequireLowercase bool      `json:"require_lowercase"`
+	RequireDigit     bool      `json:"require_digit"`
+	RequireSpecial   bool      `json:"require_special"`
+	BannedWords      []string  `json:"banned_words"`
+	HistoryDepth     int       `json:"history_depth"`
+	MaxAgeDays       int       `json:"max_age_days"`
+	Requirements     string    `json:"requirements"`
+	IsDefault        bool      `json:"is_default"`
+}
+
+func MapPasswordPolicyRecord(policy *model.PasswordPolicy) PasswordPolicyResponse {
+	bannedWords := policy.BannedWords
+	if bannedWords == nil {
+		bannedWords = []string{}
+	}
+
+	return PasswordPolicyResponse{
+		OrgId:            policy.OrgID,
+		MinLength:        policy.MinLength,
+		RequireUppercase: policy.RequireUppercase,
+		RequireLowercase: policy.RequireLowercase,
+		RequireDigit:     policy.RequireDigit,
+		RequireSpecial:   policy.RequireSpecial,
+		BannedWords:      bannedWords,
+		HistoryDepth:     policy.HistoryDepth,
+		MaxAgeDays:       policy.MaxAgeDays,
+		Requirements:     password.Describe(*policy),
+		IsDefault:        policy.ID == uuid.Nil,
+	}
+}
@@ -0,0 +1,68 @@
+package policySchema
+
+import (
+	"balkantask/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreatePolicy struct {
+	Name        string               `json:"name" validate:"required,max=100"`
+	Description string               `json:"description" validate:"max=255"`
+	Document    model.PolicyDocument `json:"document"`
+}
+
+// UpdatePolicy only changes the fields that are set. A document replaces the
+// current one.
+type UpdatePolicy struct {
+	Name        string                `json:"name" validate:"max=100"`
+	Description *string               `json:"description" validate:"omitempty,max=255"`
+	Document    *model.PolicyDocument `json:"document"`
+}
+
+type AttachPolicy struct {
+	PrincipalType string    `json:"principalType" validate:"required,oneof=user group role"`
+	PrincipalId   uuid.UUID `json:"principalId" validate:"required"`
+}
+
+type PolicyResponse struct {
+	ID          uuid.UUID            `json:"id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Document    model.PolicyDocument `json:"document"`
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+	OrgId       uuid.UUID            `json:"org_id"`
+	CreatedAt   time.Time            `json:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+}
+
+type AttachmentResponse struct {
+	PrincipalType string    `json:"principal_type"`
+	PrincipalId   uuid.UUID `json:"principal_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func MapPolicyRecord(policy *model.Policy) PolicyResponse {
+	return PolicyResponse{
+		ID:          policy.ID,
+		Name:        policy.Name,
+		Description: policy.Description,
+		Document:    policy.Document,
+		OrgId:       policy.OrgID,
+		CreatedAt:   *policy.CreatedAt,
+		UpdatedAt:   *policy.UpdatedAt,
+	}
+}
+
+func MapAttachmentRecords(attachments []model.PolicyAttachment) []AttachmentResponse {
+	response := []AttachmentResponse{}
+	for _, attachment := range attachments {
+		response = append(response, AttachmentResponse{
+			PrincipalType: attachment.PrincipalType,
+			PrincipalId:   attachment.PrincipalID,
+			CreatedAt:     *attachment.CreatedAt,
+		})
+	}
+	return response
+}
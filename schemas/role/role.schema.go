@@ -1,13 +1,45 @@
 package roleSchema
 
-import "github.com/google/uuid"
+import (
+	"balkantask/model"
 
-type AddOrDeleteRole struct {
-	RoleName string `json:"roleName" validate:"required"`
-	Type     string `json:"type" validate:"required"`
+	"github.com/google/uuid"
+)
+
+// CreateRole needs permissions, included roles or both. Includes are role
+// names, e.g. USER_READ_ACCESS.
+type CreateRole struct {
+	RoleName    string   `json:"roleName" validate:"required"`
+	Permissions []string `json:"permissions"`
+	Includes    []string `json:"includes"`
+}
+
+// UpdateRole changes the fields that are set. Permissions and includes
+// replace the ones of the role.
+type UpdateRole struct {
+	RoleName    string   `json:"roleName,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	Includes    []string `json:"includes,omitempty"`
+}
+
+// RoleResponse is a role with what it really grants: InheritedRoles are all
+// the roles it includes, directly or not, and EffectivePermissions what they
+// grant together with the role's own Permissions.
+type RoleResponse struct {
+	model.Role
+	InheritedRoles       []string `json:"InheritedRoles"`
+	EffectivePermissions []string `json:"EffectivePermissions"`
 }
 
 type TestRole struct {
 	RoleName string    `json:"roleName"`
 	RoleId   uuid.UUID `json:"roleId"`
 }
+
+// PermissionResponse is an entry of the permission catalogue together with
+// the system roles granting it.
+type PermissionResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Roles       []string `json:"roles"`
+}
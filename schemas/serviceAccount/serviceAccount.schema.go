@@ -0,0 +1,60 @@
+package serviceAccountSchema
+
+import (
+	"balkantask/model"
+	constants "balkantask/utils"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateServiceAccount struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type ServiceAccountResponse struct {
+	ID            uuid.UUID               `json:"id,omitempty"`
+	Name          string                  `json:"name,omitempty"`
+	ClientID      string                  `json:"client_id,omitempty"`
+	ClientSecret  string                  `json:"client_secret,omitempty"`
+	CreatedAt     time.Time               `json:"created_at"`
+	UpdatedAt     time.Time               `json:"updated_at"`
+	Roles         []model.Role            `json:"roles"`
+	Groups        []model.Group           `json:"groups"`
+	OrgId         uuid.UUID               `json:"org_id,omitempty"`
+	AccountStatus constants.AccountStatus `json:"account_status,omitempty"`
+}
+
+type AddOrDeleteRole struct {
+	RoleId           uuid.UUID `json:"roleId"`
+	RoleName         string    `json:"roleName"`
+	ServiceAccountId uuid.UUID `json:"serviceAccountId" validate:"required"`
+	// Only when adding: the role is held only in requests meeting them
+	Conditions map[string]map[string][]string `json:"conditions,omitempty"`
+}
+
+type AddOrDeleteGroup struct {
+	GroupId          uuid.UUID `json:"groupId"`
+	GroupName        string    `json:"groupName"`
+	ServiceAccountId uuid.UUID `json:"serviceAccountId" validate:"required"`
+}
+
+func MapServiceAccountRecord(serviceAccount *model.ServiceAccount) ServiceAccountResponse {
+	if serviceAccount == nil || serviceAccount.ID == uuid.Nil {
+		return ServiceAccountResponse{
+			ID: uuid.Nil,
+		}
+	}
+
+	return ServiceAccountResponse{
+		ID:            serviceAccount.ID,
+		Name:          serviceAccount.Name,
+		ClientID:      serviceAccount.ClientID,
+		CreatedAt:     *serviceAccount.CreatedAt,
+		UpdatedAt:     *serviceAccount.UpdatedAt,
+		Roles:         serviceAccount.Roles,
+		Groups:        serviceAccount.Groups,
+		OrgId:         serviceAccount.OrgID,
+		AccountStatus: serviceAccount.AccountStatus,
+	}
+}
@@ -0,0 +1,54 @@
+package sessionSchema
+
+import (
+	"balkantask/model"
+	constants "balkantask/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SessionResponse struct {
+	ID          uuid.UUID               `json:"id"`
+	SubjectId   uuid.UUID               `json:"subject_id"`
+	SubjectType constants.PrincipalType `json:"subject_type"`
+	ActorId     *uuid.UUID              `json:"actor_id,omitempty"`
+	ClientId    string                  `json:"client_id,omitempty"`
+	UserAgent   string                  `json:"user_agent"`
+	IP          string                  `json:"ip"`
+	AuthMethods []string                `json:"auth_methods"`
+	Current     bool                    `json:"current"`
+	CreatedAt   time.Time               `json:"created_at"`
+	LastSeenAt  time.Time               `json:"last_seen_at"`
+	ExpiresAt   time.Time               `json:"expires_at"`
+}
+
+func MapSessionRecord(session *model.Session) SessionResponse {
+	if session == nil || session.ID == uuid.Nil {
+		return SessionResponse{
+			ID: uuid.Nil,
+		}
+	}
+
+	response := SessionResponse{
+		ID:          session.ID,
+		SubjectId:   session.SubjectID,
+		SubjectType: session.SubjectType,
+		ClientId:    session.ClientID,
+		UserAgent:   session.UserAgent,
+		IP:          session.IP,
+		AuthMethods: strings.Fields(session.AuthMethods),
+		CreatedAt:   *session.CreatedAt,
+		LastSeenAt:  session.LastSeenAt,
+		ExpiresAt:   session.ExpiresAt,
+	}
+
+	// Set for sessions started by an impersonating admin
+	if session.ActorID != uuid.Nil {
+		actorId := session.ActorID
+		response.ActorId = &actorId
+	}
+
+	return response
+}
@@ -11,24 +11,32 @@ import (
 
 type CreateUser struct {
 	Username        string `json:"username" validate:"required"`
+	Email           string `json:"email,omitempty"`
 	Password        string `json:"password,omitempty" validate:"omitempty,min=8"`
 	ConfirmPassword string `json:"confirmPassword,omitempty" validate:"omitempty,min=8"`
+	// Invite emails the user a link to choose their own password
+	Invite bool `json:"invite,omitempty"`
 }
 
 type UserResponse struct {
-	ID            uuid.UUID               `json:"id,omitempty"`
-	Username      string                  `json:"username,omitempty"`
-	CreatedAt     time.Time               `json:"created_at"`
-	UpdatedAt     time.Time               `json:"updated_at"`
-	Roles         []model.Role            `json:"roles"`
-	Groups        []model.Group           `json:"groups"`
-	OrgId         uuid.UUID               `json:"org_id,omitempty"`
-	AccountStatus constants.AccountStatus `json:"account_status,omitempty"`
+	ID                 uuid.UUID               `json:"id,omitempty"`
+	Username           string                  `json:"username,omitempty"`
+	Email              string                  `json:"email,omitempty"`
+	CreatedAt          time.Time               `json:"created_at"`
+	UpdatedAt          time.Time               `json:"updated_at"`
+	Roles              []model.Role            `json:"roles"`
+	Groups             []model.Group           `json:"groups"`
+	OrgId              uuid.UUID               `json:"org_id,omitempty"`
+	AccountStatus      constants.AccountStatus `json:"account_status,omitempty"`
+	MustChangePassword bool                    `json:"must_change_password,omitempty"`
+	LDAPDN             string                  `json:"ldap_dn,omitempty"`
+	Attributes         map[string]string       `json:"attributes,omitempty"`
 }
 
 type UserResponseWithOrg struct {
 	ID            uuid.UUID               `json:"id,omitempty"`
 	Username      string                  `json:"username,omitempty"`
+	Email         string                  `json:"email,omitempty"`
 	CreatedAt     time.Time               `json:"created_at"`
 	UpdatedAt     time.Time               `json:"updated_at"`
 	Roles         []model.Role            `json:"roles"`
@@ -41,6 +49,7 @@ type UserResponseWithOrg struct {
 type CreateUserResponse struct {
 	ID            uuid.UUID               `json:"id,omitempty"`
 	Username      string                  `json:"username,omitempty"`
+	Email         string                  `json:"email,omitempty"`
 	CreatedAt     time.Time               `json:"created_at"`
 	UpdatedAt     time.Time               `json:"updated_at"`
 	Roles         []model.Role            `json:"roles"`
@@ -54,6 +63,13 @@ type AddOrDeleteRole struct {
 	RoleId   uuid.UUID `json:"roleId" `
 	RoleName string    `json:"roleName" `
 	UserId   uuid.UUID `json:"userId" validate:"required"`
+	// Only when adding: the role is held only in requests meeting them
+	Conditions map[string]map[string][]string `json:"conditions,omitempty"`
+}
+
+// UpdateAttributes replaces the attributes of a user.
+type UpdateAttributes struct {
+	Attributes map[string]string `json:"attributes" validate:"required,dive,keys,required,max=100,endkeys,max=255"`
 }
 
 type AddOrDeleteGroup struct {
@@ -78,14 +94,18 @@ func MapUserRecord(user *model.User) UserResponse {
 	}
 
 	return UserResponse{
-		ID:            user.ID,
-		Username:      user.Username,
-		CreatedAt:     *user.CreatedAt,
-		UpdatedAt:     *user.UpdatedAt,
-		Roles:         user.Roles,
-		Groups:        user.Groups,
-		OrgId:         user.OrgID,
-		AccountStatus: user.AccountStatus,
+		ID:                 user.ID,
+		Username:           user.Username,
+		Email:              user.Email,
+		CreatedAt:          *user.CreatedAt,
+		UpdatedAt:          *user.UpdatedAt,
+		Roles:              user.Roles,
+		Groups:             user.Groups,
+		OrgId:              user.OrgID,
+		AccountStatus:      user.AccountStatus,
+		MustChangePassword: user.MustChangePassword,
+		LDAPDN:             user.LDAPDN,
+		Attributes:         user.Attributes,
 	}
 }
 
@@ -93,6 +113,7 @@ func MapUserRecordWithOrg(user *model.User) UserResponseWithOrg {
 	return UserResponseWithOrg{
 		ID:            user.ID,
 		Username:      user.Username,
+		Email:         user.Email,
 		CreatedAt:     *user.CreatedAt,
 		UpdatedAt:     *user.UpdatedAt,
 		Roles:         user.Roles,
@@ -102,6 +123,11 @@ func MapUserRecordWithOrg(user *model.User) UserResponseWithOrg {
 	}
 }
 
+type ForgotPasswordInput struct {
+	Username  string `json:"username" validate:"required"`
+	AccountId string `json:"accountId" validate:"required"`
+}
+
 type SignInInput struct {
 	Username  string `json:"username"  validate:"required"`
 	Password  string `json:"password"  validate:"required"`
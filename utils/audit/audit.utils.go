@@ -0,0 +1,24 @@
+package audit
+
+import (
+	auditRepo "balkantask/database/audit"
+	"balkantask/model"
+	"fmt"
+)
+
+const (
+	AccountLocked        = "account.locked"
+	AccountUnlocked      = "account.unlocked"
+	ImpersonationStarted = "impersonation.started"
+	ImpersonatedRequest  = "impersonation.request"
+	FederatedLinked      = "federation.linked"
+	FederatedProvisioned = "federation.provisioned"
+)
+
+// Record writes an audit entry. A failure to write is logged but never fails
+// the request that caused the event.
+func Record(entry model.AuditLog) {
+	if _, err := auditRepo.CreateAuditLog(entry); err != nil {
+		fmt.Println("Error writing audit log:", err)
+	}
+}
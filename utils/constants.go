@@ -6,4 +6,18 @@ const (
 	ACTIVATED   AccountStatus = "ACTIVATED"
 	DEACTIVATED AccountStatus = "DEACTIVATED"
 	DELETED     AccountStatus = "DELETED"
+	LOCKED      AccountStatus = "LOCKED"
+
+	// An org whose email address has not been verified yet
+	PENDING_VERIFICATION AccountStatus = "PENDING_VERIFICATION"
+	// A user that has not accepted its invitation yet
+	INVITED AccountStatus = "INVITED"
+)
+
+type PrincipalType string
+
+const (
+	USER            PrincipalType = "user"
+	ORG             PrincipalType = "org"
+	SERVICE_ACCOUNT PrincipalType = "serviceAccount"
 )
@@ -0,0 +1,57 @@
+package groupMapping
+
+import (
+	groupRepo "balkantask/database/group"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Sync gives the user the groups mapped from the external groups it is a
+// member of, and takes away mapped groups it no longer gets. Groups that no
+// mapping names are left alone. It returns how many memberships changed.
+func Sync(user model.User, mappings map[string]string, externalGroups []string) (int, error) {
+	isMember := map[string]bool{}
+	for _, group := range externalGroups {
+		isMember[group] = true
+	}
+
+	wanted := map[string]bool{}
+	for external, group := range mappings {
+		wanted[group] = wanted[group] || isMember[external]
+	}
+
+	current := map[string]bool{}
+	for _, group := range user.Groups {
+		current[group.Name] = true
+	}
+
+	changed := 0
+	for name, keep := range wanted {
+		if keep == current[name] {
+			continue
+		}
+
+		group, err := groupRepo.GetGroupByName(name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+
+		if keep {
+			_, err = userRepo.AddGroupToUser(group, user)
+		} else {
+			_, err = userRepo.DeleteGroupFromUser(group, user)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,166 @@
+package hashing
+
+import (
+	"balkantask/config"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hashes are stored in the PHC string format, so every hash names its own
+// algorithm and parameters:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//	$2a$10$<salt and hash>            (bcrypt)
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	saltLength = 16
+	keyLength  = 32
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+var phcEncoding = base64.RawStdEncoding
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// Algorithm is the algorithm new hashes are created with, set by
+// PASSWORD_HASH_ALGORITHM.
+func Algorithm() string {
+	if strings.EqualFold(os.Getenv("PASSWORD_HASH_ALGORITHM"), Bcrypt) {
+		return Bcrypt
+	}
+	return Argon2id
+}
+
+func currentArgon2Params() argon2Params {
+	parallelism := config.Int("ARGON2_PARALLELISM", 4)
+	if parallelism > 255 {
+		parallelism = 255
+	}
+
+	return argon2Params{
+		memory:      uint32(config.Int("ARGON2_MEMORY", 64*1024)),
+		iterations:  uint32(config.Int("ARGON2_ITERATIONS", 3)),
+		parallelism: uint8(parallelism),
+	}
+}
+
+func bcryptCost() int {
+	cost := config.Int("BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
+
+// Hash hashes a password with the configured algorithm and parameters.
+func Hash(password string) (string, error) {
+	if Algorithm() == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost())
+		return string(hash), err
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	params := currentArgon2Params()
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, keyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, params.memory, params.iterations, params.parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the hash, whichever supported
+// algorithm created it.
+func Verify(password string, encoded string) bool {
+	if isBcrypt(encoded) {
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+// NeedsRehash reports whether a hash was made with another algorithm or
+// other parameters than the configured ones. It should be replaced the next
+// time the password is known, i.e. after a successful login.
+func NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		if Algorithm() != Bcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != bcryptCost()
+	}
+
+	params, _, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+
+	return Algorithm() != Argon2id || params != currentArgon2Params() || len(key) != keyLength
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if parts[1] != Argon2id {
+		return params, nil, nil, ErrUnknownAlgorithm
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+	if params.memory == 0 || params.iterations == 0 || params.parallelism == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	salt, err := phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	key, err := phcEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedHash
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,78 @@
+package invitation
+
+import (
+	"balkantask/config"
+	invitationRepo "balkantask/database/invitation"
+	"balkantask/model"
+	constants "balkantask/utils"
+	"balkantask/utils/notifier"
+	"balkantask/utils/tokens"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoEmail = errors.New("user has no email address")
+
+func TTL() time.Duration {
+	return config.Duration("INVITATION_TTL", 7*24*time.Hour)
+}
+
+// Send (re)invites a user: a new token replaces any earlier one, so only the
+// latest link works, and the link is mailed in the background.
+func Send(user model.User, invitedBy uuid.UUID, invitedByType constants.PrincipalType) (model.Invitation, error) {
+	if user.Email == "" {
+		return model.Invitation{}, ErrNoEmail
+	}
+
+	token, tokenHash, err := tokens.GenerateOpaqueToken()
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	now := time.Now()
+	invitation, err := invitationRepo.UpsertInvitation(model.Invitation{
+		UserID:        user.ID,
+		OrgID:         user.OrgID,
+		TokenHash:     tokenHash,
+		InvitedByID:   invitedBy,
+		InvitedByType: invitedByType,
+		SentAt:        now,
+		ExpiresAt:     now.Add(TTL()),
+	})
+	if err != nil {
+		return model.Invitation{}, err
+	}
+
+	go notify(user, token)
+
+	return invitation, nil
+}
+
+func notify(user model.User, token string) {
+	link := acceptURL() + "?token=" + url.QueryEscape(token)
+
+	err := notifier.Send(notifier.Message{
+		To:      user.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("An account with the username %s has been created for you. Open the link below within %s to choose your password:\n\n%s\n\nIf you did not expect this, you can ignore this message.",
+			user.Username, TTL(), link),
+	})
+	if err != nil {
+		fmt.Println("Error sending invitation:", err)
+	}
+}
+
+// acceptURL is the page that reads the token from the link and posts it to
+// /api/auth/invitation/accept together with the chosen password.
+func acceptURL() string {
+	acceptURL := os.Getenv("INVITATION_URL")
+	if acceptURL == "" {
+		return tokens.Issuer() + "/accept-invitation"
+	}
+	return acceptURL
+}
@@ -0,0 +1,368 @@
+package ldapSync
+
+import (
+	ldapRepo "balkantask/database/ldap"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	constants "balkantask/utils"
+	"balkantask/utils/groupMapping"
+	"balkantask/utils/tokens"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	timeout  = 10 * time.Second
+	pageSize = 500
+)
+
+var (
+	ErrSyncRunning = errors.New("a sync of this directory is already running")
+	// Most likely a wrong base DN or filter; deactivating everyone would be worse
+	ErrNoEntries = errors.New("the directory returned no users, nothing was changed")
+)
+
+// Result counts what a sync changed. Skipped lists the entries that could not
+// be synced and why.
+type Result struct {
+	Created      int      `json:"created"`
+	Updated      int      `json:"updated"`
+	Deactivated  int      `json:"deactivated"`
+	GroupChanges int      `json:"group_changes"`
+	Skipped      []string `json:"skipped"`
+}
+
+type directoryEntry struct {
+	DN       string
+	Username string
+	Email    string
+}
+
+// One sync per org at a time, whether started by the scheduler or an admin
+var (
+	runningMutex sync.Mutex
+	running      = map[uuid.UUID]bool{}
+)
+
+// Dial connects to the directory of the connector, upgrading the connection
+// with StartTLS when it is configured.
+func Dial(connector model.LDAPConnector) (*ldap.Conn, error) {
+	parsed, err := url.Parse(connector.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{ServerName: parsed.Hostname(), MinVersion: tls.VersionTLS12}
+	conn, err := ldap.DialURL(connector.URL, ldap.DialWithTLSDialer(tlsConfig, &net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if connector.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// CheckConnection binds with the service account of the connector.
+func CheckConnection(connector model.LDAPConnector) error {
+	conn, err := Dial(connector)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Bind(connector.BindDN, connector.BindPassword)
+}
+
+// Authenticate checks a password by binding as the user's entry. Wrong
+// credentials are not an error; an unreachable directory is.
+func Authenticate(connector model.LDAPConnector, dn string, password string) (bool, error) {
+	// An empty password makes an unauthenticated bind, which servers accept
+	if dn == "" || password == "" {
+		return false, nil
+	}
+
+	conn, err := Dial(connector)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	err = conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Run syncs the users of the org with its directory and records the outcome
+// on the connector.
+func Run(connector model.LDAPConnector) (Result, error) {
+	runningMutex.Lock()
+	if running[connector.OrgID] {
+		runningMutex.Unlock()
+		return Result{}, ErrSyncRunning
+	}
+	running[connector.OrgID] = true
+	runningMutex.Unlock()
+
+	defer func() {
+		runningMutex.Lock()
+		delete(running, connector.OrgID)
+		runningMutex.Unlock()
+	}()
+
+	result, err := syncDirectory(connector)
+
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	if recordErr := ldapRepo.RecordLDAPSync(connector.ID, time.Now(), message); recordErr != nil {
+		fmt.Println("Error recording LDAP sync:", recordErr)
+	}
+
+	return result, err
+}
+
+// syncDirectory creates users for new entries, adopts existing users with
+// the same username, updates emails, deactivates users whose entry is gone
+// and mirrors the mapped group memberships.
+func syncDirectory(connector model.LDAPConnector) (Result, error) {
+	result := Result{Skipped: []string{}}
+
+	conn, err := Dial(connector)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(connector.BindDN, connector.BindPassword); err != nil {
+		return result, err
+	}
+
+	entries, err := searchUsers(conn, connector)
+	if err != nil {
+		return result, err
+	}
+	if len(entries) == 0 {
+		return result, ErrNoEntries
+	}
+
+	memberships, err := searchMemberships(conn, connector)
+	if err != nil {
+		return result, err
+	}
+
+	syncedUsers, err := userRepo.FindLDAPUsersByOrgId(connector.OrgID)
+	if err != nil {
+		return result, err
+	}
+
+	usersByDN := map[string]model.User{}
+	for _, user := range syncedUsers {
+		usersByDN[normalizeDN(user.LDAPDN)] = user
+	}
+
+	entryDNs := map[string]bool{}
+	for _, entry := range entries {
+		entryDNs[normalizeDN(entry.DN)] = true
+	}
+
+	// A user adopted under a new DN is still listed under its old one
+	syncedIds := map[uuid.UUID]bool{}
+
+	for _, entry := range entries {
+		key := normalizeDN(entry.DN)
+
+		user, found := usersByDN[key]
+		created := false
+		changed := false
+
+		if !found {
+			user, created, err = adoptOrCreateUser(connector.OrgID, entry, entryDNs)
+			if err != nil {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", entry.DN, err.Error()))
+				continue
+			}
+			changed = !created
+		}
+
+		if !created && entry.Email != "" && user.Email != entry.Email {
+			user.Email = entry.Email
+			changed = true
+		}
+
+		// The directory decides who has an account
+		if user.AccountStatus == constants.DEACTIVATED {
+			user.AccountStatus = constants.ACTIVATED
+			changed = true
+		}
+
+		if changed {
+			if _, err := userRepo.UpdateUser(user); err != nil {
+				return result, err
+			}
+			result.Updated++
+		}
+		if created {
+			result.Created++
+		}
+		syncedIds[user.ID] = true
+
+		groupChanges, err := groupMapping.Sync(user, connector.GroupMappings, memberships[key])
+		result.GroupChanges += groupChanges
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for key, user := range usersByDN {
+		if entryDNs[key] || syncedIds[user.ID] || user.AccountStatus == constants.DEACTIVATED {
+			continue
+		}
+
+		user.AccountStatus = constants.DEACTIVATED
+		if _, err := userRepo.UpdateUser(user); err != nil {
+			return result, err
+		}
+		if err := tokens.RevokeAllTokens(user.ID); err != nil {
+			return result, err
+		}
+		result.Deactivated++
+	}
+
+	return result, nil
+}
+
+// adoptOrCreateUser links a directory entry to the user of the org with the
+// same username, e.g. one seeded from a CSV before, or creates the user. A
+// user already linked to another entry that still exists is not taken over.
+func adoptOrCreateUser(orgId uuid.UUID, entry directoryEntry, entryDNs map[string]bool) (model.User, bool, error) {
+	user, err := userRepo.FindUserByOrgAndUsernameWithPassword(entry.Username, orgId.String())
+	if err == nil {
+		if user.AccountStatus == constants.DELETED {
+			return model.User{}, false, errors.New("username belongs to a deleted user")
+		}
+		if user.LDAPDN != "" && entryDNs[normalizeDN(user.LDAPDN)] {
+			return model.User{}, false, errors.New("username is taken by another entry")
+		}
+		user.LDAPDN = entry.DN
+		return user, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.User{}, false, err
+	}
+
+	// Synced users have no password of their own; they sign in by bind
+	now := time.Now()
+	createdUser, err := userRepo.CreateUser(model.User{
+		Username:          entry.Username,
+		Email:             entry.Email,
+		OrgID:             orgId,
+		AccountStatus:     constants.ACTIVATED,
+		LDAPDN:            entry.DN,
+		PasswordChangedAt: &now,
+	})
+	if err != nil {
+		return model.User{}, false, err
+	}
+
+	user, err = userRepo.FindUserByIdWithPassword(createdUser.ID)
+	return user, true, err
+}
+
+func searchUsers(conn *ldap.Conn, connector model.LDAPConnector) ([]directoryEntry, error) {
+	request := ldap.NewSearchRequest(connector.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		connector.UserFilter, []string{connector.UsernameAttribute, connector.EmailAttribute}, nil)
+
+	response, err := conn.SearchWithPaging(request, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []directoryEntry{}
+	for _, entry := range response.Entries {
+		username := strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(connector.UsernameAttribute)))
+		if username == "" {
+			continue
+		}
+		entries = append(entries, directoryEntry{
+			DN:       entry.DN,
+			Username: username,
+			Email:    strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(connector.EmailAttribute))),
+		})
+	}
+
+	return entries, nil
+}
+
+// searchMemberships returns the names of the mapped groups each member DN is
+// in. Nothing is searched when no group is mapped.
+func searchMemberships(conn *ldap.Conn, connector model.LDAPConnector) (map[string][]string, error) {
+	memberships := map[string][]string{}
+	if len(connector.GroupMappings) == 0 {
+		return memberships, nil
+	}
+
+	baseDN := connector.GroupBaseDN
+	if baseDN == "" {
+		baseDN = connector.BaseDN
+	}
+
+	request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		connector.GroupFilter, []string{connector.GroupNameAttribute, connector.GroupMemberAttribute}, nil)
+
+	response, err := conn.SearchWithPaging(request, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range response.Entries {
+		name := entry.GetAttributeValue(connector.GroupNameAttribute)
+		if _, mapped := connector.GroupMappings[name]; !mapped {
+			continue
+		}
+		for _, member := range entry.GetAttributeValues(connector.GroupMemberAttribute) {
+			key := normalizeDN(member)
+			memberships[key] = append(memberships[key], name)
+		}
+	}
+
+	return memberships, nil
+}
+
+// normalizeDN makes DNs that differ only in case or spacing compare equal.
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+
+	rdns := []string{}
+	for _, rdn := range parsed.RDNs {
+		attributes := []string{}
+		for _, attribute := range rdn.Attributes {
+			attributes = append(attributes, strings.ToLower(attribute.Type)+"="+strings.ToLower(attribute.Value))
+		}
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,212 @@
+package lockout
+
+import (
+	"balkantask/config"
+	loginFailureRepo "balkantask/database/loginFailure"
+	orgRepo "balkantask/database/org"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	constants "balkantask/utils"
+	"balkantask/utils/audit"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Failures allowed before the progressive delay starts
+	freeAttempts = 2
+	maxDelay     = 30 * time.Second
+)
+
+// Account identifies the account a login attempt was made for.
+type Account struct {
+	ID    uuid.UUID
+	Type  constants.PrincipalType
+	OrgID uuid.UUID
+}
+
+// Threshold is the number of failed logins within Duration after which an
+// account gets locked.
+func Threshold() int {
+	return config.Int("LOCKOUT_THRESHOLD", 5)
+}
+
+// IPThreshold is the number of failed logins within Duration after which an
+// IP is blocked, whatever accounts it tried.
+func IPThreshold() int {
+	return config.Int("LOCKOUT_IP_THRESHOLD", 20)
+}
+
+// Duration is both how long a lock lasts and how long failures are counted.
+func Duration() time.Duration {
+	return config.Duration("LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// Delay is the wait enforced after the given number of consecutive failures:
+// nothing for the first few, then doubling from one second up to maxDelay.
+func Delay(failures int) time.Duration {
+	if failures <= freeAttempts {
+		return 0
+	}
+	if failures-freeAttempts > 6 {
+		return maxDelay
+	}
+
+	delay := time.Second << uint(failures-freeAttempts-1)
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// RetryAfter returns how long the client has to wait before it may try to log
+// in again from the IP and, when subject is set, for that account.
+func RetryAfter(subject uuid.UUID, ip string) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+
+	if failure, err := loginFailureRepo.FindLoginFailure(ipKey(ip)); err == nil && failure.Failures >= IPThreshold() {
+		wait = failure.LastFailedAt.Add(Duration()).Sub(now)
+	}
+
+	if subject != uuid.Nil {
+		if failure, err := loginFailureRepo.FindLoginFailure(accountKey(subject)); err == nil {
+			if accountWait := failure.LastFailedAt.Add(Delay(failure.Failures)).Sub(now); accountWait > wait {
+				wait = accountWait
+			}
+		}
+	}
+
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// LockedFor returns how long the account stays locked, zero if it is not.
+func LockedFor(subject uuid.UUID) time.Duration {
+	failure, err := loginFailureRepo.FindLoginFailure(accountKey(subject))
+	if err != nil || failure.LockedUntil == nil {
+		return 0
+	}
+
+	remaining := time.Until(*failure.LockedUntil)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// RecordFailure counts a failed login for the IP and, when its ID is set, for
+// the account. It returns true when the failure locked the account.
+func RecordFailure(account Account, ip string) bool {
+	now := time.Now()
+	windowStart := now.Add(-Duration())
+
+	if _, err := loginFailureRepo.IncrementLoginFailure(ipKey(ip), now, windowStart); err != nil {
+		fmt.Println("Error recording failed login:", err)
+	}
+
+	if account.ID == uuid.Nil {
+		return false
+	}
+
+	failure, err := loginFailureRepo.IncrementLoginFailure(accountKey(account.ID), now, windowStart)
+	if err != nil {
+		fmt.Println("Error recording failed login:", err)
+		return false
+	}
+
+	if failure.Failures < Threshold() {
+		return false
+	}
+
+	lockedUntil := now.Add(Duration())
+	if err := loginFailureRepo.SetLockedUntil(failure.Key, lockedUntil); err != nil {
+		fmt.Println("Error locking account:", err)
+		return false
+	}
+
+	switch account.Type {
+	case constants.USER:
+		err = userRepo.LockUser(account.ID)
+	case constants.ORG:
+		err = orgRepo.LockOrg(account.ID)
+	}
+	if err != nil {
+		fmt.Println("Error locking account:", err)
+	}
+
+	audit.Record(model.AuditLog{
+		OrgID:      account.OrgID,
+		Action:     audit.AccountLocked,
+		TargetID:   account.ID,
+		TargetType: account.Type,
+		IP:         ip,
+		Details:    fmt.Sprintf("Locked until %s after %d failed login attempts", lockedUntil.Format(time.RFC3339), failure.Failures),
+	})
+
+	return true
+}
+
+// RecordSuccess forgets the failed logins of an account. Failures of the IP
+// are kept, so one valid account cannot be used to reset them.
+func RecordSuccess(subject uuid.UUID) {
+	if err := loginFailureRepo.DeleteLoginFailure(accountKey(subject)); err != nil {
+		fmt.Println("Error clearing failed logins:", err)
+	}
+}
+
+// ReleaseExpiredLock unlocks a LOCKED account whose lock has run out. It
+// returns false while the lock still holds.
+func ReleaseExpiredLock(account Account) bool {
+	if LockedFor(account.ID) > 0 {
+		return false
+	}
+
+	err := Unlock(account, model.AuditLog{Details: "Lock expired"})
+	return err == nil
+}
+
+// Unlock lifts the lock of an account and forgets its failed logins. The
+// entry carries the actor and the reason recorded in the audit log when the
+// account was actually locked.
+func Unlock(account Account, entry model.AuditLog) error {
+	var unlocked bool
+	var err error
+	switch account.Type {
+	case constants.USER:
+		unlocked, err = userRepo.UnlockUser(account.ID)
+	case constants.ORG:
+		unlocked, err = orgRepo.UnlockOrg(account.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := loginFailureRepo.DeleteLoginFailure(accountKey(account.ID)); err != nil {
+		return err
+	}
+
+	if !unlocked {
+		return nil
+	}
+
+	entry.OrgID = account.OrgID
+	entry.Action = audit.AccountUnlocked
+	entry.TargetID = account.ID
+	entry.TargetType = account.Type
+	audit.Record(entry)
+
+	return nil
+}
+
+func accountKey(subject uuid.UUID) string {
+	return "account:" + subject.String()
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,77 @@
+package mfa
+
+import (
+	mfaRepo "balkantask/database/mfa"
+	"balkantask/model"
+	"balkantask/utils/tokens"
+	"balkantask/utils/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"os"
+	"strings"
+	"time"
+)
+
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Issuer is the name authenticator apps show next to the account.
+func Issuer() string {
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		return "GO-IAM"
+	}
+	return issuer
+}
+
+// VerifyCode checks a TOTP code, or failing that an unused recovery code, for
+// a confirmed factor. Both can only be used once. It returns the amr values
+// describing how the factor was satisfied.
+func VerifyCode(factor model.MFAFactor, code string) ([]string, bool, error) {
+	code = strings.ReplaceAll(code, " ", "")
+
+	if step, ok := totp.Validate(factor.Secret, code, time.Now()); ok {
+		used, err := mfaRepo.UseFactorStep(factor.ID, step)
+		if err != nil || !used {
+			return nil, false, err
+		}
+		return []string{"mfa", "otp"}, true, nil
+	}
+
+	used, err := mfaRepo.UseRecoveryCode(factor.SubjectID, HashRecoveryCode(code))
+	if err != nil || !used {
+		return nil, false, err
+	}
+	return []string{"mfa"}, true, nil
+}
+
+// GenerateRecoveryCodes replaces the subject's recovery codes and returns the
+// new ones. They are stored hashed and can only be shown now.
+func GenerateRecoveryCodes(factor model.MFAFactor) ([]string, error) {
+	codes := []string{}
+	codeHashes := []string{}
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		codeHashes = append(codeHashes, HashRecoveryCode(code))
+	}
+
+	if err := mfaRepo.ReplaceRecoveryCodes(factor.SubjectID, codeHashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func HashRecoveryCode(code string) string {
+	return tokens.HashToken(strings.ToLower(strings.ReplaceAll(code, "-", "")))
+}
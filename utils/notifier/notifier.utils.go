@@ -0,0 +1,119 @@
+package notifier
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users, e.g. password reset links.
+type Notifier interface {
+	Send(message Message) error
+}
+
+// SMTPNotifier sends messages as plain text emails.
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n SMTPNotifier) Send(message Message) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	body := strings.Join([]string{
+		"From: " + n.From,
+		"To: " + message.To,
+		"Subject: " + message.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		message.Body,
+	}, "\r\n")
+
+	return smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{message.To}, []byte(body))
+}
+
+// LogNotifier writes messages to a file, or to the log when Path is empty. It
+// is meant for local development where there is no mail server.
+type LogNotifier struct {
+	Path string
+}
+
+func (n LogNotifier) Send(message Message) error {
+	entry := fmt.Sprintf("[%s] To: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), message.To, message.Subject, message.Body)
+
+	if n.Path == "" {
+		log.Print(entry)
+		return nil
+	}
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(entry)
+	return err
+}
+
+var (
+	notifierMutex sync.Mutex
+	current       Notifier
+)
+
+// Default returns the notifier in use. Unless replaced with Use, it is picked
+// by NOTIFIER: smtp, or log (the default).
+func Default() Notifier {
+	notifierMutex.Lock()
+	defer notifierMutex.Unlock()
+
+	if current == nil {
+		current = fromEnv()
+	}
+
+	return current
+}
+
+// Use replaces the default notifier, e.g. with another delivery channel.
+func Use(notifier Notifier) {
+	notifierMutex.Lock()
+	defer notifierMutex.Unlock()
+
+	current = notifier
+}
+
+func Send(message Message) error {
+	return Default().Send(message)
+}
+
+func fromEnv() Notifier {
+	if os.Getenv("NOTIFIER") == "smtp" {
+		return SMTPNotifier{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	}
+
+	return LogNotifier{Path: os.Getenv("NOTIFIER_LOG_FILE")}
+}
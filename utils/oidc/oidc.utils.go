@@ -0,0 +1,436 @@
+package oidc
+
+import (
+	"balkantask/model"
+	"balkantask/utils/tokens"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// LoginStateUse is the token_use of the cookie that carries a login between
+// the redirect to the provider and its callback.
+const LoginStateUse = "oidc_state"
+
+const (
+	// How long metadata and keys of a provider are reused
+	cacheTTL = time.Hour
+	// Unknown key ids refetch the key set at most this often
+	keyRefetchInterval = time.Minute
+	LoginStateTTL      = 10 * time.Minute
+)
+
+var idTokenAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+var (
+	ErrInvalidIDToken   = errors.New("invalid id token")
+	ErrInvalidState     = errors.New("invalid login state")
+	ErrProviderResponse = errors.New("unexpected response from identity provider")
+	ErrInsecureIssuer   = errors.New("issuer must use https")
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Discovery is the part of a provider's metadata the login needs.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// LoginState is what the callback needs to finish a login it started.
+type LoginState struct {
+	ProviderID   uuid.UUID
+	State        string
+	Nonce        string
+	CodeVerifier string
+	// The login was started for a cookie session
+	CookieSession bool
+}
+
+type cachedDiscovery struct {
+	discovery Discovery
+	fetchedAt time.Time
+}
+
+type cachedKeys struct {
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+var (
+	cacheMutex  sync.Mutex
+	discoveries = map[string]cachedDiscovery{}
+	keySets     = map[string]cachedKeys{}
+)
+
+// RedirectURI is the callback registered with every provider.
+func RedirectURI() string {
+	redirectURI := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURI == "" {
+		return tokens.Issuer() + "/api/auth/oidc/callback"
+	}
+	return redirectURI
+}
+
+// LoginURL starts a login with the provider.
+func LoginURL(providerId uuid.UUID) string {
+	return tokens.Issuer() + "/api/auth/oidc/" + providerId.String() + "/login"
+}
+
+// SecureIssuer reports whether the issuer is served over https. Plain http
+// issuers are only accepted when OIDC_ALLOW_INSECURE_ISSUER is set, which is
+// meant for a provider running next to the API during development.
+func SecureIssuer(issuer string) bool {
+	if allowInsecure, _ := strconv.ParseBool(os.Getenv("OIDC_ALLOW_INSECURE_ISSUER")); allowInsecure {
+		return true
+	}
+
+	parsed, err := url.Parse(issuer)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != ""
+}
+
+// Discover loads the metadata of an issuer from its well-known document.
+func Discover(issuer string) (Discovery, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if !SecureIssuer(issuer) {
+		return Discovery{}, ErrInsecureIssuer
+	}
+
+	cacheMutex.Lock()
+	cached, ok := discoveries[issuer]
+	cacheMutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < cacheTTL {
+		return cached.discovery, nil
+	}
+
+	var discovery Discovery
+	if err := getJSON(issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return Discovery{}, err
+	}
+
+	// The document must describe the issuer it was fetched from
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return Discovery{}, ErrProviderResponse
+	}
+
+	cacheMutex.Lock()
+	discoveries[issuer] = cachedDiscovery{discovery: discovery, fetchedAt: time.Now()}
+	cacheMutex.Unlock()
+
+	return discovery, nil
+}
+
+// NewLoginState starts a login with a provider. The returned value is kept in
+// a cookie until the callback; only the state and nonce go to the provider.
+func NewLoginState(providerId uuid.UUID, cookieSession bool) (LoginState, string, error) {
+	loginState := LoginState{ProviderID: providerId, CookieSession: cookieSession}
+	for _, value := range []*string{&loginState.State, &loginState.Nonce, &loginState.CodeVerifier} {
+		random, _, err := tokens.GenerateOpaqueToken()
+		if err != nil {
+			return LoginState{}, "", err
+		}
+		*value = random
+	}
+
+	now := time.Now().UTC()
+	signed, err := tokens.SignToken(jwt.MapClaims{
+		"iss":           tokens.Issuer(),
+		"sub":           providerId,
+		"token_use":     LoginStateUse,
+		"state":         loginState.State,
+		"nonce":         loginState.Nonce,
+		"code_verifier": loginState.CodeVerifier,
+		"cookie":        cookieSession,
+		"exp":           now.Add(LoginStateTTL).Unix(),
+		"iat":           now.Unix(),
+		"nbf":           now.Unix(),
+	})
+
+	return loginState, signed, err
+}
+
+// ParseLoginState reads the cookie set by NewLoginState.
+func ParseLoginState(signed string) (LoginState, error) {
+	tokenByte, err := tokens.ParseToken(signed)
+	if err != nil {
+		return LoginState{}, ErrInvalidState
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid || claims["token_use"] != LoginStateUse {
+		return LoginState{}, ErrInvalidState
+	}
+
+	providerId, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return LoginState{}, ErrInvalidState
+	}
+
+	return LoginState{
+		ProviderID:    providerId,
+		State:         ClaimString(claims, "state"),
+		Nonce:         ClaimString(claims, "nonce"),
+		CodeVerifier:  ClaimString(claims, "code_verifier"),
+		CookieSession: ClaimTrue(claims, "cookie"),
+	}, nil
+}
+
+// AuthorizationURL is where the browser is sent to sign in at the provider.
+func AuthorizationURL(discovery Discovery, provider model.IdentityProvider, loginState LoginState) string {
+	scopes := []string{"openid"}
+	for _, scope := range provider.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(loginState.CodeVerifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.ClientID)
+	params.Set("redirect_uri", RedirectURI())
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", loginState.State)
+	params.Set("nonce", loginState.Nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange trades the code of the callback for the provider's ID token.
+func Exchange(discovery Discovery, provider model.IdentityProvider, code string, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", RedirectURI())
+	form.Set("code_verifier", codeVerifier)
+
+	request, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(provider.ClientID), url.QueryEscape(provider.ClientSecret))
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned %d", ErrProviderResponse, response.StatusCode)
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.IDToken == "" {
+		return "", ErrProviderResponse
+	}
+
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an
+// ID token and returns its claims.
+func VerifyIDToken(discovery Discovery, provider model.IdentityProvider, idToken string, nonce string) (jwt.MapClaims, error) {
+	tokenByte, err := jwt.Parse(idToken, func(jwtToken *jwt.Token) (interface{}, error) {
+		kid, _ := jwtToken.Header["kid"].(string)
+		return publicKey(discovery.JWKSURI, kid)
+	}, jwt.WithValidMethods(idTokenAlgorithms), jwt.WithIssuer(discovery.Issuer), jwt.WithAudience(provider.ClientID), jwt.WithLeeway(time.Minute))
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if expiresAt, err := claims.GetExpirationTime(); err != nil || expiresAt == nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	// A token for several audiences must name us as the party it was issued to
+	if azp, ok := claims["azp"]; ok && fmt.Sprint(azp) != provider.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+
+	if ClaimString(claims, "nonce") != nonce || ClaimString(claims, "sub") == "" {
+		return nil, ErrInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// ClaimString returns a string claim, or an empty string if it is missing or
+// of another type.
+func ClaimString(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// ClaimStrings returns a claim holding a list of strings. Providers that send
+// a single value as a plain string are accepted too.
+func ClaimStrings(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := []string{}
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	}
+	return []string{}
+}
+
+// ClaimTrue reports whether a boolean claim is set. Some providers send
+// email_verified as a string.
+func ClaimTrue(claims jwt.MapClaims, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// publicKey finds the key a provider signed with, refetching the key set
+// when the kid is unknown, e.g. after the provider rotated its keys.
+func publicKey(jwksURI string, kid string) (crypto.PublicKey, error) {
+	cacheMutex.Lock()
+	cached, ok := keySets[jwksURI]
+	cacheMutex.Unlock()
+
+	if ok && time.Since(cached.fetchedAt) < cacheTTL {
+		if key, found := cached.keys[kid]; found {
+			return key, nil
+		}
+		if time.Since(cached.fetchedAt) < keyRefetchInterval {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+	}
+
+	keys, err := fetchKeys(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheMutex.Lock()
+	keySets[jwksURI] = cachedKeys{keys: keys, fetchedAt: time.Now()}
+	cacheMutex.Unlock()
+
+	key, found := keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+func fetchKeys(jwksURI string) (map[string]crypto.PublicKey, error) {
+	var keySet struct {
+		Keys []tokens.JWK `json:"keys"`
+	}
+	if err := getJSON(jwksURI, &keySet); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			// Keys of types we do not support are skipped
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func parseJWK(jwk tokens.JWK) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
+
+func getJSON(target string, value interface{}) error {
+	response, err := httpClient.Get(target)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", ErrProviderResponse, target, response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
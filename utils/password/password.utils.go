@@ -0,0 +1,193 @@
+package password
+
+import (
+	passwordRepo "balkantask/database/password"
+	"balkantask/model"
+	constants "balkantask/utils"
+	"balkantask/utils/hashing"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	pass "github.com/sethvargo/go-password/password"
+	"gorm.io/gorm"
+)
+
+const (
+	// Special characters that count for RequireSpecial
+	specialCharacters = "@$!%*#?&"
+
+	// Hashes kept per principal, the largest history depth a policy may ask for
+	MaxHistoryDepth = 24
+
+	minGeneratedLength = 12
+)
+
+// Requirements describes the default policy, which also applies to org signup.
+var Requirements = Describe(DefaultPolicy())
+
+var generator, _ = pass.NewGenerator(&pass.GeneratorInput{Symbols: specialCharacters})
+
+// DefaultPolicy is used by orgs that did not configure their own policy.
+func DefaultPolicy() model.PasswordPolicy {
+	return model.PasswordPolicy{
+		MinLength:        8,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSpecial:   true,
+		BannedWords:      []string{},
+	}
+}
+
+// PolicyForOrg returns the password policy of the org, or the default one.
+func PolicyForOrg(orgId uuid.UUID) (model.PasswordPolicy, error) {
+	policy, err := passwordRepo.FindPasswordPolicyByOrgId(orgId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		policy = DefaultPolicy()
+		policy.OrgID = orgId
+		return policy, nil
+	}
+	return policy, err
+}
+
+// Describe explains the length and character rules of a policy.
+func Describe(policy model.PasswordPolicy) string {
+	var classes []string
+	if policy.RequireUppercase {
+		classes = append(classes, "one uppercase letter")
+	}
+	if policy.RequireLowercase {
+		classes = append(classes, "one lowercase letter")
+	}
+	if policy.RequireDigit {
+		classes = append(classes, "one number")
+	}
+	if policy.RequireSpecial {
+		classes = append(classes, "one special character ("+specialCharacters+")")
+	}
+
+	description := fmt.Sprintf("Password must be at least %d characters long", policy.MinLength)
+	if len(classes) > 0 {
+		description += ", contain at least " + strings.Join(classes[:len(classes)-1], ", ")
+		if len(classes) > 1 {
+			description += " and "
+		}
+		description += classes[len(classes)-1]
+	}
+	return description + "."
+}
+
+// Validate checks a password against the default policy.
+func Validate(password string) bool {
+	return Violation(DefaultPolicy(), password) == ""
+}
+
+// Violation returns why the password does not satisfy the policy, or an empty
+// string if it does.
+func Violation(policy model.PasswordPolicy, password string) string {
+	hasUppercase, hasLowercase, hasDigit, hasSpecial := false, false, false, false
+	for _, char := range password {
+		switch {
+		case char >= 'A' && char <= 'Z':
+			hasUppercase = true
+		case char >= 'a' && char <= 'z':
+			hasLowercase = true
+		case char >= '0' && char <= '9':
+			hasDigit = true
+		case strings.ContainsRune(specialCharacters, char):
+			hasSpecial = true
+		}
+	}
+
+	if len(password) < policy.MinLength ||
+		(policy.RequireUppercase && !hasUppercase) ||
+		(policy.RequireLowercase && !hasLowercase) ||
+		(policy.RequireDigit && !hasDigit) ||
+		(policy.RequireSpecial && !hasSpecial) {
+		return Describe(policy)
+	}
+
+	lowered := strings.ToLower(password)
+	for _, word := range policy.BannedWords {
+		if word != "" && strings.Contains(lowered, strings.ToLower(word)) {
+			return fmt.Sprintf("Password must not contain the word %q.", word)
+		}
+	}
+
+	return ""
+}
+
+// Check verifies a new password of an existing principal: the policy rules
+// and, when the policy keeps a history, that it was not used recently. It
+// returns the reason the password is refused, if any.
+func Check(policy model.PasswordPolicy, subject uuid.UUID, password string) (string, error) {
+	if violation := Violation(policy, password); violation != "" {
+		return violation, nil
+	}
+
+	if policy.HistoryDepth <= 0 || subject == uuid.Nil {
+		return "", nil
+	}
+
+	history, err := passwordRepo.FindPasswordHistory(subject, policy.HistoryDepth)
+	if err != nil {
+		return "", err
+	}
+
+	for _, entry := range history {
+		if hashing.Verify(password, entry.PasswordHash) {
+			return fmt.Sprintf("Password must differ from the last %d passwords.", policy.HistoryDepth), nil
+		}
+	}
+
+	return "", nil
+}
+
+// Generate returns a random password that satisfies the policy, for accounts
+// created without one.
+func Generate(policy model.PasswordPolicy) (string, error) {
+	length := policy.MinLength
+	if length < minGeneratedLength {
+		length = minGeneratedLength
+	}
+
+	// Letters are picked at random, so a rare draw can miss a letter class
+	for i := 0; i < 10; i++ {
+		generated, err := generator.Generate(length, 2, 2, false, true)
+		if err != nil {
+			return "", err
+		}
+		if Violation(policy, generated) == "" {
+			return generated, nil
+		}
+	}
+
+	return "", errors.New("could not generate a password for the policy")
+}
+
+// Remember records the hash of a password that was just set, so it can be
+// checked against later changes.
+func Remember(subject uuid.UUID, subjectType constants.PrincipalType, hash string) error {
+	_, err := passwordRepo.CreatePasswordHistory(model.PasswordHistory{
+		SubjectID:    subject,
+		SubjectType:  subjectType,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		return err
+	}
+
+	return passwordRepo.TrimPasswordHistory(subject, MaxHistoryDepth)
+}
+
+// Expired reports whether a password last changed at changedAt has to be
+// rotated under the policy.
+func Expired(policy model.PasswordPolicy, changedAt time.Time) bool {
+	if policy.MaxAgeDays <= 0 {
+		return false
+	}
+	return changedAt.AddDate(0, 0, policy.MaxAgeDays).Before(time.Now())
+}
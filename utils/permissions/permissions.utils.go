@@ -0,0 +1,390 @@
+package permissions
+
+import (
+	policyRepo "balkantask/database/policy"
+	rolesRepo "balkantask/database/roles"
+	"balkantask/model"
+	apiKeySchema "balkantask/schemas/apiKey"
+	orgSchema "balkantask/schemas/org"
+	serviceAccountSchema "balkantask/schemas/serviceAccount"
+	userSchema "balkantask/schemas/user"
+	constants "balkantask/utils"
+	"balkantask/utils/policy"
+	"balkantask/utils/roles"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type Permission string
+
+const (
+	OrgRead        Permission = "org:read"
+	OrgWrite       Permission = "org:write"
+	OrgAdmin       Permission = "org:admin"
+	AuditRead      Permission = "audit:read"
+	UserRead       Permission = "user:read"
+	UserWrite      Permission = "user:write"
+	UserDeactivate Permission = "user:deactivate"
+	UserDelete     Permission = "user:delete"
+	RoleRead       Permission = "role:read"
+	RoleWrite      Permission = "role:write"
+	GroupRead      Permission = "group:read"
+	GroupWrite     Permission = "group:write"
+	TaskRead       Permission = "task:read"
+	TaskWrite      Permission = "task:write"
+	PolicyRead     Permission = "policy:read"
+	PolicyWrite    Permission = "policy:write"
+)
+
+// Definition describes a permission of the catalogue.
+type Definition struct {
+	Name        Permission `json:"name"`
+	Description string     `json:"description"`
+}
+
+// Catalogue lists every permission the API checks.
+var Catalogue = []Definition{
+	{OrgRead, "View the sign-in settings of the org: identity providers and LDAP"},
+	{OrgWrite, "Manage OAuth clients of the org"},
+	{OrgAdmin, "Manage security settings: identity providers, LDAP, MFA and password policy"},
+	{AuditRead, "View the audit log"},
+	{UserRead, "View users, their sessions, invitations and service accounts"},
+	{UserWrite, "Create and update users, invitations and service accounts and assign their roles and groups"},
+	{UserDeactivate, "Deactivate, reactivate and unlock users"},
+	{UserDelete, "Delete users"},
+	{RoleRead, "View roles"},
+	{RoleWrite, "Create, update and delete custom roles"},
+	{GroupRead, "View groups"},
+	{GroupWrite, "Create and delete groups and assign their roles"},
+	{TaskRead, "View tasks"},
+	{TaskWrite, "Create and delete tasks and assign their roles"},
+	{PolicyRead, "View policies and what they are attached to"},
+	{PolicyWrite, "Create, update, delete, attach and detach policies"},
+}
+
+// rolePermissions maps the system roles to what they grant on top of the
+// roles they include, e.g. USER_FULL_ACCESS adds deleting users to
+// USER_WRITE_ACCESS. The org roles include the roles of every kind. Policies
+// can grant anything, so only ORG_FULL_ACCESS manages them.
+var rolePermissions = map[roles.Role][]Permission{
+	roles.OrgFullAccess:    {OrgAdmin, PolicyWrite},
+	roles.OrgWriteAccess:   {OrgWrite},
+	roles.OrgReadAccess:    {OrgRead, AuditRead, PolicyRead},
+	roles.UserFullAccess:   {UserDelete},
+	roles.UserWriteAccess:  {UserWrite, UserDeactivate},
+	roles.UserReadAccess:   {UserRead},
+	roles.RoleFullAccess:   {},
+	roles.RoleWriteAccess:  {RoleWrite},
+	roles.RoleReadAccess:   {RoleRead},
+	roles.GroupFullAccess:  {},
+	roles.GroupWriteAccess: {GroupWrite},
+	roles.GroupReadAccess:  {GroupRead},
+	roles.TasksFullAccess:  {},
+	roles.TasksWriteAccess: {TaskWrite},
+	roles.TasksReadAccess:  {TaskRead},
+}
+
+// Kind is the kind of resource a permission is about, e.g. task for
+// task:read.
+func (p Permission) Kind() string {
+	kind, _, _ := strings.Cut(string(p), ":")
+	return kind
+}
+
+// Names lists the names of the catalogue, the actions of a policy.
+func Names() []string {
+	names := []string{}
+	for _, definition := range Catalogue {
+		names = append(names, string(definition.Name))
+	}
+	return names
+}
+
+// IsValid tells whether the permission is in the catalogue.
+func IsValid(permission Permission) bool {
+	for _, definition := range Catalogue {
+		if definition.Name == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ForRole lists the permissions a role grants itself: the built-in ones of a
+// SYSTEM role or the ones a CUSTOM role was created with. The roles it
+// includes add theirs, see ForRoles.
+func ForRole(role model.Role) []Permission {
+	if role.OrgID == nil {
+		return rolePermissions[roles.Role(role.Name)]
+	}
+
+	granted := []Permission{}
+	for _, permission := range role.Permissions {
+		granted = append(granted, Permission(permission))
+	}
+	return granted
+}
+
+// ForRoles lists without duplicates the permissions granted by roles that are
+// already expanded with the roles they include.
+func ForRoles(expanded []model.Role) []Permission {
+	granted := []Permission{}
+	for _, role := range expanded {
+		for _, permission := range ForRole(role) {
+			if !contains(granted, permission) {
+				granted = append(granted, permission)
+			}
+		}
+	}
+	return granted
+}
+
+// RolesGranting lists the system roles that grant a permission, themselves or
+// through the roles they include.
+func RolesGranting(permission Permission) []roles.Role {
+	granting := []roles.Role{}
+	for _, role := range roles.SystemRoles {
+		for _, included := range roles.SystemClosure(role) {
+			if contains(rolePermissions[included], permission) {
+				granting = append(granting, role)
+				break
+			}
+		}
+	}
+	return granting
+}
+
+// Has tells whether the roles, expanded with the roles they include, grant
+// the permission in the org. CUSTOM roles of other orgs grant nothing.
+func Has(orgId uuid.UUID, expanded []model.Role, permission Permission) bool {
+	for _, role := range expanded {
+		if roles.AvailableTo(role, orgId) && contains(ForRole(role), permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// CallerOrgId returns the org the caller of a request acts in: the org of
+// its root, user or service account.
+func CallerOrgId(c *fiber.Ctx) (uuid.UUID, bool) {
+	if org, ok := c.Locals("org").(orgSchema.OrgResponse); ok {
+		return org.ID, true
+	}
+
+	if user, ok := c.Locals("user").(userSchema.UserResponse); ok {
+		return user.OrgId, true
+	}
+
+	if serviceAccount, ok := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse); ok {
+		return serviceAccount.OrgId, true
+	}
+
+	return uuid.Nil, false
+}
+
+// Granted tells whether the caller of a request holds the permission on
+// every resource of its kind.
+func Granted(c *fiber.Ctx, permission Permission) bool {
+	return Authorize(c, permission, policy.AnyResource(permission.Kind()))
+}
+
+// Authorize is the central check of whether the caller may use a permission
+// on a resource, e.g. task:read on task/<id>. The policies attached to the
+// caller, its groups and roles are evaluated first and an explicit Deny wins
+// over any grant. Without a matching statement the roles decide, counting
+// only those whose assignment conditions the request meets. The org root is
+// always authorized in its org.
+func Authorize(c *fiber.Ctx, permission Permission, resource string) bool {
+	if _, ok := c.Locals("org").(orgSchema.OrgResponse); ok {
+		return true
+	}
+
+	caller, ok := callerOf(c)
+	if !ok {
+		return false
+	}
+
+	grants, err := callerGrants(c, caller)
+	if err != nil {
+		return false
+	}
+
+	switch policy.Evaluate(grants.statements, string(permission), resource, caller.context) {
+	case policy.Denied:
+		return false
+	case policy.Allowed:
+		// A key restricted to some roles is not widened by policies
+		if len(caller.keyRoles) == 0 {
+			return true
+		}
+	}
+
+	return Has(caller.orgId, grants.roles, permission)
+}
+
+type principal struct {
+	id       uuid.UUID
+	orgId    uuid.UUID
+	roles    []model.Role
+	groups   []model.Group
+	keyRoles []model.Role
+	context  policy.Context
+}
+
+// grants is what is loaded for a caller once per request.
+type grants struct {
+	statements []model.PolicyStatement
+	// Held in this request and expanded with the roles they include
+	roles []model.Role
+}
+
+func callerOf(c *fiber.Ctx) (principal, bool) {
+	context := policy.RequestContext(c.IP(), time.Now(), authMethods(c))
+
+	if user, ok := c.Locals("user").(userSchema.UserResponse); ok {
+		apiKey, _ := c.Locals("apiKey").(apiKeySchema.APIKeyResponse)
+
+		context[policy.KeyPrincipalId] = []string{user.ID.String()}
+		context[policy.KeyPrincipalType] = []string{string(constants.USER)}
+		context[policy.KeyOrgId] = []string{user.OrgId.String()}
+		context[policy.KeyUsername] = []string{user.Username}
+		context[policy.KeyEmail] = []string{user.Email}
+		context.AddAttributes(user.Attributes)
+
+		return principal{
+			id:       user.ID,
+			orgId:    user.OrgId,
+			roles:    user.Roles,
+			groups:   user.Groups,
+			keyRoles: apiKey.Roles,
+			context:  context,
+		}, true
+	}
+
+	if serviceAccount, ok := c.Locals("serviceAccount").(serviceAccountSchema.ServiceAccountResponse); ok {
+		context[policy.KeyPrincipalId] = []string{serviceAccount.ID.String()}
+		context[policy.KeyPrincipalType] = []string{string(constants.SERVICE_ACCOUNT)}
+		context[policy.KeyOrgId] = []string{serviceAccount.OrgId.String()}
+
+		return principal{
+			id:      serviceAccount.ID,
+			orgId:   serviceAccount.OrgId,
+			roles:   serviceAccount.Roles,
+			groups:  serviceAccount.Groups,
+			context: context,
+		}, true
+	}
+
+	return principal{}, false
+}
+
+// authMethods reads how the caller authenticated from the amr claim of its
+// access token.
+func authMethods(c *fiber.Ctx) []string {
+	if _, ok := c.Locals("apiKey").(apiKeySchema.APIKeyResponse); ok {
+		return []string{"api_key"}
+	}
+
+	methods := []string{}
+	claims, _ := c.Locals("claims").(jwt.MapClaims)
+	amr, _ := claims["amr"].([]interface{})
+	for _, method := range amr {
+		if name, ok := method.(string); ok {
+			methods = append(methods, name)
+		}
+	}
+	return methods
+}
+
+// callerGrants loads the roles the caller holds in this request, i.e. whose
+// assignment conditions are met, with the roles they include. Then the
+// policies attached to the caller, its groups and those roles.
+func callerGrants(c *fiber.Ctx, caller principal) (grants, error) {
+	if loaded, ok := c.Locals("callerGrants").(grants); ok {
+		return loaded, nil
+	}
+
+	holders := []uuid.UUID{caller.id}
+	for _, group := range caller.groups {
+		holders = append(holders, group.ID)
+	}
+
+	roleConditions, err := rolesRepo.GetRoleConditions(holders)
+	if err != nil {
+		return grants{}, err
+	}
+
+	conditions := map[[2]uuid.UUID]map[string]map[string][]string{}
+	for _, condition := range roleConditions {
+		conditions[[2]uuid.UUID{condition.PrincipalID, condition.RoleID}] = condition.Conditions
+	}
+
+	available, err := rolesRepo.GetRolesWithIncludes(caller.orgId)
+	if err != nil {
+		return grants{}, err
+	}
+
+	held := []model.Role{}
+	for _, role := range heldRoles(caller, conditions) {
+		if roles.AvailableTo(role, caller.orgId) {
+			held = append(held, role)
+		}
+	}
+	graph := roles.NewGraph(available)
+	expanded := graph.Expand(held)
+	// A restricted API key only gets what its roles grant of the owner's
+	if len(caller.keyRoles) > 0 {
+		expanded = graph.Restrict(held, caller.keyRoles)
+	}
+
+	principalIds := append([]uuid.UUID{}, holders...)
+	for _, role := range expanded {
+		if role.ID != uuid.Nil {
+			principalIds = append(principalIds, role.ID)
+		}
+	}
+
+	policies, err := policyRepo.FindPoliciesForPrincipals(caller.orgId, principalIds)
+	if err != nil {
+		return grants{}, err
+	}
+
+	loaded := grants{statements: policy.Statements(policies), roles: expanded}
+	c.Locals("callerGrants", loaded)
+	return loaded, nil
+}
+
+// heldRoles lists the roles of the caller, directly or through its groups,
+// whose conditions the request meets.
+func heldRoles(caller principal, conditions map[[2]uuid.UUID]map[string]map[string][]string) []model.Role {
+	held := []model.Role{}
+	for _, role := range caller.roles {
+		if policy.ConditionsMet(conditions[[2]uuid.UUID{caller.id, role.ID}], caller.context) {
+			held = append(held, role)
+		}
+	}
+
+	for _, group := range caller.groups {
+		for _, role := range group.Roles {
+			if policy.ConditionsMet(conditions[[2]uuid.UUID{group.ID, role.ID}], caller.context) {
+				held = append(held, role)
+			}
+		}
+	}
+
+	return held
+}
+
+func contains(permissions []Permission, target Permission) bool {
+	for _, permission := range permissions {
+		if permission == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,415 @@
+package policy
+
+import (
+	"balkantask/model"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Effects of a statement
+const (
+	Allow = "Allow"
+	Deny  = "Deny"
+)
+
+// Principal types a policy can be attached to
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+	PrincipalRole  = "role"
+)
+
+// Decision is the outcome of evaluating the policies of a caller.
+type Decision int
+
+const (
+	// NotApplicable means no statement matched, the roles decide
+	NotApplicable Decision = iota
+	Allowed
+	Denied
+)
+
+// ResourceKinds lists the kinds of resources, named kind/<id> in a statement.
+var ResourceKinds = []string{"org", "audit", "user", "role", "group", "task", "policy"}
+
+// Condition keys set for every caller
+const (
+	KeyPrincipalId   = "principal:id"
+	KeyPrincipalType = "principal:type"
+	KeyOrgId         = "principal:org_id"
+	KeyUsername      = "user:username"
+	KeyEmail         = "user:email"
+	KeyClientIP      = "request:ip"
+	KeyTime          = "request:time"
+	KeyTimeOfDay     = "request:time_of_day"
+	KeyDayOfWeek     = "request:day_of_week"
+	KeyAuthMethod    = "auth:method"
+	KeyMFA           = "auth:mfa"
+	// Followed by the name of a user attribute, e.g. user:attr/department
+	KeyUserAttributePrefix = "user:attr/"
+)
+
+// ConditionKeys lists the keys a condition can test, besides user
+// attributes.
+var ConditionKeys = []string{
+	KeyPrincipalId, KeyPrincipalType, KeyOrgId, KeyUsername, KeyEmail,
+	KeyClientIP, KeyTime, KeyTimeOfDay, KeyDayOfWeek, KeyAuthMethod, KeyMFA,
+}
+
+// Context holds the values of the condition keys for a request. A key can
+// have several values, e.g. every method the caller authenticated with.
+type Context map[string][]string
+
+type operator struct {
+	test func(value string, expected string) bool
+	// A negated operator needs every pair of values to pass, the others any
+	negated bool
+	// Checks an expected value when the document is stored
+	valid func(expected string) bool
+}
+
+var operators = map[string]operator{
+	"StringEquals":        {func(value, expected string) bool { return value == expected }, false, anyValue},
+	"StringNotEquals":     {func(value, expected string) bool { return value != expected }, true, anyValue},
+	"StringLike":          {func(value, expected string) bool { return Match(expected, value) }, false, anyValue},
+	"StringNotLike":       {func(value, expected string) bool { return !Match(expected, value) }, true, anyValue},
+	"IpAddress":           {inNetwork, false, validNetwork},
+	"NotIpAddress":        {func(value, expected string) bool { return !inNetwork(value, expected) }, true, validNetwork},
+	"DateGreaterThan":     {func(value, expected string) bool { return compareDates(value, expected) > 0 }, false, validDate},
+	"DateLessThan":        {func(value, expected string) bool { return compareDates(value, expected) < 0 }, false, validDate},
+	"TimeOfDayBetween":    {inWindow, false, validWindow},
+	"TimeOfDayNotBetween": {func(value, expected string) bool { return !inWindow(value, expected) }, true, validWindow},
+	"Bool":                {func(value, expected string) bool { return value == expected }, false, validBool},
+}
+
+// RequestContext sets the keys describing a request: where it comes from,
+// when, and how the caller authenticated. The time of day and day of week
+// are in the POLICY_TIMEZONE, UTC by default.
+func RequestContext(ip string, now time.Time, authMethods []string) Context {
+	local := now.In(location())
+	mfa := contains(authMethods, "mfa")
+
+	return Context{
+		KeyClientIP:   {ip},
+		KeyTime:       {now.UTC().Format(time.RFC3339)},
+		KeyTimeOfDay:  {local.Format("15:04")},
+		KeyDayOfWeek:  {local.Format("Mon")},
+		KeyAuthMethod: authMethods,
+		KeyMFA:        {strconv.FormatBool(mfa)},
+	}
+}
+
+// AddAttributes sets the user:attr/<name> keys.
+func (c Context) AddAttributes(attributes map[string]string) {
+	for name, value := range attributes {
+		c[KeyUserAttributePrefix+name] = []string{value}
+	}
+}
+
+// Resource names a single resource, e.g. task/<id>.
+func Resource(kind string, id uuid.UUID) string {
+	return kind + "/" + id.String()
+}
+
+// AnyResource names every resource of a kind, e.g. task/*. It is what a
+// request that is not about a single resource is checked against.
+func AnyResource(kind string) string {
+	return kind + "/*"
+}
+
+// Match tests a value against a pattern where * matches any run of
+// characters, e.g. task:* or group/*.
+func Match(pattern string, value string) bool {
+	star, next := -1, 0
+	p, v := 0, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case p < len(pattern) && pattern[p] == value[v]:
+			p++
+			v++
+		case star >= 0:
+			next++
+			p, v = star+1, next
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Statements collects the statements of the policies.
+func Statements(policies []model.Policy) []model.PolicyStatement {
+	statements := []model.PolicyStatement{}
+	for _, policy := range policies {
+		statements = append(statements, policy.Document.Statement...)
+	}
+	return statements
+}
+
+// Evaluate decides an action on a resource with deny-overrides: a matching
+// Deny wins over any Allow.
+func Evaluate(statements []model.PolicyStatement, action string, resource string, context Context) Decision {
+	decision := NotApplicable
+	for _, statement := range statements {
+		if !matchesAny(statement.Actions, action) || !matchesAny(statement.Resources, resource) {
+			continue
+		}
+		if !ConditionsMet(statement.Conditions, context) {
+			continue
+		}
+
+		if statement.Effect == Deny {
+			return Denied
+		}
+		decision = Allowed
+	}
+	return decision
+}
+
+// ConditionsMet tells whether every condition holds. A key missing from the
+// context fails its condition, except for a negated operator: a caller
+// without a clearance attribute does not have clearance "high" either, so a
+// Deny on StringNotEquals still applies to it.
+func ConditionsMet(conditions map[string]map[string][]string, context Context) bool {
+	for name, keys := range conditions {
+		operator, ok := operators[name]
+		if !ok {
+			return false
+		}
+
+		for key, expected := range keys {
+			values, ok := context[key]
+			if !ok || len(values) == 0 {
+				if operator.negated {
+					continue
+				}
+				return false
+			}
+			if !operator.holds(values, expected) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ValidateConditions checks the operators, keys and values of conditions
+// before they are stored.
+func ValidateConditions(conditions map[string]map[string][]string) error {
+	for name, keys := range conditions {
+		operator, ok := operators[name]
+		if !ok {
+			return fmt.Errorf("Unknown condition operator %s", name)
+		}
+
+		for key, expected := range keys {
+			if !validKey(key) {
+				return fmt.Errorf("Unknown condition key %s", key)
+			}
+			if len(expected) == 0 {
+				return fmt.Errorf("Condition %s has no values", key)
+			}
+			for _, value := range expected {
+				if !operator.valid(value) {
+					return fmt.Errorf("Invalid value %s for %s", value, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks a document before it is stored. actions are the names an
+// action pattern has to match at least one of.
+func Validate(document model.PolicyDocument, actions []string) error {
+	if len(document.Statement) == 0 {
+		return fmt.Errorf("Policy has no statement")
+	}
+
+	for i, statement := range document.Statement {
+		if statement.Effect != Allow && statement.Effect != Deny {
+			return fmt.Errorf("Statement %d: effect must be %s or %s", i, Allow, Deny)
+		}
+
+		if len(statement.Actions) == 0 {
+			return fmt.Errorf("Statement %d: actions are required", i)
+		}
+		for _, action := range statement.Actions {
+			if !matchesSome(action, actions) {
+				return fmt.Errorf("Statement %d: unknown action %s", i, action)
+			}
+		}
+
+		if len(statement.Resources) == 0 {
+			return fmt.Errorf("Statement %d: resources are required", i)
+		}
+		for _, resource := range statement.Resources {
+			if !validResource(resource) {
+				return fmt.Errorf("Statement %d: invalid resource %s", i, resource)
+			}
+		}
+
+		if err := ValidateConditions(statement.Conditions); err != nil {
+			return fmt.Errorf("Statement %d: %s", i, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (o operator) holds(values []string, expected []string) bool {
+	for _, value := range values {
+		for _, candidate := range expected {
+			passed := o.test(value, candidate)
+			if o.negated && !passed {
+				return false
+			}
+			if !o.negated && passed {
+				return true
+			}
+		}
+	}
+	return o.negated
+}
+
+func validKey(key string) bool {
+	if name := strings.TrimPrefix(key, KeyUserAttributePrefix); name != key {
+		return name != ""
+	}
+	return contains(ConditionKeys, key)
+}
+
+// inNetwork tests an IP against a CIDR, or a single address.
+func inNetwork(value string, expected string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+
+	if _, network, err := net.ParseCIDR(expected); err == nil {
+		return network.Contains(ip)
+	}
+	return ip.Equal(net.ParseIP(expected))
+}
+
+func validNetwork(expected string) bool {
+	_, _, err := net.ParseCIDR(expected)
+	return err == nil || net.ParseIP(expected) != nil
+}
+
+func compareDates(value string, expected string) int {
+	valueTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0
+	}
+	expectedTime, err := time.Parse(time.RFC3339, expected)
+	if err != nil {
+		return 0
+	}
+
+	switch {
+	case valueTime.After(expectedTime):
+		return 1
+	case valueTime.Before(expectedTime):
+		return -1
+	default:
+		return 0
+	}
+}
+
+func validDate(expected string) bool {
+	_, err := time.Parse(time.RFC3339, expected)
+	return err == nil
+}
+
+// inWindow tests a time of day against a window like 09:00-17:00. A window
+// ending before it starts spans midnight, e.g. 22:00-06:00.
+func inWindow(value string, expected string) bool {
+	start, end, found := strings.Cut(expected, "-")
+	if !found {
+		return false
+	}
+
+	if start <= end {
+		return value >= start && value < end
+	}
+	return value >= start || value < end
+}
+
+func validWindow(expected string) bool {
+	start, end, found := strings.Cut(expected, "-")
+	if !found {
+		return false
+	}
+
+	_, startErr := time.Parse("15:04", start)
+	_, endErr := time.Parse("15:04", end)
+	return startErr == nil && endErr == nil && len(start) == 5 && len(end) == 5
+}
+
+func validBool(expected string) bool {
+	_, err := strconv.ParseBool(expected)
+	return err == nil && (expected == "true" || expected == "false")
+}
+
+func anyValue(expected string) bool {
+	return true
+}
+
+func location() *time.Location {
+	location, err := time.LoadLocation(os.Getenv("POLICY_TIMEZONE"))
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSome(pattern string, values []string) bool {
+	for _, value := range values {
+		if Match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// A resource is * or kind/<id>, where the id may use wildcards.
+func validResource(resource string) bool {
+	if resource == "*" {
+		return true
+	}
+
+	kind, id, found := strings.Cut(resource, "/")
+	return found && id != "" && contains(ResourceKinds, kind)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"balkantask/model"
+	"testing"
+)
+
+const taskId = "2f0e5c1a-8d6b-4c3e-9a57-0b1d2e3f4a5b"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"star matches any resource", "*", "task/" + taskId, true},
+		{"star matches any action", "*", "user:delete", true},
+		{"kind wildcard matches a single resource", "task/*", "task/" + taskId, true},
+		{"kind wildcard matches every resource of the kind", "task/*", "task/*", true},
+		{"single resource does not match every resource", "task/" + taskId, "task/*", false},
+		{"single resource matches itself", "task/" + taskId, "task/" + taskId, true},
+		{"kind wildcard does not match another kind", "task/*", "group/" + taskId, false},
+		{"action wildcard", "task:*", "task:read", true},
+		{"action wildcard needs the separator", "task:*", "tasks:read", false},
+		{"leading wildcard", "*:read", "group:read", true},
+		{"wildcard in the middle", "task/2f0e*4a5b", "task/" + taskId, true},
+		{"empty pattern", "", "task:read", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Match(test.pattern, test.value); got != test.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	allowTask := model.PolicyStatement{Effect: Allow, Actions: []string{"task:*"}, Resources: []string{"task/*"}}
+	denyTask := model.PolicyStatement{Effect: Deny, Actions: []string{"task:write"}, Resources: []string{"task/" + taskId}}
+	allowOne := model.PolicyStatement{Effect: Allow, Actions: []string{"task:read"}, Resources: []string{"task/" + taskId}}
+	allowAll := model.PolicyStatement{Effect: Allow, Actions: []string{"*"}, Resources: []string{"*"}}
+	denyOffice := model.PolicyStatement{
+		Effect:     Deny,
+		Actions:    []string{"*"},
+		Resources:  []string{"*"},
+		Conditions: map[string]map[string][]string{"NotIpAddress": {KeyClientIP: {"10.0.0.0/8"}}},
+	}
+	denyUncleared := model.PolicyStatement{
+		Effect:     Deny,
+		Actions:    []string{"task:*"},
+		Resources:  []string{"task/*"},
+		Conditions: map[string]map[string][]string{"StringNotEquals": {KeyUserAttributePrefix + "clearance": {"high"}}},
+	}
+	allowCleared := model.PolicyStatement{
+		Effect:     Allow,
+		Actions:    []string{"task:*"},
+		Resources:  []string{"task/*"},
+		Conditions: map[string]map[string][]string{"StringEquals": {KeyUserAttributePrefix + "clearance": {"high"}}},
+	}
+
+	tests := []struct {
+		name       string
+		statements []model.PolicyStatement
+		action     string
+		resource   string
+		want       Decision
+	}{
+		{"no statements", nil, "task:read", "task/" + taskId, NotApplicable},
+		{"allow", []model.PolicyStatement{allowTask}, "task:read", "task/" + taskId, Allowed},
+		{"deny wins over an earlier allow", []model.PolicyStatement{allowTask, denyTask}, "task:write", "task/" + taskId, Denied},
+		{"deny wins over a later allow", []model.PolicyStatement{denyTask, allowTask}, "task:write", "task/" + taskId, Denied},
+		{"deny wins over allow on everything", []model.PolicyStatement{allowAll, denyTask}, "task:write", "task/" + taskId, Denied},
+		{"deny on another action", []model.PolicyStatement{allowTask, denyTask}, "task:read", "task/" + taskId, Allowed},
+		{"single resource grant does not cover every task", []model.PolicyStatement{allowOne}, "task:read", "task/*", NotApplicable},
+		{"single resource deny does not cover every task", []model.PolicyStatement{allowTask, denyTask}, "task:write", "task/*", Allowed},
+		{"action outside the statement", []model.PolicyStatement{allowOne}, "task:write", "task/" + taskId, NotApplicable},
+		{"deny whose condition fails", []model.PolicyStatement{allowAll, denyOffice}, "user:read", "user/*", Allowed},
+		{"deny with missing key", []model.PolicyStatement{allowAll, denyUncleared}, "task:read", "task/" + taskId, Denied},
+		{"allow with missing key", []model.PolicyStatement{allowCleared}, "task:read", "task/" + taskId, NotApplicable},
+	}
+
+	context := Context{KeyClientIP: {"10.1.2.3"}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Evaluate(test.statements, test.action, test.resource, context); got != test.want {
+				t.Errorf("Evaluate(%s, %s) = %v, want %v", test.action, test.resource, got, test.want)
+			}
+		})
+	}
+}
+
+func TestConditionsMet(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions map[string]map[string][]string
+		context    Context
+		want       bool
+	}{
+		{
+			"no conditions",
+			nil,
+			Context{},
+			true,
+		},
+		{
+			"missing key fails",
+			map[string]map[string][]string{"StringEquals": {KeyUsername: {"alice"}}},
+			Context{},
+			false,
+		},
+		{
+			"missing key satisfies a negated operator",
+			map[string]map[string][]string{"StringNotEquals": {KeyUserAttributePrefix + "clearance": {"high"}}},
+			Context{KeyUsername: {"alice"}},
+			true,
+		},
+		{
+			"missing key satisfies NotIpAddress",
+			map[string]map[string][]string{"NotIpAddress": {KeyClientIP: {"10.0.0.0/8"}}},
+			Context{},
+			true,
+		},
+		{
+			"IpAddress in one of several networks",
+			map[string]map[string][]string{"IpAddress": {KeyClientIP: {"192.168.0.0/16", "10.0.0.0/8"}}},
+			Context{KeyClientIP: {"10.1.2.3"}},
+			true,
+		},
+		{
+			"IpAddress outside every network",
+			map[string]map[string][]string{"IpAddress": {KeyClientIP: {"192.168.0.0/16", "10.0.0.0/8"}}},
+			Context{KeyClientIP: {"8.8.8.8"}},
+			false,
+		},
+		{
+			"NotIpAddress outside every network",
+			map[string]map[string][]string{"NotIpAddress": {KeyClientIP: {"192.168.0.0/16", "10.0.0.0/8"}}},
+			Context{KeyClientIP: {"8.8.8.8"}},
+			true,
+		},
+		{
+			"NotIpAddress in the second network",
+			map[string]map[string][]string{"NotIpAddress": {KeyClientIP: {"192.168.0.0/16", "10.0.0.0/8"}}},
+			Context{KeyClientIP: {"10.1.2.3"}},
+			false,
+		},
+		{
+			"NotIpAddress equal to a single address",
+			map[string]map[string][]string{"NotIpAddress": {KeyClientIP: {"192.168.0.0/16", "203.0.113.7"}}},
+			Context{KeyClientIP: {"203.0.113.7"}},
+			false,
+		},
+		{
+			"TimeOfDayBetween inside a window",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"09:00-17:00"}}},
+			Context{KeyTimeOfDay: {"09:00"}},
+			true,
+		},
+		{
+			"TimeOfDayBetween end is exclusive",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"09:00-17:00"}}},
+			Context{KeyTimeOfDay: {"17:00"}},
+			false,
+		},
+		{
+			"TimeOfDayBetween before midnight in a window spanning it",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"23:30"}},
+			true,
+		},
+		{
+			"TimeOfDayBetween after midnight in a window spanning it",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"05:59"}},
+			true,
+		},
+		{
+			"TimeOfDayBetween at the end of a window spanning midnight",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"06:00"}},
+			false,
+		},
+		{
+			"TimeOfDayBetween outside a window spanning midnight",
+			map[string]map[string][]string{"TimeOfDayBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"12:00"}},
+			false,
+		},
+		{
+			"TimeOfDayNotBetween outside a window spanning midnight",
+			map[string]map[string][]string{"TimeOfDayNotBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"12:00"}},
+			true,
+		},
+		{
+			"TimeOfDayNotBetween inside a window spanning midnight",
+			map[string]map[string][]string{"TimeOfDayNotBetween": {KeyTimeOfDay: {"22:00-06:00"}}},
+			Context{KeyTimeOfDay: {"00:00"}},
+			false,
+		},
+		{
+			"StringEquals any of several context values",
+			map[string]map[string][]string{"StringEquals": {KeyAuthMethod: {"mfa"}}},
+			Context{KeyAuthMethod: {"pwd", "mfa"}},
+			true,
+		},
+		{
+			"StringNotEquals needs every context value to differ",
+			map[string]map[string][]string{"StringNotEquals": {KeyAuthMethod: {"api_key"}}},
+			Context{KeyAuthMethod: {"pwd", "api_key"}},
+			false,
+		},
+		{
+			"every operator has to hold",
+			map[string]map[string][]string{
+				"IpAddress":    {KeyClientIP: {"10.0.0.0/8"}},
+				"StringEquals": {KeyUsername: {"alice"}},
+			},
+			Context{KeyClientIP: {"10.1.2.3"}, KeyUsername: {"bob"}},
+			false,
+		},
+		{
+			"unknown operator fails",
+			map[string]map[string][]string{"StringSimilar": {KeyUsername: {"alice"}}},
+			Context{KeyUsername: {"alice"}},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ConditionsMet(test.conditions, test.context); got != test.want {
+				t.Errorf("ConditionsMet() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -26,8 +26,178 @@ const (
 	TasksFullAccess  Role = "TASKS_FULL_ACCESS"
 )
 
-func UserIsAuthorized(roles []model.Role, group []model.Group, targetRoles []Role) bool {
+// Role types. SYSTEM roles are seeded and cannot be changed, CUSTOM roles
+// belong to an org.
+const (
+	SystemType = "SYSTEM"
+	CustomType = "CUSTOM"
+)
+
+// SystemRoles lists the roles seeded by /api/roles/seed.
+var SystemRoles = []Role{
+	OrgFullAccess, OrgWriteAccess, OrgReadAccess,
+	UserFullAccess, UserWriteAccess, UserReadAccess,
+	RoleFullAccess, RoleWriteAccess, RoleReadAccess,
+	GroupFullAccess, GroupWriteAccess, GroupReadAccess,
+	TasksFullAccess, TasksWriteAccess, TasksReadAccess,
+}
+
+// systemIncludes is the hierarchy of the SYSTEM roles, e.g. USER_FULL_ACCESS
+// includes USER_WRITE_ACCESS, which includes USER_READ_ACCESS.
+var systemIncludes = map[Role][]Role{
+	OrgFullAccess:    {OrgWriteAccess},
+	OrgWriteAccess:   {OrgReadAccess, UserFullAccess, RoleFullAccess, GroupFullAccess, TasksFullAccess},
+	OrgReadAccess:    {UserReadAccess, RoleReadAccess, GroupReadAccess, TasksReadAccess},
+	UserFullAccess:   {UserWriteAccess},
+	UserWriteAccess:  {UserReadAccess},
+	RoleFullAccess:   {RoleWriteAccess},
+	RoleWriteAccess:  {RoleReadAccess},
+	GroupFullAccess:  {GroupWriteAccess},
+	GroupWriteAccess: {GroupReadAccess},
+	TasksFullAccess:  {TasksWriteAccess},
+	TasksWriteAccess: {TasksReadAccess},
+}
+
+// SystemClosure lists a SYSTEM role with every role it includes, directly or
+// not.
+func SystemClosure(role Role) []Role {
+	closure := []Role{role}
+	for i := 0; i < len(closure); i++ {
+		for _, included := range systemIncludes[closure[i]] {
+			if !containsRole(closure, included) {
+				closure = append(closure, included)
+			}
+		}
+	}
+	return closure
+}
+
+// Graph holds the roles available to an org, to expand what they include.
+type Graph struct {
+	byId   map[uuid.UUID]model.Role
+	byName map[string]model.Role
+}
+
+// NewGraph indexes roles loaded with their Includes.
+func NewGraph(available []model.Role) Graph {
+	graph := Graph{byId: map[uuid.UUID]model.Role{}, byName: map[string]model.Role{}}
+	for _, role := range available {
+		graph.byId[role.ID] = role
+		graph.byName[role.Name] = role
+	}
+	return graph
+}
+
+// ByName finds a role available to the org.
+func (g Graph) ByName(name string) (model.Role, bool) {
+	role, ok := g.byName[name]
+	return role, ok
+}
+
+// Includes lists the roles a role includes directly: the built-in ones of a
+// SYSTEM role or the stored ones of a CUSTOM role.
+func (g Graph) Includes(role model.Role) []model.Role {
+	if role.OrgID == nil {
+		included := []model.Role{}
+		for _, name := range systemIncludes[Role(role.Name)] {
+			systemRole, ok := g.byName[string(name)]
+			if !ok {
+				// Not seeded, its permissions are still known by name
+				systemRole = model.Role{Name: string(name), Type: SystemType}
+			}
+			included = append(included, systemRole)
+		}
+		return included
+	}
+
+	if stored, ok := g.byId[role.ID]; ok {
+		return stored.Includes
+	}
+	return role.Includes
+}
+
+// Expand lists the roles together with every role they include, directly or
+// not, without duplicates.
+func (g Graph) Expand(held []model.Role) []model.Role {
+	expanded := []model.Role{}
+	seen := map[string]bool{}
+	queue := append([]model.Role{}, held...)
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		if seen[role.Name] {
+			continue
+		}
+		seen[role.Name] = true
+
+		expanded = append(expanded, role)
+		queue = append(queue, g.Includes(role)...)
+	}
+	return expanded
+}
+
+// Reaches tells whether the target is one of the roles or included by them,
+// i.e. whether a role including them would form a cycle.
+func (g Graph) Reaches(from []model.Role, target uuid.UUID) bool {
+	for _, role := range g.Expand(from) {
+		if role.ID == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Narrow keeps the held roles that are allowed or include an allowed role,
+// e.g. the roles of a user that matter to an API key restricted to some.
+func (g Graph) Narrow(held []model.Role, allowed []model.Role) []model.Role {
+	narrowed := []model.Role{}
+	for _, role := range held {
+		for _, allowedRole := range allowed {
+			if g.Reaches([]model.Role{role}, allowedRole.ID) {
+				narrowed = append(narrowed, role)
+				break
+			}
+		}
+	}
+	return narrowed
+}
+
+// Restrict expands the held roles and keeps those the allowed roles grant,
+// directly or through the roles they include.
+func (g Graph) Restrict(held []model.Role, allowed []model.Role) []model.Role {
+	allowedNames := map[string]bool{}
+	for _, role := range g.Expand(allowed) {
+		allowedNames[role.Name] = true
+	}
+
+	restricted := []model.Role{}
+	for _, role := range g.Expand(held) {
+		if allowedNames[role.Name] {
+			restricted = append(restricted, role)
+		}
+	}
+	return restricted
+}
+
+// IsSystemRole tells whether a name is taken by one of the SYSTEM roles.
+func IsSystemRole(name string) bool {
+	for _, role := range SystemRoles {
+		if string(role) == name {
+			return true
+		}
+	}
+	return false
+}
 
+// AvailableTo tells whether a role can be used in an org: SYSTEM roles are
+// shared, CUSTOM roles only belong to the org that created them.
+func AvailableTo(role model.Role, orgId uuid.UUID) bool {
+	return role.OrgID == nil || *role.OrgID == orgId
+}
+
+// EffectiveRoles flattens the roles held directly and through groups into a
+// single de-duplicated list.
+func EffectiveRoles(roles []model.Role, group []model.Group) []model.Role {
 	userRoles := []model.Role{}
 	for _, role := range roles {
 		userRoles = append(userRoles, role)
@@ -38,16 +208,7 @@ func UserIsAuthorized(roles []model.Role, group []model.Group, targetRoles []Rol
 		}
 	}
 
-	uniqueRoles := RemoveDuplicates(userRoles)
-
-	for _, targetRole := range targetRoles {
-		for _, role := range uniqueRoles {
-			if Role(role.Name) == targetRole {
-				return true
-			}
-		}
-	}
-	return false
+	return RemoveDuplicates(userRoles)
 }
 
 func UserHasRole(roles []model.Role, targetRole model.Role) bool {
@@ -115,6 +276,15 @@ func UserHasTaskAuthorization(roles []model.Role, group []model.Group, targetTas
 	return false
 }
 
+func containsRole(roles []Role, target Role) bool {
+	for _, role := range roles {
+		if role == target {
+			return true
+		}
+	}
+	return false
+}
+
 func RemoveDuplicates(rolesExist []model.Role) []model.Role {
 
 	uniqueRolesMap := make(map[uuid.UUID]struct{})
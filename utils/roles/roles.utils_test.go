@@ -0,0 +1,195 @@
+package roles
+
+import (
+	"balkantask/model"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func customRole(orgId uuid.UUID, name string, includes ...model.Role) model.Role {
+	role := model.Role{Name: name, Type: CustomType, OrgID: &orgId, Includes: includes}
+	role.ID = uuid.New()
+	return role
+}
+
+func systemRole(name Role) model.Role {
+	role := model.Role{Name: string(name), Type: SystemType}
+	role.ID = uuid.New()
+	return role
+}
+
+func TestGraphReaches(t *testing.T) {
+	orgId := uuid.New()
+
+	userFull := systemRole(UserFullAccess)
+	userWrite := systemRole(UserWriteAccess)
+	userRead := systemRole(UserReadAccess)
+
+	// auditor -> reviewer -> approver
+	approver := customRole(orgId, "APPROVER")
+	reviewer := customRole(orgId, "REVIEWER", approver)
+	auditor := customRole(orgId, "AUDITOR", reviewer)
+
+	// support -> helpdesk, helpdesk -> USER_FULL_ACCESS
+	helpdesk := customRole(orgId, "HELPDESK", userFull)
+	support := customRole(orgId, "SUPPORT", helpdesk)
+
+	standalone := customRole(orgId, "STANDALONE")
+
+	graph := NewGraph([]model.Role{userFull, userWrite, userRead, approver, reviewer, auditor, helpdesk, support, standalone})
+
+	// Reaches(includes, role) is how a role update is checked for cycles
+	tests := []struct {
+		name   string
+		from   []model.Role
+		target model.Role
+		want   bool
+	}{
+		{"a role including itself", []model.Role{standalone}, standalone, true},
+		{"direct include", []model.Role{support}, helpdesk, true},
+		{"indirect include", []model.Role{support}, userFull, true},
+		{"through the SYSTEM hierarchy", []model.Role{support}, userRead, true},
+		{"includes are not followed backwards", []model.Role{helpdesk}, support, false},
+		{"unrelated role", []model.Role{support}, standalone, false},
+		{"approver including auditor closes an indirect cycle", []model.Role{auditor}, approver, true},
+		{"reviewer including auditor closes a direct cycle", []model.Role{auditor}, reviewer, true},
+		{"auditor including approver is no cycle", []model.Role{approver}, auditor, false},
+		{"any of several roles", []model.Role{standalone, auditor}, approver, true},
+		{"no roles", nil, standalone, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := graph.Reaches(test.from, test.target.ID); got != test.want {
+				t.Errorf("Reaches(%s) = %v, want %v", test.target.Name, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGraphExpandTerminatesOnCycles(t *testing.T) {
+	orgId := uuid.New()
+
+	// A role stored including itself
+	self := customRole(orgId, "SELF")
+	self.Includes = []model.Role{self}
+
+	first := customRole(orgId, "FIRST")
+	second := customRole(orgId, "SECOND", first)
+	first.Includes = []model.Role{second}
+
+	graph := NewGraph([]model.Role{self, first, second})
+
+	tests := []struct {
+		name string
+		held []model.Role
+		want int
+	}{
+		{"self cycle", []model.Role{self}, 1},
+		{"two role cycle", []model.Role{first}, 2},
+		{"held twice", []model.Role{first, second}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := len(graph.Expand(test.held)); got != test.want {
+				t.Errorf("len(Expand()) = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGraphAPIKeyWithInheritedRole(t *testing.T) {
+	orgId := uuid.New()
+
+	tasksFull := systemRole(TasksFullAccess)
+	tasksWrite := systemRole(TasksWriteAccess)
+	tasksRead := systemRole(TasksReadAccess)
+	userRead := systemRole(UserReadAccess)
+	planner := customRole(orgId, "PLANNER", tasksFull)
+
+	graph := NewGraph([]model.Role{tasksFull, tasksWrite, tasksRead, userRead, planner})
+
+	names := func(roles []model.Role) []string {
+		names := []string{}
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name       string
+		held       []model.Role
+		keyRoles   []model.Role
+		canCreate  bool
+		narrowed   []string
+		restricted []string
+	}{
+		{
+			"key restricted to an inherited role",
+			[]model.Role{tasksFull},
+			[]model.Role{tasksRead},
+			true,
+			[]string{"TASKS_FULL_ACCESS"},
+			[]string{"TASKS_READ_ACCESS"},
+		},
+		{
+			"key restricted to a role inherited through a CUSTOM role",
+			[]model.Role{planner, userRead},
+			[]model.Role{tasksWrite},
+			true,
+			[]string{"PLANNER"},
+			[]string{"TASKS_WRITE_ACCESS", "TASKS_READ_ACCESS"},
+		},
+		{
+			"key restricted to a held role",
+			[]model.Role{tasksFull, userRead},
+			[]model.Role{userRead},
+			true,
+			[]string{"USER_READ_ACCESS"},
+			[]string{"USER_READ_ACCESS"},
+		},
+		{
+			"key restricted to a role above the held one",
+			[]model.Role{tasksRead},
+			[]model.Role{tasksFull},
+			false,
+			[]string{},
+			[]string{"TASKS_READ_ACCESS"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canCreate := true
+			for _, role := range test.keyRoles {
+				canCreate = canCreate && graph.Reaches(test.held, role.ID)
+			}
+			if canCreate != test.canCreate {
+				t.Errorf("Reaches() = %v, want %v", canCreate, test.canCreate)
+			}
+
+			if got := names(graph.Narrow(test.held, test.keyRoles)); !equalNames(got, test.narrowed) {
+				t.Errorf("Narrow() = %v, want %v", got, test.narrowed)
+			}
+
+			if got := names(graph.Restrict(test.held, test.keyRoles)); !equalNames(got, test.restricted) {
+				t.Errorf("Restrict() = %v, want %v", got, test.restricted)
+			}
+		})
+	}
+}
+
+func equalNames(got []string, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,18 @@
 package schedulers
 
 import (
+	"balkantask/config"
+	ldapRepo "balkantask/database/ldap"
+	loginFailureRepo "balkantask/database/loginFailure"
+	oauthRepo "balkantask/database/oauth"
 	orgRepo "balkantask/database/org"
+	sessionRepo "balkantask/database/session"
+	tokensRepo "balkantask/database/tokens"
 	userRepo "balkantask/database/user"
+	constants "balkantask/utils"
+	"balkantask/utils/ldapSync"
+	"balkantask/utils/lockout"
+	"balkantask/utils/tokens"
 	"fmt"
 	"time"
 )
@@ -73,6 +83,122 @@ func deleteAccountsData() {
 
 }
 
+func deleteUnverifiedOrgs() {
+	fmt.Println("Deleting orgs with unverified emails at", time.Now())
+	threshold := time.Now().Add(-config.Duration("UNVERIFIED_ORG_TTL", 7*24*time.Hour))
+
+	// Find the orgs still waiting for email verification since before the threshold
+	orgs, err := orgRepo.GetUnverifiedOrgsForThreshold(threshold)
+	if err != nil {
+		fmt.Println("Error getting unverified orgs:", err)
+		return
+	}
+
+	if len(orgs) == 0 {
+		return
+	}
+
+	err = orgRepo.DeleteOrgs(orgs)
+	if err != nil {
+		fmt.Println("Error deleting unverified orgs:", err)
+		return
+	}
+}
+
+func deleteExpiredRefreshTokens() {
+	fmt.Println("Deleting expired refresh tokens at", time.Now())
+
+	err := tokensRepo.DeleteExpiredRefreshTokens(time.Now())
+	if err != nil {
+		fmt.Println("Error deleting refresh tokens:", err)
+		return
+	}
+}
+
+func deleteExpiredRevocations() {
+	fmt.Println("Deleting expired token revocations at", time.Now())
+
+	err := tokensRepo.DeleteExpiredRevocations(time.Now())
+	if err != nil {
+		fmt.Println("Error deleting token revocations:", err)
+		return
+	}
+}
+
+func rotateSigningKeys() {
+	fmt.Println("Checking JWT signing key rotation at", time.Now())
+
+	err := tokens.RotateKeysIfDue()
+	if err != nil {
+		fmt.Println("Error rotating signing keys:", err)
+		return
+	}
+}
+
+func deleteExpiredAuthorizationCodes() {
+	fmt.Println("Deleting expired authorization codes at", time.Now())
+
+	err := oauthRepo.DeleteExpiredAuthorizationCodes(time.Now())
+	if err != nil {
+		fmt.Println("Error deleting authorization codes:", err)
+		return
+	}
+}
+
+func deleteExpiredPasswordResetTokens() {
+	fmt.Println("Deleting expired password reset tokens at", time.Now())
+
+	err := tokensRepo.DeleteExpiredPasswordResetTokens(time.Now())
+	if err != nil {
+		fmt.Println("Error deleting password reset tokens:", err)
+		return
+	}
+}
+
+func deleteStaleLoginFailures() {
+	fmt.Println("Deleting stale login failures at", time.Now())
+
+	err := loginFailureRepo.DeleteStaleLoginFailures(time.Now().Add(-lockout.Duration()))
+	if err != nil {
+		fmt.Println("Error deleting login failures:", err)
+		return
+	}
+}
+
+func deleteEndedSessions() {
+	fmt.Println("Deleting ended sessions at", time.Now())
+
+	// Kept until the last access token of the session has expired
+	err := sessionRepo.DeleteEndedSessions(time.Now().Add(-tokens.AccessTokenTTL()))
+	if err != nil {
+		fmt.Println("Error deleting sessions:", err)
+		return
+	}
+}
+
+func syncLDAPDirectories() {
+	fmt.Println("Syncing LDAP directories at", time.Now())
+
+	connectors, err := ldapRepo.FindScheduledLDAPConnectors()
+	if err != nil {
+		fmt.Println("Error getting LDAP connectors:", err)
+		return
+	}
+
+	for _, connector := range connectors {
+		if !tokens.PrincipalIsActive(connector.OrgID, constants.ORG) {
+			continue
+		}
+
+		result, err := ldapSync.Run(connector)
+		if err != nil {
+			fmt.Println("Error syncing LDAP directory of org", connector.OrgID, ":", err)
+			continue
+		}
+		fmt.Printf("Synced LDAP directory of org %s: %d created, %d updated, %d deactivated\n", connector.OrgID, result.Created, result.Updated, result.Deactivated)
+	}
+}
+
 func Scheduler() {
 	for {
 		now := time.Now()
@@ -87,5 +213,23 @@ func Scheduler() {
 		go deleteDeactivatedUser()
 		go markAccountDeleted()
 		go deleteAccountsData()
+		go deleteExpiredRefreshTokens()
+		go deleteExpiredRevocations()
+		go rotateSigningKeys()
+		go deleteExpiredAuthorizationCodes()
+		go deleteExpiredPasswordResetTokens()
+		go deleteStaleLoginFailures()
+		go deleteEndedSessions()
+		go deleteUnverifiedOrgs()
+	}
+}
+
+// LDAPSyncScheduler syncs directories every LDAP_SYNC_INTERVAL, which is
+// usually more often than the daily jobs above.
+func LDAPSyncScheduler() {
+	for {
+		time.Sleep(config.Duration("LDAP_SYNC_INTERVAL", time.Hour))
+
+		syncLDAPDirectories()
 	}
 }
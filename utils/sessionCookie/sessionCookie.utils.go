@@ -0,0 +1,134 @@
+package sessionCookie
+
+import (
+	"balkantask/utils/tokens"
+	"crypto/subtle"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	AccessTokenCookie  = "token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+
+	// The refresh token is only ever sent back to the refresh endpoint
+	refreshTokenPath = "/api/auth/refresh"
+)
+
+// Requested tells whether the client asked for a cookie session instead of
+// tokens in the response body, with ?session=cookie on a login request.
+func Requested(c *fiber.Ctx) bool {
+	return c.Query("session") == "cookie"
+}
+
+// Domain is the COOKIE_DOMAIN the session cookies are set for. Empty keeps
+// them on the exact host that served the login.
+func Domain() string {
+	return os.Getenv("COOKIE_DOMAIN")
+}
+
+// Secure reads COOKIE_SECURE, defaulting to whether the issuer is served
+// over https. SameSite=None cookies are always secure, browsers drop them
+// otherwise.
+func Secure() bool {
+	if SameSite() == fiber.CookieSameSiteNoneMode {
+		return true
+	}
+
+	secure, err := strconv.ParseBool(os.Getenv("COOKIE_SECURE"))
+	if err != nil {
+		return strings.HasPrefix(tokens.Issuer(), "https://")
+	}
+
+	return secure
+}
+
+// SameSite reads COOKIE_SAMESITE (Lax, Strict or None), defaulting to Lax.
+func SameSite() string {
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "strict":
+		return fiber.CookieSameSiteStrictMode
+	case "none":
+		return fiber.CookieSameSiteNoneMode
+	default:
+		return fiber.CookieSameSiteLaxMode
+	}
+}
+
+// NewCSRFToken returns the token handed to the browser and the hash that is
+// put in the access token, binding the two together.
+func NewCSRFToken() (string, string, error) {
+	return tokens.GenerateOpaqueToken()
+}
+
+// Set stores a token pair in HttpOnly cookies. The CSRF token is readable by
+// scripts so it can be echoed back in the X-CSRF-Token header.
+func Set(c *fiber.Ctx, tokenPair tokens.TokenPair, csrfToken string) {
+	now := time.Now()
+	refreshExpires := now.Add(tokens.RefreshTokenTTL())
+
+	c.Cookie(cookie(AccessTokenCookie, tokenPair.AccessToken, "/", now.Add(time.Duration(tokenPair.ExpiresIn)*time.Second), true))
+	c.Cookie(cookie(RefreshTokenCookie, tokenPair.RefreshToken, refreshTokenPath, refreshExpires, true))
+	c.Cookie(cookie(CSRFCookie, csrfToken, "/", refreshExpires, false))
+}
+
+// Clear expires all session cookies.
+func Clear(c *fiber.Ctx) {
+	expired := time.Unix(0, 0)
+
+	c.Cookie(cookie(AccessTokenCookie, "", "/", expired, true))
+	c.Cookie(cookie(RefreshTokenCookie, "", refreshTokenPath, expired, true))
+	c.Cookie(cookie(CSRFCookie, "", "/", expired, false))
+}
+
+// ValidHeader checks the X-CSRF-Token header against the hash carried in the
+// access token.
+func ValidHeader(c *fiber.Ctx, hash string) bool {
+	header := c.Get(CSRFHeader)
+	if header == "" || hash == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(tokens.HashToken(header)), []byte(hash)) == 1
+}
+
+// ValidDoubleSubmit checks the X-CSRF-Token header against the CSRF cookie,
+// for requests that carry no access token, i.e. a refresh.
+func ValidDoubleSubmit(c *fiber.Ctx) bool {
+	header, cookie := c.Get(CSRFHeader), c.Cookies(CSRFCookie)
+	if header == "" || cookie == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie)) == 1
+}
+
+// SafeMethod tells whether a request cannot change state and so needs no
+// CSRF token.
+func SafeMethod(c *fiber.Ctx) bool {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func cookie(name string, value string, path string, expires time.Time, httpOnly bool) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   Domain(),
+		Expires:  expires,
+		HTTPOnly: httpOnly,
+		Secure:   Secure(),
+		SameSite: SameSite(),
+	}
+}
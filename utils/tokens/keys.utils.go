@@ -0,0 +1,484 @@
+package tokens
+
+import (
+	"balkantask/config"
+	tokensRepo "balkantask/database/tokens"
+	"balkantask/model"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+type signingKey struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+var (
+	keyRingMutex sync.RWMutex
+	keyRing      = map[string]*signingKey{}
+	activeKid    string
+)
+
+// An unknown kid makes lookupKey read the keys from the database. To keep
+// forged kids from turning every request into a query, the ring is reloaded
+// at most once per keyReloadInterval and a kid that is still missing after a
+// reload is not looked for again until missingKidTTL has passed.
+const (
+	keyReloadInterval = 10 * time.Second
+	missingKidTTL     = 5 * time.Minute
+	maxMissingKids    = 1024
+)
+
+var (
+	reloadMutex  sync.Mutex
+	lastReloadAt time.Time
+	missingKids  = map[string]time.Time{}
+)
+
+var supportedAlgorithms = []string{"RS256", "ES256", "EdDSA"}
+
+func SigningAlgorithm() string {
+	algorithm := os.Getenv("JWT_SIGNING_ALGORITHM")
+	if algorithm == "" {
+		return "RS256"
+	}
+	return algorithm
+}
+
+func KeyRotationInterval() time.Duration {
+	return config.Duration("JWT_KEY_ROTATION", 30*24*time.Hour)
+}
+
+// KeyGracePeriod is how long a retired key keeps verifying tokens. It never
+// drops below the access token lifetime so rotation cannot log anyone out.
+func KeyGracePeriod() time.Duration {
+	grace := config.Duration("JWT_KEY_GRACE", 7*24*time.Hour)
+	if grace < AccessTokenTTL() {
+		return AccessTokenTTL()
+	}
+	return grace
+}
+
+// LoadKeys fills the in-memory key ring from the database and creates the
+// first signing key if there is none yet.
+func LoadKeys() error {
+	algorithm := SigningAlgorithm()
+	if !isSupportedAlgorithm(algorithm) {
+		return fmt.Errorf("unsupported JWT_SIGNING_ALGORITHM %q", algorithm)
+	}
+
+	if _, err := keyCipher(); err != nil {
+		return err
+	}
+
+	if err := encryptPlaintextKeys(); err != nil {
+		return err
+	}
+
+	if err := reloadKeys(); err != nil {
+		return err
+	}
+
+	keyRingMutex.RLock()
+	active, ok := keyRing[activeKid]
+	keyRingMutex.RUnlock()
+
+	if !ok || active.Algorithm != algorithm {
+		return rotateKeys(time.Now().Add(-KeyRotationInterval()))
+	}
+
+	return nil
+}
+
+// RotateKeysIfDue rotates the active signing key once it is older than the
+// rotation interval and drops retired keys past their grace period.
+func RotateKeysIfDue() error {
+	if err := reloadKeys(); err != nil {
+		return err
+	}
+
+	keyRingMutex.RLock()
+	active, ok := keyRing[activeKid]
+	keyRingMutex.RUnlock()
+
+	dueBefore := time.Now().Add(-KeyRotationInterval())
+	if !ok || active.CreatedAt.Before(dueBefore) {
+		if err := rotateKeys(dueBefore); err != nil {
+			return err
+		}
+	}
+
+	if err := tokensRepo.DeleteExpiredSigningKeys(time.Now()); err != nil {
+		return err
+	}
+
+	return reloadKeys()
+}
+
+// rotateKeys generates a new active signing key and retires the previous ones
+// if the active key was created before dueBefore. The check is repeated while
+// the active key is locked, so a rotation another instance just made is kept.
+func rotateKeys(dueBefore time.Time) error {
+	algorithm := SigningAlgorithm()
+
+	privateKey, err := generatePrivateKey(algorithm)
+	if err != nil {
+		return err
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	publicDer, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return err
+	}
+
+	kid := uuid.NewString()
+	encryptedKey, err := encryptPrivateKey(kid, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}))
+	if err != nil {
+		return err
+	}
+
+	_, err = tokensRepo.RotateSigningKey(model.SigningKey{
+		Kid:        kid,
+		Algorithm:  algorithm,
+		PrivateKey: encryptedKey,
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})),
+	}, dueBefore, time.Now().Add(KeyGracePeriod()))
+	if err != nil {
+		return err
+	}
+
+	return reloadKeys()
+}
+
+// SignToken signs the claims with the active key and sets the kid header.
+func SignToken(claims jwt.MapClaims) (string, error) {
+	keyRingMutex.RLock()
+	key, ok := keyRing[activeKid]
+	keyRingMutex.RUnlock()
+
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
+
+	tokenByte := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	tokenByte.Header["kid"] = key.Kid
+
+	return tokenByte.SignedString(key.PrivateKey)
+}
+
+// ParseToken verifies a token signed by any key in the ring.
+func ParseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(jwtToken *jwt.Token) (interface{}, error) {
+		kid, _ := jwtToken.Header["kid"].(string)
+
+		key, ok := lookupKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+
+		if jwtToken.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %s", jwtToken.Header["alg"])
+		}
+
+		return key.PublicKey, nil
+	}, jwt.WithValidMethods(supportedAlgorithms))
+}
+
+// PublicJWKs returns the public half of every key in the ring.
+func PublicJWKs() []JWK {
+	keyRingMutex.RLock()
+	defer keyRingMutex.RUnlock()
+
+	jwks := []JWK{}
+	for _, key := range keyRing {
+		jwk := JWK{Kid: key.Kid, Use: "sig", Alg: key.Algorithm}
+
+		switch publicKey := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = publicKey.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// lookupKey finds a key by kid, reloading the ring in case another instance
+// rotated keys since we last loaded them.
+func lookupKey(kid string) (*signingKey, bool) {
+	key, ok := ringKey(kid)
+	if ok || kid == "" {
+		return key, ok
+	}
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	// another request may have reloaded the ring while this one waited
+	if key, ok := ringKey(kid); ok {
+		return key, ok
+	}
+
+	if missedAt, missed := missingKids[kid]; missed && time.Since(missedAt) < missingKidTTL {
+		return nil, false
+	}
+
+	if time.Since(lastReloadAt) < keyReloadInterval {
+		return nil, false
+	}
+
+	lastReloadAt = time.Now()
+	if err := reloadKeys(); err != nil {
+		return nil, false
+	}
+
+	key, ok = ringKey(kid)
+	if !ok {
+		if len(missingKids) >= maxMissingKids {
+			missingKids = map[string]time.Time{}
+		}
+		missingKids[kid] = time.Now()
+	}
+
+	return key, ok
+}
+
+func ringKey(kid string) (*signingKey, bool) {
+	keyRingMutex.RLock()
+	defer keyRingMutex.RUnlock()
+
+	key, ok := keyRing[kid]
+	return key, ok
+}
+
+func reloadKeys() error {
+	storedKeys, err := tokensRepo.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	ring := map[string]*signingKey{}
+	newActiveKid := ""
+	now := time.Now()
+
+	for _, storedKey := range storedKeys {
+		if storedKey.ExpiresAt != nil && storedKey.ExpiresAt.Before(now) {
+			continue
+		}
+
+		key, err := parseSigningKey(storedKey)
+		if err != nil {
+			return err
+		}
+
+		ring[key.Kid] = key
+		// keys are ordered by creation, so the newest unretired key wins
+		if key.RetiredAt == nil {
+			newActiveKid = key.Kid
+		}
+	}
+
+	keyRingMutex.Lock()
+	keyRing = ring
+	activeKid = newActiveKid
+	keyRingMutex.Unlock()
+
+	return nil
+}
+
+func parseSigningKey(storedKey model.SigningKey) (*signingKey, error) {
+	privatePem, err := decryptPrivateKey(storedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(privatePem)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key for kid %s", storedKey.Kid)
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key for kid %s", storedKey.Kid)
+	}
+
+	createdAt := time.Time{}
+	if storedKey.CreatedAt != nil {
+		createdAt = *storedKey.CreatedAt
+	}
+
+	return &signingKey{
+		Kid:        storedKey.Kid,
+		Algorithm:  storedKey.Algorithm,
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.Public(),
+		CreatedAt:  createdAt,
+		RetiredAt:  storedKey.RetiredAt,
+	}, nil
+}
+
+// Private keys are stored encrypted with AES-256-GCM under
+// JWT_KEY_ENCRYPTION_KEY, a base64 encoded 32 byte key. The kid is
+// authenticated with the key, so a stored key cannot be moved to another row.
+func keyCipher() (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY must be a base64 encoded 32 byte key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func encryptPrivateKey(kid string, privatePem []byte) (string, error) {
+	aead, err := keyCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, privatePem, []byte(kid))), nil
+}
+
+func decryptPrivateKey(storedKey model.SigningKey) ([]byte, error) {
+	// written by an instance that did not encrypt keys yet
+	if isPlaintextKey(storedKey) {
+		return []byte(storedKey.PrivateKey), nil
+	}
+
+	aead, err := keyCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(storedKey.PrivateKey)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid private key for kid %s", storedKey.Kid)
+	}
+
+	privatePem, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(storedKey.Kid))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt private key for kid %s", storedKey.Kid)
+	}
+
+	return privatePem, nil
+}
+
+// encryptPlaintextKeys encrypts the private keys stored as plain PEM before
+// keys were encrypted at rest.
+func encryptPlaintextKeys() error {
+	storedKeys, err := tokensRepo.GetSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, storedKey := range storedKeys {
+		if !isPlaintextKey(storedKey) {
+			continue
+		}
+
+		encryptedKey, err := encryptPrivateKey(storedKey.Kid, []byte(storedKey.PrivateKey))
+		if err != nil {
+			return err
+		}
+
+		if err := tokensRepo.UpdateSigningKeyPrivateKey(storedKey.Kid, encryptedKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isPlaintextKey(storedKey model.SigningKey) bool {
+	return strings.HasPrefix(storedKey.PrivateKey, "-----BEGIN")
+}
+
+func generatePrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+func isSupportedAlgorithm(algorithm string) bool {
+	for _, supported := range supportedAlgorithms {
+		if supported == algorithm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,541 @@
+package tokens
+
+import (
+	"balkantask/config"
+	orgRepo "balkantask/database/org"
+	serviceAccountRepo "balkantask/database/serviceAccount"
+	sessionRepo "balkantask/database/session"
+	tokensRepo "balkantask/database/tokens"
+	userRepo "balkantask/database/user"
+	"balkantask/model"
+	constants "balkantask/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+}
+
+// TokenRequest describes who a token pair is issued to. ClientID and Scope are
+// only set when the tokens are issued to an OAuth client. AuthMethods ends up
+// in the amr claim, e.g. pwd, mfa, otp. UserAgent and IP describe the device
+// the session is shown as. Actor is only set for impersonation. CSRFHash is
+// only set for cookie sessions and binds the CSRF token to the access token.
+type TokenRequest struct {
+	Subject     uuid.UUID
+	SubjectType constants.PrincipalType
+	FamilyID    uuid.UUID
+	ClientID    string
+	Scope       string
+	AuthMethods []string
+	UserAgent   string
+	IP          string
+	Actor       *Actor
+	CSRFHash    string
+}
+
+// Actor is the principal acting on behalf of the subject of a token, i.e. the
+// admin impersonating a user. It is carried in the act claim.
+type Actor struct {
+	ID   uuid.UUID
+	Type constants.PrincipalType
+}
+
+// Every JWT we sign carries a token_use claim so a token minted for one
+// purpose cannot be presented for another.
+const (
+	AccessTokenUse    = "access"
+	MFAChallengeUse   = "mfa"
+	MFAEnrollmentUse  = "mfa_enroll"
+	EmailVerifyUse    = "email_verify"
+	PasswordChangeUse = "password_change"
+	challengeTokenTTL = 5 * time.Minute
+)
+
+// Longer user agents are cut to fit the session record
+const maxUserAgentLength = 255
+
+var (
+	ErrInvalidRefreshToken      = errors.New("invalid refresh token")
+	ErrRefreshTokenReused       = errors.New("refresh token reuse detected")
+	ErrRefreshTokenExpired      = errors.New("refresh token expired")
+	ErrPrincipalInactive        = errors.New("account is not active")
+	ErrInvalidChallengeToken    = errors.New("invalid mfa token")
+	ErrInvalidAccessToken       = errors.New("invalid access token")
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+)
+
+// Issuer is the public base URL of this service, used as the iss claim.
+func Issuer() string {
+	issuer := os.Getenv("ISSUER_URL")
+	if issuer == "" {
+		return "http://localhost:3000"
+	}
+	return strings.TrimSuffix(issuer, "/")
+}
+
+func AccessTokenTTL() time.Duration {
+	return config.Duration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+func RefreshTokenTTL() time.Duration {
+	return config.Duration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func PasswordResetTTL() time.Duration {
+	return config.Duration("PASSWORD_RESET_TTL", 30*time.Minute)
+}
+
+func EmailVerificationTTL() time.Duration {
+	return config.Duration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+func ImpersonationTTL() time.Duration {
+	return config.Duration("IMPERSONATION_TTL", 15*time.Minute)
+}
+
+// GenerateAccessToken signs a short-lived JWT for the request. The sid claim
+// ties the token to its refresh token family so logout can end both, and
+// principal_type tells CheckJWT where to look the subject up.
+func GenerateAccessToken(request TokenRequest) (string, error) {
+	now := time.Now().UTC()
+
+	ttl := AccessTokenTTL()
+	if request.Actor != nil {
+		ttl = ImpersonationTTL()
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            Issuer(),
+		"sub":            request.Subject,
+		"jti":            uuid.New(),
+		"sid":            request.FamilyID,
+		"principal_type": request.SubjectType,
+		"token_use":      AccessTokenUse,
+		"exp":            now.Add(ttl).Unix(),
+		"iat":            now.Unix(),
+		"nbf":            now.Unix(),
+	}
+
+	if request.Actor != nil {
+		claims["act"] = map[string]interface{}{
+			"sub":            request.Actor.ID,
+			"principal_type": request.Actor.Type,
+		}
+	}
+
+	if len(request.AuthMethods) > 0 {
+		claims["amr"] = request.AuthMethods
+	}
+
+	if request.CSRFHash != "" {
+		claims["csrf"] = request.CSRFHash
+	}
+
+	if request.ClientID != "" {
+		claims["aud"] = request.ClientID
+		claims["client_id"] = request.ClientID
+		claims["scope"] = request.Scope
+	}
+
+	return SignToken(claims)
+}
+
+// GenerateChallengeToken signs a short-lived token proving the password was
+// correct. It is only accepted by the endpoints matching its use: the MFA
+// login steps, or the change of a password set by an admin.
+func GenerateChallengeToken(subject uuid.UUID, subjectType constants.PrincipalType, use string) (string, error) {
+	now := time.Now().UTC()
+
+	return SignToken(jwt.MapClaims{
+		"iss":            Issuer(),
+		"sub":            subject,
+		"jti":            uuid.New(),
+		"principal_type": subjectType,
+		"token_use":      use,
+		"exp":            now.Add(challengeTokenTTL).Unix(),
+		"iat":            now.Unix(),
+		"nbf":            now.Unix(),
+	})
+}
+
+// ValidateAccessToken checks an access token for a caller that cannot verify
+// it on its own: the signature and use, that neither the token nor its session
+// were revoked, and that its principal (and impersonating actor) is active.
+func ValidateAccessToken(tokenString string) (jwt.MapClaims, error) {
+	tokenByte, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid || claims["token_use"] != AccessTokenUse {
+		return nil, ErrInvalidAccessToken
+	}
+
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return nil, ErrInvalidAccessToken
+	}
+
+	revoked, err := tokensRepo.IsAccessTokenRevoked(fmt.Sprint(claims["jti"]), subject, issuedAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// Tokens outside a session, e.g. from the client_credentials grant, have no sid
+	if sessionId, err := uuid.Parse(fmt.Sprint(claims["sid"])); err == nil && sessionId != uuid.Nil {
+		session, err := sessionRepo.FindSessionById(sessionId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err == nil && (session.RevokedAt != nil || session.SubjectID != subject) {
+			return nil, ErrInvalidAccessToken
+		}
+	}
+
+	if !PrincipalIsActive(subject, constants.PrincipalType(fmt.Sprint(claims["principal_type"]))) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	if actor, ok := ActorFromClaims(claims); ok && !PrincipalIsActive(actor.ID, actor.Type) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	return claims, nil
+}
+
+// ParseChallengeToken verifies a challenge token of the given use and returns
+// the principal it was issued to.
+func ParseChallengeToken(tokenString string, use string) (uuid.UUID, constants.PrincipalType, error) {
+	tokenByte, err := ParseToken(tokenString)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid || claims["token_use"] != use {
+		return uuid.Nil, "", ErrInvalidChallengeToken
+	}
+
+	subject, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidChallengeToken
+	}
+
+	return subject, constants.PrincipalType(fmt.Sprint(claims["principal_type"])), nil
+}
+
+// GenerateEmailVerificationToken signs the token of a verification link. It
+// names the address it was sent to, so it stops working if the email changes.
+func GenerateEmailVerificationToken(orgId uuid.UUID, email string) (string, error) {
+	now := time.Now().UTC()
+
+	return SignToken(jwt.MapClaims{
+		"iss":       Issuer(),
+		"sub":       orgId,
+		"jti":       uuid.New(),
+		"email":     email,
+		"token_use": EmailVerifyUse,
+		"exp":       now.Add(EmailVerificationTTL()).Unix(),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+	})
+}
+
+// ParseEmailVerificationToken verifies the token of a verification link and
+// returns the org and the address it was sent to.
+func ParseEmailVerificationToken(tokenString string) (uuid.UUID, string, error) {
+	tokenByte, err := ParseToken(tokenString)
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	claims, ok := tokenByte.Claims.(jwt.MapClaims)
+	if !ok || !tokenByte.Valid || claims["token_use"] != EmailVerifyUse {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	orgId, err := uuid.Parse(fmt.Sprint(claims["sub"]))
+	if err != nil {
+		return uuid.Nil, "", ErrInvalidVerificationToken
+	}
+
+	return orgId, fmt.Sprint(claims["email"]), nil
+}
+
+// GenerateOpaqueToken returns a random URL-safe token together with the hash
+// that should be persisted in its place.
+func GenerateOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokens mints an access token and a refresh token belonging to the
+// request's token family. Leave FamilyID empty to start a new family (i.e. a
+// fresh login), which is recorded as a new session.
+func IssueTokens(request TokenRequest) (TokenPair, error) {
+	now := time.Now()
+	expiresAt := now.Add(RefreshTokenTTL())
+
+	if request.FamilyID == uuid.Nil {
+		session, err := sessionRepo.CreateSession(model.Session{
+			SubjectID:   request.Subject,
+			SubjectType: request.SubjectType,
+			ClientID:    request.ClientID,
+			UserAgent:   truncateUserAgent(request.UserAgent),
+			IP:          request.IP,
+			AuthMethods: strings.Join(request.AuthMethods, " "),
+			LastSeenAt:  now,
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			return TokenPair{}, err
+		}
+		request.FamilyID = session.ID
+	} else if err := sessionRepo.ExtendSession(request.FamilyID, request.IP, expiresAt); err != nil {
+		return TokenPair{}, err
+	}
+
+	accessToken, err := GenerateAccessToken(request)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, refreshTokenHash, err := GenerateOpaqueToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	_, err = tokensRepo.CreateRefreshToken(model.RefreshToken{
+		TokenHash:   refreshTokenHash,
+		FamilyID:    request.FamilyID,
+		SubjectID:   request.Subject,
+		SubjectType: request.SubjectType,
+		ClientID:    request.ClientID,
+		Scope:       request.Scope,
+		AuthMethods: strings.Join(request.AuthMethods, " "),
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// IssueImpersonationToken mints an access token for the subject on behalf of
+// request.Actor. It gets its own session, so the user can see and end it, but
+// no refresh token: once it expires the actor has to start over.
+func IssueImpersonationToken(request TokenRequest) (TokenPair, error) {
+	now := time.Now()
+
+	session, err := sessionRepo.CreateSession(model.Session{
+		SubjectID:   request.Subject,
+		SubjectType: request.SubjectType,
+		ActorID:     request.Actor.ID,
+		UserAgent:   truncateUserAgent(request.UserAgent),
+		IP:          request.IP,
+		AuthMethods: strings.Join(request.AuthMethods, " "),
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(ImpersonationTTL()),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+	request.FamilyID = session.ID
+
+	accessToken, err := GenerateAccessToken(request)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(ImpersonationTTL().Seconds()),
+	}, nil
+}
+
+// ActorFromClaims returns the impersonating principal of an access token, if
+// the token was issued by IssueImpersonationToken.
+func ActorFromClaims(claims jwt.MapClaims) (Actor, bool) {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return Actor{}, false
+	}
+
+	id, err := uuid.Parse(fmt.Sprint(act["sub"]))
+	if err != nil {
+		return Actor{}, false
+	}
+
+	return Actor{ID: id, Type: constants.PrincipalType(fmt.Sprint(act["principal_type"]))}, true
+}
+
+// ConsumeRefreshToken validates a refresh token and marks it as used. The
+// caller is expected to issue the next pair in the same family.
+func ConsumeRefreshToken(refreshToken string) (model.RefreshToken, error) {
+	storedToken, err := tokensRepo.FindRefreshTokenByHash(HashToken(refreshToken))
+	if err != nil {
+		return storedToken, ErrInvalidRefreshToken
+	}
+
+	// A refresh token is single use. Seeing it again means it has leaked, so the
+	// whole family is revoked and every holder has to log in again.
+	if storedToken.UsedAt != nil || storedToken.RevokedAt != nil {
+		if err := RevokeSession(storedToken.FamilyID); err != nil {
+			return storedToken, err
+		}
+		return storedToken, ErrRefreshTokenReused
+	}
+
+	if storedToken.ExpiresAt.Before(time.Now()) {
+		return storedToken, ErrRefreshTokenExpired
+	}
+
+	marked, err := tokensRepo.MarkRefreshTokenUsed(storedToken.ID)
+	if err != nil {
+		return storedToken, err
+	}
+
+	if !marked {
+		RevokeSession(storedToken.FamilyID)
+		return storedToken, ErrRefreshTokenReused
+	}
+
+	if !PrincipalIsActive(storedToken.SubjectID, storedToken.SubjectType) {
+		RevokeSession(storedToken.FamilyID)
+		return storedToken, ErrPrincipalInactive
+	}
+
+	return storedToken, nil
+}
+
+// PrincipalIsActive reports whether the principal may still be issued tokens.
+// A LOCKED account stays active: the lock only guards its password login. An
+// org waiting for email verification or an invited user is not active yet.
+func PrincipalIsActive(id uuid.UUID, principalType constants.PrincipalType) bool {
+	switch principalType {
+	case constants.USER:
+		user, err := userRepo.FindUserByIdWithPassword(id)
+		if err != nil || user.AccountStatus == constants.DEACTIVATED || user.AccountStatus == constants.INVITED || (user.Org != nil && user.Org.AccountStatus == constants.DELETED) {
+			return false
+		}
+		return true
+	case constants.ORG:
+		org, err := orgRepo.FindOrgById(id)
+		return err == nil && org.AccountStatus != constants.DELETED && org.AccountStatus != constants.DEACTIVATED && org.AccountStatus != constants.PENDING_VERIFICATION
+	case constants.SERVICE_ACCOUNT:
+		serviceAccount, err := serviceAccountRepo.FindServiceAccountById(id)
+		if err != nil || serviceAccount.AccountStatus != constants.ACTIVATED || (serviceAccount.Org != nil && serviceAccount.Org.AccountStatus == constants.DELETED) {
+			return false
+		}
+		return true
+	}
+
+	return false
+}
+
+// IssuePasswordResetToken returns a new single-use password reset token for
+// the subject. Older unused tokens stay valid until one of them is used.
+func IssuePasswordResetToken(subject uuid.UUID, subjectType constants.PrincipalType) (string, error) {
+	token, tokenHash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tokensRepo.CreatePasswordResetToken(model.PasswordResetToken{
+		TokenHash:   tokenHash,
+		SubjectID:   subject,
+		SubjectType: subjectType,
+		ExpiresAt:   time.Now().Add(PasswordResetTTL()),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeAccessToken blocks a single access token until it would have expired.
+func RevokeAccessToken(jti string, subject uuid.UUID, expiresAt time.Time) error {
+	return tokensRepo.CreateRevokedToken(model.RevokedToken{
+		JTI:       jti,
+		SubjectID: subject,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// RevokeAllTokens invalidates every access and refresh token issued to the
+// subject so far, e.g. after a password change or deactivation.
+func RevokeAllTokens(subject uuid.UUID) error {
+	now := time.Now()
+
+	err := tokensRepo.UpsertSubjectRevocation(model.SubjectRevocation{
+		SubjectID: subject,
+		RevokedAt: now,
+		ExpiresAt: now.Add(AccessTokenTTL()),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sessionRepo.RevokeSessionsBySubject(subject); err != nil {
+		return err
+	}
+
+	return tokensRepo.RevokeRefreshTokensBySubject(subject)
+}
+
+// RevokeSession ends one login: its refresh tokens stop working and its
+// access tokens are rejected by CheckJWT.
+func RevokeSession(sessionId uuid.UUID) error {
+	if err := sessionRepo.RevokeSession(sessionId); err != nil {
+		return err
+	}
+
+	return tokensRepo.RevokeRefreshTokenFamily(sessionId)
+}
+
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgentLength {
+		return strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	return userAgent
+}